@@ -0,0 +1,92 @@
+// Copyright (c) 2016 NATS Messaging System
+package main
+
+import (
+	"fmt"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+	top "github.com/nats-io/nats-top/util"
+)
+
+// generateAccountsView returns the connections rolled up by account,
+// followed by the connections of the account being drilled into.
+func generateAccountsView(engine *top.Engine, stats *top.Stats) string {
+	var accounts []string
+	var systemAccount string
+	if stats.Accountz != nil {
+		accounts = stats.Accountz.Accounts
+		systemAccount = stats.Accountz.SystemAccount
+	}
+	hasAccountz := engine.Capabilities == nil || engine.Capabilities.HasEndpoint("/accountz")
+	if !hasAccountz && !top.HasAccounts(stats.ConnsExt) {
+		return unavailableEndpoint(engine, "/accountz")
+	}
+
+	groups := top.AccountConns(stats.Connz.Conns, stats.ConnsExt, stats.Rates.Connections, accounts)
+	top.SortGroups(groups, currentViewSettings().accountsSortOpt)
+	account := engine.Settings().Account
+
+	keySize := len("ACCOUNT") + DEFAULT_PADDING_SIZE
+	for _, group := range groups {
+		if size := len(group.Key) + DEFAULT_PADDING_SIZE; size > keySize {
+			keySize = size
+		}
+	}
+
+	var system string
+	if systemAccount != "" {
+		system = fmt.Sprintf(" (system: %s)", systemAccount)
+	}
+
+	// Second line is left empty for prompting options
+	text := fmt.Sprintf("Accounts: %d%s  Connections Polled: %d  %s\n\n",
+		len(groups), system, len(stats.Connz.Conns), stats.EndpointError("/accountz"))
+
+	accountHeader := DEFAULT_PADDING
+	accountHeader += "%-" + fmt.Sprintf("%d", keySize) + "s "
+	accountHeader += groupHeaderFormat + "\n"
+	text += fmt.Sprintf(accountHeader, "ACCOUNT",
+		"CONNS", "SUBS", "PENDING", "MSGS_TO", "MSGS_FROM", "BYTES_TO", "BYTES_FROM",
+		"MSGS_TO/S", "MSGS_FROM/S", "BYTES_TO/S", "BYTES_FROM/S")
+
+	accountValues := DEFAULT_PADDING
+	accountValues += "%-" + fmt.Sprintf("%d", keySize) + "s "
+	accountValues += groupRowFormat + "\n"
+
+	var selected *top.ConnGroup
+	for _, group := range groups {
+		text += fmt.Sprintf(accountValues, group.Key,
+			group.NumConns, group.NumSubs, top.Psize(int64(group.Pending)),
+			top.Psize(group.OutMsgs), top.Psize(group.InMsgs),
+			top.Psize(group.OutBytes), top.Psize(group.InBytes),
+			group.Rates.OutMsgsRate, group.Rates.InMsgsRate,
+			top.Psize(int64(group.Rates.OutBytesRate)), top.Psize(int64(group.Rates.InBytesRate)))
+
+		if group.Key == account {
+			selected = group
+		}
+	}
+
+	if account == "" {
+		return text
+	}
+
+	// Connections are requested for the account when the server
+	// supports it, otherwise only the polled ones are shown.
+	var conns []gnatsd.ConnInfo
+	ext := stats.ConnsExt
+	if stats.AccountConnz != nil {
+		conns = stats.AccountConnz.Conns
+		ext = stats.AccountConnsExt
+	} else if selected != nil {
+		conns = selected.Conns
+	}
+	var accountErr string
+	if err, ok := stats.Errors[top.AccountConnzPath]; ok {
+		accountErr = err.Error()
+	}
+	text += fmt.Sprintf("\nConnections in account %s: %d  %s\n", account, len(conns), accountErr)
+	text += accountConnsTable.generate(engine, conns, ext, rowsLeft(text))
+
+	return text
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2016 NATS Messaging System
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	top "github.com/nats-io/nats-top/util"
+)
+
+const (
+	// Chopped: CID STATE SUBS...
+	diffHeaderFormat = "%-6s  %-5s  %-8s  %-10s  %-10s  %-10s  %-10s  %-10s  %s\n"
+	diffRowFormat    = "%-6d  %-5s  %-8s  %-10s  %-10s  %-10s  %-10s  %-10s  %s\n"
+)
+
+// comparison has the stats frozen on screen and the ones marked to
+// be compared against, along with the live ones from polling and the
+// position in the scrollback when looking at older ones.
+type comparison struct {
+	sync.Mutex
+	live   *top.Stats
+	frozen *top.Stats
+	marked *top.Stats
+	back   uint64
+}
+
+// compared has the frozen and marked stats of the UI.
+var compared = &comparison{}
+
+// update takes the live stats and returns the ones to show.
+func (c *comparison) update(stats *top.Stats) *top.Stats {
+	c.Lock()
+	defer c.Unlock()
+	c.live = stats
+	return c.shownLocked()
+}
+
+// shown returns the stats to show, nil until polled once.
+func (c *comparison) shown() *top.Stats {
+	c.Lock()
+	defer c.Unlock()
+	return c.shownLocked()
+}
+
+func (c *comparison) shownLocked() *top.Stats {
+	if c.frozen != nil {
+		return c.frozen
+	}
+	if c.back != 0 {
+		var stats *top.Stats
+		c.back, stats = scrollback.At(c.back)
+		return stats
+	}
+	return c.live
+}
+
+// toggleFreeze keeps showing the current stats while polling
+// goes on, or goes back to showing the live ones.
+func (c *comparison) toggleFreeze() {
+	c.Lock()
+	defer c.Unlock()
+	if c.frozen != nil {
+		c.frozen = nil
+	} else {
+		c.frozen = c.shownLocked()
+	}
+}
+
+// toggleMark marks the stats being shown to compare against
+// the live ones later, or clears the mark.
+func (c *comparison) toggleMark() {
+	c.Lock()
+	defer c.Unlock()
+	if c.marked != nil {
+		c.marked = nil
+	} else {
+		c.marked = c.shownLocked()
+	}
+}
+
+// diff returns the changes from the marked stats, or else from the
+// frozen ones, to the live ones, along with which of the two they are.
+// It returns nil when there are neither.
+func (c *comparison) diff() (*top.StatsDiff, string) {
+	c.Lock()
+	defer c.Unlock()
+	if c.live == nil {
+		return nil, ""
+	}
+	if c.marked != nil {
+		return top.DiffStats(c.marked, c.live), "marked"
+	}
+	if c.frozen != nil {
+		return top.DiffStats(c.frozen, c.live), "frozen"
+	}
+	return nil, ""
+}
+
+// status returns what is shown in the header while frozen or marked.
+func (c *comparison) status() string {
+	c.Lock()
+	defer c.Unlock()
+	var status string
+	if c.frozen != nil {
+		status += " FROZEN " + c.frozen.Varz.Now.Local().Format("15:04:05")
+	}
+	if c.marked != nil {
+		status += " MARKED " + c.marked.Varz.Now.Local().Format("15:04:05")
+	}
+	if scrollback != nil && c.frozen == nil {
+		status += " " + c.scrollStatusLocked()
+	}
+	return status
+}
+
+// psizeDelta returns the size with its sign.
+func psizeDelta(d int64) string {
+	if d < 0 {
+		return "-" + top.Psize(-d)
+	}
+	return "+" + top.Psize(d)
+}
+
+// generateDiffView returns the server wide and per connection changes
+// between the marked or frozen stats and the live ones.
+func generateDiffView(engine *top.Engine, stats *top.Stats) string {
+	diff, base := compared.diff()
+	if diff == nil {
+		return "Nothing to compare: press 'm' to mark the stats, or 'f' to freeze them,\n" +
+			"then the changes up to the live stats are shown here.\n"
+	}
+
+	elapsed := diff.To.Sub(diff.From) / time.Millisecond * time.Millisecond
+	text := fmt.Sprintf("Comparing %s (%s) to %s (live), %s apart  %s\n",
+		diff.From.Local().Format("15:04:05"), base, diff.To.Local().Format("15:04:05"),
+		elapsed, stats.Error)
+	text += fmt.Sprintf("  In:   Msgs: %s  Bytes: %s\n", psizeDelta(diff.InMsgs), psizeDelta(diff.InBytes))
+	text += fmt.Sprintf("  Out:  Msgs: %s  Bytes: %s\n", psizeDelta(diff.OutMsgs), psizeDelta(diff.OutBytes))
+	text += fmt.Sprintf("  Conns: %+d (total: %+d)  Subs: %+d  Slow Consumers: %+d  Memory: %s\n\n",
+		diff.NumConns, diff.TotalConns, diff.Subs, diff.SlowConsumers, psizeDelta(diff.Mem))
+
+	top.SortConnDeltas(diff.Conns, engine.Settings().SortOpt)
+
+	text += fmt.Sprintf(DEFAULT_PADDING+diffHeaderFormat, "CID", "STATE", "SUBS", "PENDING",
+		"MSGS_TO", "MSGS_FROM", "BYTES_TO", "BYTES_FROM", "HOST")
+
+	// Rows below the screen would not be visible
+	deltas := diff.Conns
+	if rows := rowsLeft(text); rows < len(deltas) {
+		deltas = deltas[:rows]
+	}
+	for _, delta := range deltas {
+		text += fmt.Sprintf(DEFAULT_PADDING+diffRowFormat, delta.Conn.Cid, delta.State,
+			fmt.Sprintf("%+d", delta.NumSubs), psizeDelta(delta.Pending),
+			psizeDelta(delta.OutMsgs), psizeDelta(delta.InMsgs),
+			psizeDelta(delta.OutBytes), psizeDelta(delta.InBytes),
+			resolveHost(delta.Conn))
+	}
+
+	return text
+}
@@ -0,0 +1,40 @@
+// Copyright (c) 2016 NATS Messaging System
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+
+	top "github.com/nats-io/nats-top/util"
+)
+
+// runDashboard polls the server and pushes the stats to the
+// browsers connected to the dashboard instead of the top view.
+func runDashboard(engine *top.Engine, addr string) error {
+	dashboard := top.NewDashboard()
+
+	go monitorStats(engine)
+	go func() {
+		for stats := range engine.StatsCh {
+			if err := dashboard.Publish(stats); err != nil {
+				log.Printf("nats-top: %s", err)
+			}
+		}
+	}()
+
+	addr = loopbackAddr(addr)
+	log.Printf("nats-top: serving dashboard at http://%s", addr)
+	return http.ListenAndServe(addr, dashboard.Handler())
+}
+
+// loopbackAddr returns the address on the loopback interface unless
+// another host is given, e.g. 0.0.0.0, since browsers reaching the
+// dashboard are not authenticated.
+func loopbackAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2016 NATS Messaging System
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+	top "github.com/nats-io/nats-top/util"
+)
+
+const (
+	// Chopped: RID REMOTE_ID...
+	routesHeaderFormat = "%-6s  %-24s  %-21s  %-9s  %-10s  %-7s  %-10s  %-10s  %-10s  %-10s  %-10s\n"
+	routesRowFormat    = "%-6d  %-24s  %-21s  %-9t  %-10t  %-7d  %-10s  %-10s  %-10s  %-10s  %-10s\n"
+)
+
+// generateServerInfo returns all the info about the server from
+// the latest stats, used by the info view and the info command.
+func generateServerInfo(engine *top.Engine, stats *top.Stats) string {
+	varz := stats.Varz
+	varzExt := &top.VarzExt{}
+	if stats.VarzExt != nil {
+		varzExt = stats.VarzExt
+	}
+
+	info := &gnatsd.Info{}
+	if varz.Info != nil {
+		info = varz.Info
+	}
+	opts := &gnatsd.Options{}
+	if varz.Options != nil {
+		opts = varz.Options
+	}
+
+	// CPU is reported as a percentage of a single core
+	var cpuPerCore float64
+	if varz.Cores > 0 {
+		cpuPerCore = varz.CPU / float64(varz.Cores)
+	}
+
+	text := fmt.Sprintf("NATS server info %s\n\n", stats.Error)
+	text += fmt.Sprintf("  Server ID:        %s\n", info.ID)
+	if varzExt.ServerName != "" {
+		text += fmt.Sprintf("  Server Name:      %s\n", varzExt.ServerName)
+	}
+	if len(varzExt.Tags) > 0 {
+		text += fmt.Sprintf("  Tags:             %s\n", strings.Join(varzExt.Tags, ", "))
+	}
+	text += fmt.Sprintf("  Version:          %s  (go: %s)\n", info.Version, info.GoVersion)
+	if engine.Capabilities != nil {
+		endpoints := make([]string, 0, len(engine.Capabilities.Endpoints))
+		for path := range engine.Capabilities.Endpoints {
+			endpoints = append(endpoints, path)
+		}
+		sort.Strings(endpoints)
+		text += fmt.Sprintf("  Endpoints:        %s\n", strings.Join(endpoints, " "))
+	}
+	text += fmt.Sprintf("  Host:             %s  Port: %d\n", info.Host, varz.Port)
+	text += fmt.Sprintf("  Auth Required:    %t  TLS Required: %t  TLS Verify: %t\n",
+		info.AuthRequired, info.TLSRequired, info.TLSVerify)
+	text += fmt.Sprintf("  Max Payload:      %s  Max Connections: %d  Max Pending: %s  Max Control Line: %d\n",
+		top.Psize(int64(varz.MaxPayload)), opts.MaxConn, top.Psize(int64(opts.MaxPending)), opts.MaxControlLine)
+	text += fmt.Sprintf("  Monitoring:       Host: %s  HTTP Port: %d  HTTPS Port: %d\n",
+		opts.HTTPHost, opts.HTTPPort, opts.HTTPSPort)
+	text += fmt.Sprintf("  Start:            %s  (uptime: %s)\n", varz.Start, varz.Uptime)
+	if !varzExt.ConfigLoadTime.IsZero() {
+		text += fmt.Sprintf("  Config Load Time: %s\n", varzExt.ConfigLoadTime)
+	}
+	text += fmt.Sprintf("  Cores:            %d\n", varz.Cores)
+	text += fmt.Sprintf("  CPU:              %.1f%%  (%.1f%% per core)\n", varz.CPU, cpuPerCore)
+	text += fmt.Sprintf("  Memory:           %s\n", top.Psize(varz.Mem))
+	text += fmt.Sprintf("  Connections:      %d  Total: %d  New/Sec: %.1f\n",
+		varz.Connections, varz.TotalConnections, stats.Rates.NewConnsRate)
+	text += fmt.Sprintf("  Routes:           %d  Remotes: %d  Leafnodes: %d\n", varz.Routes, varz.Remotes, varzExt.Leafnodes)
+	text += fmt.Sprintf("  Subscriptions:    %d\n", varz.Subscriptions)
+	if varzExt.SystemAccount != "" {
+		text += fmt.Sprintf("  System Account:   %s\n", varzExt.SystemAccount)
+	}
+	text += fmt.Sprintf("  Slow Consumers:   %d", varz.SlowConsumers)
+	if sc := varzExt.SlowConsumersStats; sc != nil {
+		text += fmt.Sprintf("  (clients: %d, routes: %d, gateways: %d, leafs: %d)",
+			sc.Clients, sc.Routes, sc.Gateways, sc.Leafs)
+	}
+	text += "\n"
+	text += fmt.Sprintf("  In:               Msgs: %s  Bytes: %s  Msgs/Sec: %.1f  Bytes/Sec: %s\n",
+		top.Psize(varz.InMsgs), top.Psize(varz.InBytes),
+		stats.Rates.InMsgsRate, top.Psize(int64(stats.Rates.InBytesRate)))
+	text += fmt.Sprintf("  Out:              Msgs: %s  Bytes: %s  Msgs/Sec: %.1f  Bytes/Sec: %s\n",
+		top.Psize(varz.OutMsgs), top.Psize(varz.OutBytes),
+		stats.Rates.OutMsgsRate, top.Psize(int64(stats.Rates.OutBytesRate)))
+
+	// Requests to the monitoring endpoints, sorted by path
+	paths := make([]string, 0, len(varz.HTTPReqStats))
+	for path := range varz.HTTPReqStats {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	// Rates are not available when fetching the info only once
+	text += "\nHTTP Requests:\n"
+	for _, path := range paths {
+		text += fmt.Sprintf("  %-16s  %-10d", path, varz.HTTPReqStats[path])
+		if stats.Rates.HTTPReqRates != nil {
+			text += fmt.Sprintf("  %.1f/s", stats.Rates.HTTPReqRates[path])
+		}
+		text += "\n"
+	}
+
+	if stats.Routez == nil {
+		return text
+	}
+
+	text += fmt.Sprintf("\nRoutes: %d\n", stats.Routez.NumRoutes)
+	if len(stats.Routez.Routes) == 0 {
+		return text
+	}
+	text += fmt.Sprintf("  "+routesHeaderFormat, "RID", "REMOTE_ID", "ADDRESS", "SOLICITED", "CONFIGURED",
+		"SUBS", "PENDING", "MSGS_TO", "MSGS_FROM", "BYTES_TO", "BYTES_FROM")
+	for _, route := range stats.Routez.Routes {
+		text += fmt.Sprintf("  "+routesRowFormat, route.Rid, route.RemoteID,
+			fmt.Sprintf("%s:%d", route.IP, route.Port), route.DidSolicit, route.IsConfigured,
+			route.NumSubs, top.Psize(int64(route.Pending)),
+			top.Psize(route.OutMsgs), top.Psize(route.InMsgs),
+			top.Psize(route.OutBytes), top.Psize(route.InBytes))
+	}
+
+	return text
+}
+
+// runInfo fetches the server info once and prints it.
+func runInfo(engine *top.Engine) error {
+	stats := &top.Stats{
+		Routez: &gnatsd.Routez{},
+		Rates:  &top.Rates{},
+		Error:  fmt.Errorf(""),
+	}
+
+	body, err := engine.Fetch("/varz")
+	if err != nil {
+		return err
+	}
+	result, err := top.Decode("/varz", body)
+	if err != nil {
+		return err
+	}
+	varz, ok := result.(*gnatsd.Varz)
+	if !ok {
+		return fmt.Errorf("could not get /varz from server")
+	}
+	stats.Varz = varz
+	stats.VarzExt = top.DecodeVarzExt(body)
+
+	result, err = engine.Request("/routez")
+	if err != nil {
+		return err
+	}
+	if routez, ok := result.(*gnatsd.Routez); ok {
+		stats.Routez = routez
+	}
+
+	fmt.Print(generateServerInfo(engine, stats))
+	return nil
+}
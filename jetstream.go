@@ -0,0 +1,96 @@
+// Copyright (c) 2016 NATS Messaging System
+package main
+
+import (
+	"fmt"
+
+	top "github.com/nats-io/nats-top/util"
+)
+
+const (
+	// Chopped: ACCOUNT STREAM...
+	streamsHeaderFormat = "%-10s  %-10s  %-10s  %-10s  %-9s  %-10s  %-10s"
+	streamsRowFormat    = "%-10s  %-10s  %-10d  %-10d  %-9d  %-10.1f  %-10s"
+
+	// Chopped: ACCOUNT STREAM CONSUMER...
+	consumersHeaderFormat = "%-10s  %-11s  %-11s  %-7s  %-11s  %-11s  %-14s  %-8s"
+	consumersRowFormat    = "%-10d  %-11d  %-11d  %-7d  %-11d  %-11d  %-14.1f  %-8.1f"
+)
+
+// generateJetStreamView returns the JetStream usage of the server
+// followed by the tables with the streams and consumers per account.
+func generateJetStreamView(engine *top.Engine, stats *top.Stats) string {
+	if engine.Capabilities != nil && !engine.Capabilities.HasEndpoint("/jsz") {
+		return unavailableEndpoint(engine, "/jsz")
+	}
+	jsz := stats.Jsz
+	if jsz == nil {
+		jsz = &top.JSInfo{}
+	}
+	if jsz.Disabled {
+		return "JetStream is disabled in this server\n"
+	}
+
+	// Second line is left empty for prompting options
+	text := fmt.Sprintf("JetStream: Accounts: %d  Streams: %d  Consumers: %d  Messages: %s  Bytes: %s  Memory: %s  Storage: %s  %s\n\n",
+		jsz.Accounts, jsz.Streams, jsz.Consumers, top.Psize(int64(jsz.Messages)), top.Psize(int64(jsz.Bytes)),
+		top.Psize(int64(jsz.Memory)), top.Psize(int64(jsz.Storage)), stats.EndpointError("/jsz"))
+
+	sortOpt := currentViewSettings().jsSortOpt
+	streams := top.JSStreams(jsz)
+	top.SortStreams(streams, stats.Rates.Streams, sortOpt)
+	consumers := top.JSConsumers(jsz)
+	top.SortConsumers(consumers, stats.Rates.Consumers, sortOpt)
+
+	accountSize := len("ACCOUNT") + DEFAULT_PADDING_SIZE
+	streamSize := len("STREAM") + DEFAULT_PADDING_SIZE
+	consumerSize := len("CONSUMER") + DEFAULT_PADDING_SIZE
+	for _, stream := range streams {
+		if size := len(stream.Account) + DEFAULT_PADDING_SIZE; size > accountSize {
+			accountSize = size
+		}
+		if size := len(stream.Name) + DEFAULT_PADDING_SIZE; size > streamSize {
+			streamSize = size
+		}
+	}
+	for _, consumer := range consumers {
+		if size := len(consumer.Name) + DEFAULT_PADDING_SIZE; size > consumerSize {
+			consumerSize = size
+		}
+	}
+	prefix := DEFAULT_PADDING
+	prefix += "%-" + fmt.Sprintf("%d", accountSize) + "s "
+	prefix += "%-" + fmt.Sprintf("%d", streamSize) + "s "
+
+	text += fmt.Sprintf("Streams: %d (sort by: %s)\n", len(streams), sortOpt)
+	text += fmt.Sprintf(prefix+streamsHeaderFormat+"\n", "ACCOUNT", "STREAM",
+		"MSGS", "BYTES", "FIRST_SEQ", "LAST_SEQ", "CONSUMERS", "MSGS/S", "BYTES/S")
+	for _, stream := range streams {
+		rates := stats.Rates.Streams[stream.Key()]
+		if rates == nil {
+			rates = &top.StreamRates{}
+		}
+		state := stream.State
+		text += fmt.Sprintf(prefix+streamsRowFormat+"\n", stream.Account, stream.Name,
+			top.Psize(int64(state.Msgs)), top.Psize(int64(state.Bytes)),
+			state.FirstSeq, state.LastSeq, state.Consumers,
+			rates.MsgsRate, top.Psize(int64(rates.BytesRate)))
+	}
+
+	consumerPrefix := prefix + "%-" + fmt.Sprintf("%d", consumerSize) + "s "
+	text += fmt.Sprintf("\nConsumers: %d\n", len(consumers))
+	text += fmt.Sprintf(consumerPrefix+consumersHeaderFormat+"\n", "ACCOUNT", "STREAM", "CONSUMER",
+		"PENDING", "ACK_PENDING", "REDELIVERED", "WAITING", "DELIVERED", "ACK_FLOOR", "DELIVERED/S", "ACK/S")
+	for _, consumer := range consumers {
+		rates := stats.Rates.Consumers[consumer.Key()]
+		if rates == nil {
+			rates = &top.ConsumerRates{}
+		}
+		text += fmt.Sprintf(consumerPrefix+consumersRowFormat+"\n", consumer.Account, consumer.Stream, consumer.Name,
+			consumer.NumPending, consumer.NumAckPending, consumer.NumRedelivered, consumer.NumWaiting,
+			consumer.Delivered.Stream, consumer.AckFloor.Stream,
+			rates.DeliveredRate, rates.AckRate)
+	}
+
+	return text
+}
@@ -2,12 +2,15 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"log"
-	"net"
 	"os"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	gnatsd "github.com/nats-io/gnatsd/server"
@@ -21,10 +24,24 @@ var (
 	host        = flag.String("s", "127.0.0.1", "The nats server host.")
 	port        = flag.Int("m", 8222, "The NATS server monitoring port.")
 	conns       = flag.Int("n", 1024, "Maximum number of connections to poll.")
-	delay       = flag.Int("d", 1, "Refresh interval in seconds.")
+	delay       = flag.String("d", "1s", "Refresh interval, e.g. 250ms or 5s, in seconds when only a number.")
+	adaptive    = flag.Bool("adaptive", false, "Back off the refresh interval in case the server gets slow to respond to /connz.")
 	sortBy      = flag.String("sort", "cid", "Value for which to sort by the connections.")
 	showVersion = flag.Bool("v", false, "Show nats-top version.")
 	lookupDNS   = flag.Bool("lookup", false, "Enable client addresses DNS lookup.")
+	groupBy     = flag.String("group", "", "Value for which to group the connections.")
+	rates       = flag.String("rates", "instant", "Averaging for the rates: instant, ewma, 1m, 5m or 15m.")
+	displayAuth = flag.Bool("auth", false, "Request auth details and show the user and TLS of the connections.")
+	displaySubs = flag.Bool("subs", false, "Request the subscriptions of the connections and show them.")
+	minVersion  = flag.String("min-versions", "", "Minimum version of the client libraries by language, e.g. go=1.31.0,java=2.17.0.")
+	httpAddr    = flag.String("http", "", "Serve a web dashboard at the address instead of the top view, e.g. :8080 on the loopback interface.")
+	listenAddr  = flag.String("listen", "127.0.0.1:8282", "Address at which the serve command streams the snapshots, without any authentication.")
+	attachAddr  = flag.String("attach", "", "Get the snapshots from a nats-top serve daemon at host:port instead of polling the server.")
+	recordFile  = flag.String("record", "", "Record the responses from the server to a file, one json snapshot per line.")
+	recordGzip  = flag.Bool("record-gzip", false, "Compress the recording with gzip.")
+	recordSize  = flag.Int("record-max-size", 0, "Rotate the recording once it is larger than the size in MB, never when 0.")
+	replayFile  = flag.String("replay", "", "Replay a recording made with -record instead of polling the server.")
+	scrollSize  = flag.Int("scrollback", top.DefaultScrollbackSize, "Number of recent stats kept to scroll back through with the arrow keys, disabled when 0.")
 
 	// Secure options
 	httpsPort     = flag.Int("ms", 0, "The NATS server secure monitoring port.")
@@ -48,26 +65,93 @@ var (
 	defaultHeaderFormat = "%-6s  %-10s  %-10s  %-10s  %-10s  %-10s  %-7s  %-7s  %-7s  %-40s"
 	defaultRowFormat    = "%-6d  %-10s  %-10s  %-10s  %-10s  %-10s  %-7s  %-7s  %-7s  %-40s"
 
+	// Chopped: KEY...
+	groupHeaderFormat = "%-7s  %-7s  %-10s  %-10s  %-10s  %-10s  %-10s  %-11s  %-13s  %-12s  %-14s"
+	groupRowFormat    = "%-7d  %-7d  %-10s  %-10s  %-10s  %-10s  %-10s  %-11.1f  %-13.1f  %-12s  %-14s"
+
+	// Chopped: HOST CID NAME...
+	talkersHeaderFormat = "%-10s  %-10s  %-10s  %-10s  %-11s  %-13s  %-12s  %-14s  %-7s  %-7s"
+	talkersRowFormat    = "%-10s  %-10s  %-10s  %-10s  %-11.1f  %-13.1f  %-12s  %-14s  %-7s  %-7s"
+
 	usageHelp = `
-usage: nats-top [-s server] [-m http_port] [-ms https_port] [-n num_connections] [-d delay_secs] [-sort by]
-                [-cert FILE] [-key FILE ][-cacert FILE] [-k]
+usage: nats-top [-s server] [-m http_port] [-ms https_port] [-n num_connections] [-d delay] [-adaptive]
+                [-sort by] [-group by] [-rates mode] [-auth] [-subs] [-min-versions lang=version,...]
+                [-http addr] [-listen addr] [-attach host:port]
+                [-record FILE] [-record-gzip] [-record-max-size MB] [-replay FILE]
+                [-scrollback N] [-cert FILE] [-key FILE ][-cacert FILE] [-k] [command]
+
+commands:
+    info    Show all the info from the server and exit.
+    audit   Show the client library versions and exit,
+            with an error in case there are outdated ones.
+    serve   Poll the server once for all the instances of
+            nats-top attached to the daemon with -attach.
+            Snapshots are streamed without authentication to
+            anyone reaching the -listen address, including the
+            auth details of the connections with -auth.
 
 `
-	// cache for reducing DNS lookups in case enabled
-	resolvedHosts = map[string]string{}
+	// reverse DNS lookups in the background in case enabled
+	resolver = top.NewResolver()
 )
 
+// viewSettings are the options of the views which are changed from the
+// event loop while the views are generated in the update goroutine, so
+// they are only accessed through currentViewSettings and updateViewSettings.
+type viewSettings struct {
+	// grouping of the connections, with its sort option and expanded group
+	groupBy       top.GroupByOpt
+	groupSortOpt  top.GroupSortOpt
+	expandedGroup string
+
+	// averaging of the rates displayed next to the instantaneous ones
+	rateMode top.RateMode
+
+	// sliding window and sort option for top talkers, disabled when zero
+	talkersWindow  time.Duration
+	talkersSortOpt top.TalkersSortOpt
+
+	// sort options of the JetStream and accounts views
+	jsSortOpt       top.JSSortOpt
+	accountsSortOpt top.GroupSortOpt
+}
+
+var (
+	viewsMu sync.Mutex
+	views   = viewSettings{
+		groupSortOpt:    top.GroupSortByConns,
+		rateMode:        top.RateModeInstant,
+		talkersSortOpt:  top.TalkersSortByOutBytes,
+		jsSortOpt:       top.JSSortByAccount,
+		accountsSortOpt: top.GroupSortByConns,
+	}
+)
+
+// currentViewSettings returns a copy of the options of the views.
+func currentViewSettings() viewSettings {
+	viewsMu.Lock()
+	defer viewsMu.Unlock()
+	return views
+}
+
+// updateViewSettings changes the options of the views with fn.
+func updateViewSettings(fn func(v *viewSettings)) {
+	viewsMu.Lock()
+	defer viewsMu.Unlock()
+	fn(&views)
+}
+
 func usage() {
-	log.Fatalf(usageHelp)
+	log.Fatal(usageHelp)
 }
 
 func init() {
 	log.SetFlags(0)
 	flag.Usage = usage
-	flag.Parse()
 }
 
 func main() {
+	flag.Parse()
 
 	if *showVersion {
 		log.Printf("nats-top v%s", version)
@@ -76,18 +160,25 @@ func main() {
 
 	var engine *top.Engine
 
+	interval, err := top.ParseDelay(*delay)
+	if err != nil {
+		log.Printf("nats-top: %s", err)
+		usage()
+	}
+
 	// Use secure port if set explicitly, otherwise use http port by default
 	if *httpsPort != 0 {
-		engine = top.NewEngine(*host, *httpsPort, *conns, *delay)
+		engine = top.NewEngine(*host, *httpsPort, *conns, 0)
 		err := engine.SetupHTTPS(*caCertOpt, *certOpt, *keyOpt, *skipVerifyOpt)
 		if err != nil {
 			log.Printf("nats-top: %s", err)
 			usage()
 		}
 	} else {
-		engine = top.NewEngine(*host, *port, *conns, *delay)
+		engine = top.NewEngine(*host, *port, *conns, 0)
 		engine.SetupHTTP()
 	}
+	engine.Interval = interval
 
 	if engine.Host == "" {
 		log.Printf("nats-top: invalid monitoring endpoint")
@@ -99,13 +190,74 @@ func main() {
 		usage()
 	}
 
-	// Smoke test to abort in case can't connect to server since the beginning.
-	_, err := engine.Request("/varz")
+	engine.DisplayAuth = *displayAuth
+	engine.DisplaySubs = *displaySubs
+	engine.AdaptiveDelay = *adaptive
+	setDNSLookup(*lookupDNS)
+
+	if *attachAddr != "" || *replayFile != "" {
+		// The daemon polls the server instead, or the snapshots are
+		// recorded, and the capabilities are taken from the snapshots.
+		engine.HttpClient = nil
+		if flag.Arg(0) != "" {
+			log.Printf("nats-top: %s command is not available when attached or replaying", flag.Arg(0))
+			usage()
+		}
+		if *replayFile != "" {
+			rec, err := top.OpenRecording(*replayFile)
+			if err != nil {
+				log.Printf("nats-top: %s", err)
+				usage()
+			}
+			player = top.NewPlayer(rec)
+		}
+	} else {
+		// Smoke test to abort in case can't connect to server since the beginning.
+		_, err = engine.Request("/varz")
+		if err != nil {
+			log.Printf("nats-top: %s", err)
+			usage()
+		}
+
+		// Detect which fields and endpoints the server supports
+		err = engine.SetupCapabilities()
+		if err != nil {
+			log.Printf("nats-top: %s", err)
+			usage()
+		}
+	}
+
+	minVersions, err = top.ParseMinVersions(*minVersion)
 	if err != nil {
 		log.Printf("nats-top: %s", err)
 		usage()
 	}
 
+	// Commands which do not start the top view
+	switch flag.Arg(0) {
+	case "":
+	case "serve":
+		setupRecorder(engine)
+		log.Fatalf("nats-top: %s", runServe(engine, *listenAddr))
+	case "info":
+		if err := runInfo(engine); err != nil {
+			log.Fatalf("nats-top: %s", err)
+		}
+		os.Exit(0)
+	case "audit":
+		outdated, err := runAudit(engine)
+		if err != nil {
+			log.Fatalf("nats-top: %s", err)
+		}
+		if outdated {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	default:
+		log.Printf("nats-top: unknown command: %s", flag.Arg(0))
+		usage()
+	}
+
 	sortOpt := gnatsd.SortOpt(*sortBy)
 	if !sortOpt.IsValid() {
 		log.Fatalf("nats-top: invalid option to sort by: %s\n", sortOpt)
@@ -113,16 +265,56 @@ func main() {
 	}
 	engine.SortOpt = sortOpt
 
+	groupOpt := top.GroupByOpt(*groupBy)
+	if !groupOpt.IsValid() {
+		log.Fatalf("nats-top: invalid option to group by: %s\n", groupOpt)
+		usage()
+	}
+	views.groupBy = groupOpt
+
+	rateMode, err := top.ParseRateMode(*rates)
+	if err != nil {
+		log.Fatalf("nats-top: %s\n", err)
+		usage()
+	}
+	views.rateMode = rateMode
+
+	setupRecorder(engine)
+
+	// Web dashboard replaces the top view
+	if *httpAddr != "" {
+		log.Fatalf("nats-top: %s", runDashboard(engine, *httpAddr))
+	}
+
+	// Recordings are scrolled through with the playback controls
+	if player == nil && *scrollSize > 0 {
+		scrollback = top.NewScrollback(*scrollSize)
+		engine.Scrollback = scrollback
+	}
+
 	err = ui.Init()
 	if err != nil {
 		panic(err)
 	}
 	defer ui.Close()
 
-	go engine.MonitorStats()
+	go monitorStats(engine)
 	StartUI(engine)
 }
 
+// setupRecorder starts recording the snapshots to a file, if enabled.
+func setupRecorder(engine *top.Engine) {
+	if *recordFile == "" {
+		return
+	}
+	recorder, err := top.NewRecorder(*recordFile, *recordGzip, int64(*recordSize)*1024*1024)
+	if err != nil {
+		log.Printf("nats-top: %s", err)
+		usage()
+	}
+	engine.Recorder = recorder
+}
+
 // clearScreen tries to ensure resetting original state of screen
 func clearScreen() {
 	fmt.Print("\033[2J\033[1;1H\033[?25l")
@@ -164,6 +356,9 @@ func generateParagraph(
 	if stats.Varz.Info != nil {
 		serverVersion = stats.Varz.Info.Version
 	}
+	if stats.VarzExt != nil && stats.VarzExt.ServerName != "" {
+		serverVersion += fmt.Sprintf(" [%s]", stats.VarzExt.ServerName)
+	}
 
 	mem := top.Psize(memVal)
 	inMsgs := top.Psize(inMsgsVal)
@@ -175,163 +370,283 @@ func generateParagraph(
 	inBytesRate := top.Psize(int64(stats.Rates.InBytesRate))
 	outBytesRate := top.Psize(int64(stats.Rates.OutBytesRate))
 
-	info := "NATS server version %s (uptime: %s) %s"
-	info += "\nServer:\n  Load: CPU:  %.1f%%  Memory: %s  Slow Consumers: %d\n"
-	info += "  In:   Msgs: %s  Bytes: %s  Msgs/Sec: %.1f  Bytes/Sec: %s\n"
-	info += "  Out:  Msgs: %s  Bytes: %s  Msgs/Sec: %.1f  Bytes/Sec: %s"
+	// Smoothed rates are shown next to the instantaneous ones
+	var inMsgsAvg, inBytesAvg, outMsgsAvg, outBytesAvg string
+	settings := currentViewSettings()
+	rateMode := settings.rateMode
+	if avg, ok := stats.Rates.Averages[rateMode]; ok && rateMode != top.RateModeInstant {
+		inMsgsAvg = fmt.Sprintf(" (%s: %.1f)", rateMode, avg.InMsgsRate)
+		inBytesAvg = fmt.Sprintf(" (%s: %s)", rateMode, top.Psize(int64(avg.InBytesRate)))
+		outMsgsAvg = fmt.Sprintf(" (%s: %.1f)", rateMode, avg.OutMsgsRate)
+		outBytesAvg = fmt.Sprintf(" (%s: %s)", rateMode, top.Psize(int64(avg.OutBytesRate)))
+	}
 
-	text := fmt.Sprintf(info, serverVersion, uptime, stats.Error,
-		cpu, mem, slowConsumers,
-		inMsgs, inBytes, inMsgsRate, inBytesRate,
-		outMsgs, outBytes, outMsgsRate, outBytesRate)
-	text += fmt.Sprintf("\n\nConnections Polled: %d\n", numConns)
-	displaySubs := engine.DisplaySubs
+	// Make new slow consumers from last interval stand out
+	var newSlowConsumers string
+	if stats.Rates.NewSlowConsumers > 0 {
+		newSlowConsumers = fmt.Sprintf(" (+%d NEW)", stats.Rates.NewSlowConsumers)
+	}
 
-	// Dynamically add columns and padding depending
-	header := make([]interface{}, 0)
-	hostSize := DEFAULT_HOST_PADDING_SIZE
+	// Requests to the monitoring endpoints, sorted by path
+	paths := make([]string, 0, len(stats.Rates.HTTPReqRates))
+	for path := range stats.Rates.HTTPReqRates {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var httpReqRates string
+	for _, path := range paths {
+		httpReqRates += fmt.Sprintf("  %s: %.1f/s", path, stats.Rates.HTTPReqRates[path])
+	}
 
-	// Disable name unless we have seen one using it
-	nameSize := 0
-	for _, conn := range stats.Connz.Conns {
-		var size int
-
-		var hostname string
-		if *lookupDNS {
-			// Make a lookup for each one of the ips and memoize
-			// them for subsequent polls.
-			if addr, present := resolvedHosts[conn.IP]; !present {
-				addrs, err := net.LookupAddr(conn.IP)
-				if err == nil && len(addrs) > 0 && len(addrs[0]) > 0 {
-					hostname = addrs[0]
-					resolvedHosts[conn.IP] = hostname
-				} else {
-					// Otherwise just continue to use ip:port as resolved host
-					// can be an empty string even though there were no errors.
-					hostname = fmt.Sprintf("%s:%d", conn.IP, conn.Port)
-					resolvedHosts[conn.IP] = hostname
-				}
-			} else {
-				hostname = addr
-			}
-		} else {
-			hostname = fmt.Sprintf("%s:%d", conn.IP, conn.Port)
-		}
+	info := "NATS server version %s (uptime: %s%s) %s"
+	info += "\nServer:\n  Load: CPU:  %.1f%%  Memory: %s  Slow Consumers: %d%s\n"
+	info += "  In:   Msgs: %s  Bytes: %s  Msgs/Sec: %.1f%s  Bytes/Sec: %s%s\n"
+	info += "  Out:  Msgs: %s  Bytes: %s  Msgs/Sec: %.1f%s  Bytes/Sec: %s%s\n"
+	info += "  Conns: Total: %d  New/Sec: %.1f  Subs: %d (%+d)\n"
+	info += "  HTTP:%s"
+
+	// Timeline of the replay next to the errors
+	status := fmt.Sprint(stats.Error)
+	if stats.Playback != nil {
+		status += " " + generatePlayback(stats.Playback)
+	}
+	status += compared.status()
+
+	text := fmt.Sprintf(info, serverVersion, uptime, generateRefresh(engine, stats), status,
+		cpu, mem, slowConsumers, newSlowConsumers,
+		inMsgs, inBytes, inMsgsRate, inMsgsAvg, inBytesRate, inBytesAvg,
+		outMsgs, outBytes, outMsgsRate, outMsgsAvg, outBytesRate, outBytesAvg,
+		stats.Varz.TotalConnections, stats.Rates.NewConnsRate,
+		stats.Varz.Subscriptions, stats.Rates.SubsDelta,
+		httpReqRates)
+	text += fmt.Sprintf("\n\nConnections Polled: %d\n", numConns)
 
-		// host
-		size = len(hostname)
-		if size > hostSize {
-			hostSize = size + DEFAULT_PADDING_SIZE
-		}
+	if settings.talkersWindow > 0 {
+		text += generateTalkersTable(engine, settings.talkersWindow, rowsLeft(text))
+	} else if settings.groupBy != top.GroupByNone {
+		text += generateGroupsTable(engine, stats, settings.groupBy, rowsLeft(text))
+	} else {
+		text += polledConnsTable.generate(engine, stats.Connz.Conns, stats.ConnsExt, rowsLeft(text))
+	}
 
-		// name
-		size = len(conn.Name)
-		if size > nameSize {
-			nameSize = size + DEFAULT_PADDING_SIZE
+	return text
+}
 
-			// If using name, ensure that it is not too small...
-			minLen := len("NAME")
-			if nameSize < minLen {
-				nameSize = minLen
-			}
-		}
+// generateRefresh shows the rate at which the stats are refreshed,
+// along with the interval which was set in case it was backed off.
+func generateRefresh(engine *top.Engine, stats *top.Stats) string {
+	if stats.Interval <= 0 {
+		return ""
 	}
 
-	// Initial padding
-	connHeader := DEFAULT_PADDING
+	text := fmt.Sprintf(", refresh: %.2fs (%.1f/s)", stats.Interval.Seconds(), 1/stats.Interval.Seconds())
+	if delay := engine.Settings().PollInterval(); engine.EffectiveDelay() > delay {
+		text += fmt.Sprintf(" backed off from %s", delay)
+	}
+	if stats.ConnzTime > 0 {
+		text += fmt.Sprintf(", /connz: %.1fms", float64(stats.ConnzTime)/float64(time.Millisecond))
+	}
+	return text
+}
 
-	// HOST
-	header = append(header, "HOST")
-	connHeader += "%-" + fmt.Sprintf("%d", hostSize) + "s "
+// lookupEnabled is whether DNS lookup is enabled, which is toggled
+// from the UI while the connections are being rendered.
+var lookupEnabled int32
 
-	// CID
-	header = append(header, "CID")
-	connHeader += " %-6s "
+func dnsLookupEnabled() bool {
+	return atomic.LoadInt32(&lookupEnabled) == 1
+}
 
-	// NAME
-	if nameSize > 0 {
-		header = append(header, "NAME")
-		connHeader += "%-" + fmt.Sprintf("%d", nameSize) + "s "
+func setDNSLookup(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
 	}
+	atomic.StoreInt32(&lookupEnabled, v)
+}
 
-	header = append(header, "SUBS", "PENDING", "MSGS_TO", "MSGS_FROM", "BYTES_TO", "BYTES_FROM", "LANG", "VERSION", "UPTIME", "LAST ACTIVITY")
-	connHeader += defaultHeaderFormat
-	if displaySubs {
-		connHeader += "%13s"
+// resolveHost returns the address that should be displayed for
+// a client connection, which is the hostname if DNS lookup is enabled
+// and it was resolved already, otherwise ip:port while it is looked up.
+func resolveHost(conn gnatsd.ConnInfo) string {
+	if dnsLookupEnabled() {
+		if hostname, ok := resolver.Lookup(conn.IP); ok {
+			return hostname
+		}
 	}
-	// ...LAST ACTIVITY
-	connHeader += "\n"
+	return fmt.Sprintf("%s:%d", conn.IP, conn.Port)
+}
 
-	var connRows string
-	if displaySubs {
-		header = append(header, "SUBSCRIPTIONS")
-		connRows = fmt.Sprintf(connHeader, header...)
-	} else {
-		connRows = fmt.Sprintf(connHeader, header...)
+// connExtColumn is an optional column for a field which
+// is only reported by newer versions of the server.
+type connExtColumn struct {
+	header string
+	value  func(ext *top.ConnInfoExt) string
+	size   int
+}
+
+func newConnExtColumns() []*connExtColumn {
+	return []*connExtColumn{
+		{header: "KIND", value: func(ext *top.ConnInfoExt) string { return ext.Kind }},
+		{header: "TYPE", value: func(ext *top.ConnInfoExt) string { return ext.Type }},
+		{header: "ACCOUNT", value: func(ext *top.ConnInfoExt) string { return ext.Account }},
+		{header: "RTT", value: func(ext *top.ConnInfoExt) string { return ext.RTT }},
+		{header: "TLS_PEER", value: func(ext *top.ConnInfoExt) string {
+			if len(ext.TLSPeerCerts) > 0 && ext.TLSPeerCerts[0] != nil {
+				return ext.TLSPeerCerts[0].Subject
+			}
+			return ""
+		}},
 	}
+}
 
-	// Add to screen!
-	text += connRows
+// groupHost returns the host used as key when grouping connections
+// by host, which is the resolved name if DNS lookup is enabled.
+func groupHost(conn gnatsd.ConnInfo) string {
+	if !dnsLookupEnabled() {
+		return conn.IP
+	}
 
-	connValues := DEFAULT_PADDING
+	// Fallback to the ip in case the lookup did not succeed
+	// so that connections from the same host are grouped.
+	hostname := resolveHost(conn)
+	if strings.HasPrefix(hostname, conn.IP+":") {
+		return conn.IP
+	}
+	return hostname
+}
 
-	// HOST: e.g. 192.168.1.1:78901
-	connValues += "%-" + fmt.Sprintf("%d", hostSize) + "s "
+// generateGroupsTable returns the formatted header and rows for the
+// connections aggregated by the group option, followed by the
+// connections from the expanded group in case there is one, up to
+// the number of rows which fit in the screen.
+func generateGroupsTable(engine *top.Engine, stats *top.Stats, by top.GroupByOpt, maxRows int) string {
+	settings := currentViewSettings()
+	groups := top.GroupConns(stats.Connz.Conns, stats.Rates.Connections, by, groupHost)
+	top.SortGroups(groups, settings.groupSortOpt)
+
+	keySize := DEFAULT_HOST_PADDING_SIZE
+	for _, group := range groups {
+		size := len(group.Key)
+		if size > keySize {
+			keySize = size + DEFAULT_PADDING_SIZE
+		}
+	}
 
-	// CID: e.g. 1234
-	connValues += " %-6d "
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Groups by %s: %d\n", by, len(groups))
+
+	groupHeader := DEFAULT_PADDING
+	groupHeader += "%-" + fmt.Sprintf("%d", keySize) + "s "
+	groupHeader += groupHeaderFormat + "\n"
+	fmt.Fprintf(&buf, groupHeader, strings.ToUpper(string(by)),
+		"CONNS", "SUBS", "PENDING", "MSGS_TO", "MSGS_FROM", "BYTES_TO", "BYTES_FROM",
+		"MSGS_TO/S", "MSGS_FROM/S", "BYTES_TO/S", "BYTES_FROM/S")
+
+	groupValues := DEFAULT_PADDING
+	groupValues += "%-" + fmt.Sprintf("%d", keySize) + "s "
+	groupValues += groupRowFormat + "\n"
+
+	// Rows below the screen would not be visible, although the
+	// expanded group is looked up among all of them.
+	rows := maxRows - 2
+	var expanded *top.ConnGroup
+	for i, group := range groups {
+		if group.Key == settings.expandedGroup {
+			expanded = group
+		}
+		if i >= rows {
+			continue
+		}
+		fmt.Fprintf(&buf, groupValues, group.Key,
+			group.NumConns, group.NumSubs, top.Psize(int64(group.Pending)),
+			top.Psize(group.OutMsgs), top.Psize(group.InMsgs),
+			top.Psize(group.OutBytes), top.Psize(group.InBytes),
+			group.Rates.OutMsgsRate, group.Rates.InMsgsRate,
+			top.Psize(int64(group.Rates.OutBytesRate)), top.Psize(int64(group.Rates.InBytesRate)))
+	}
 
-	// NAME: e.g. hello
-	if nameSize > 0 {
-		connValues += "%-" + fmt.Sprintf("%d", nameSize) + "s "
+	// Show the members of the expanded group below the groups
+	if expanded != nil {
+		fmt.Fprintf(&buf, "\nConnections in %s %s: %d\n", by, expanded.Key, expanded.NumConns)
+		left := maxRows - bytes.Count(buf.Bytes(), []byte("\n"))
+		buf.WriteString(groupConnsTable.generate(engine, expanded.Conns, stats.ConnsExt, left))
 	}
 
-	connValues += defaultRowFormat
-	if displaySubs {
-		connValues += "%s"
+	return buf.String()
+}
+
+// generateTalkersTable returns the formatted header and rows for the
+// connections ranked by their traffic over the sliding window, up to
+// the number of rows which fit in the screen.
+func generateTalkersTable(engine *top.Engine, window time.Duration, maxRows int) string {
+	// Rows below the screen would not be visible
+	sortOpt := currentViewSettings().talkersSortOpt
+	var talkers []*top.Talker
+	if rows := maxRows - 2; engine.History != nil && rows > 0 {
+		talkers = engine.History.TopTalkers(window, sortOpt, rows)
 	}
-	connValues += "\n"
 
-	for _, conn := range stats.Connz.Conns {
-		var h string
-		if *lookupDNS {
-			if rh, present := resolvedHosts[conn.IP]; present {
-				h = rh
+	hostSize := DEFAULT_HOST_PADDING_SIZE
+	nameSize := 0
+	var span time.Duration
+	for _, talker := range talkers {
+		size := len(resolveHost(talker.Conn))
+		if size > hostSize {
+			hostSize = size + DEFAULT_PADDING_SIZE
+		}
+		size = len(talker.Conn.Name)
+		if size > nameSize {
+			nameSize = size + DEFAULT_PADDING_SIZE
+			if nameSize < len("NAME") {
+				nameSize = len("NAME")
 			}
-		} else {
-			h = fmt.Sprintf("%s:%d", conn.IP, conn.Port)
 		}
+		if talker.Span > span {
+			span = talker.Span
+		}
+	}
 
-		// Build the info line
-		var connLine string
-		connLineInfo := make([]interface{}, 0)
-		connLineInfo = append(connLineInfo, h)
-		connLineInfo = append(connLineInfo, conn.Cid)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Top talkers over %s by %s (covered: %s)\n", window, sortOpt, span)
 
-		// Name not included unless present
-		if nameSize > 0 {
-			connLineInfo = append(connLineInfo, conn.Name)
-		}
+	talkersHeader := DEFAULT_PADDING
+	talkersHeader += "%-" + fmt.Sprintf("%d", hostSize) + "s "
+	talkersHeader += " %-6s "
+	header := []interface{}{"HOST", "CID"}
+	if nameSize > 0 {
+		talkersHeader += "%-" + fmt.Sprintf("%d", nameSize) + "s "
+		header = append(header, "NAME")
+	}
+	talkersHeader += talkersHeaderFormat + "\n"
+	header = append(header, "MSGS_TO", "MSGS_FROM", "BYTES_TO", "BYTES_FROM",
+		"MSGS_TO/S", "MSGS_FROM/S", "BYTES_TO/S", "BYTES_FROM/S", "LANG", "VERSION")
+	fmt.Fprintf(&buf, talkersHeader, header...)
+
+	talkersValues := DEFAULT_PADDING
+	talkersValues += "%-" + fmt.Sprintf("%d", hostSize) + "s "
+	talkersValues += " %-6d "
+	if nameSize > 0 {
+		talkersValues += "%-" + fmt.Sprintf("%d", nameSize) + "s "
+	}
+	talkersValues += talkersRowFormat + "\n"
 
-		connLineInfo = append(connLineInfo, conn.NumSubs)
-		connLineInfo = append(connLineInfo, top.Psize(int64(conn.Pending)), top.Psize(conn.OutMsgs), top.Psize(conn.InMsgs))
-		connLineInfo = append(connLineInfo, top.Psize(conn.OutBytes), top.Psize(conn.InBytes))
-		connLineInfo = append(connLineInfo, conn.Lang, conn.Version)
-		connLineInfo = append(connLineInfo, conn.Uptime, conn.LastActivity)
-
-		if displaySubs {
-			subs := strings.Join(conn.Subs, ", ")
-			connLineInfo = append(connLineInfo, subs)
-			connLine = fmt.Sprintf(connValues, connLineInfo...)
-		} else {
-			connLine = fmt.Sprintf(connValues, connLineInfo...)
+	for _, talker := range talkers {
+		conn := talker.Conn
+		values := []interface{}{resolveHost(conn), conn.Cid}
+		if nameSize > 0 {
+			values = append(values, conn.Name)
 		}
-
-		// Add line to screen!
-		text += connLine
+		values = append(values,
+			top.Psize(talker.OutMsgs), top.Psize(talker.InMsgs),
+			top.Psize(talker.OutBytes), top.Psize(talker.InBytes),
+			talker.Rates.OutMsgsRate, talker.Rates.InMsgsRate,
+			top.Psize(int64(talker.Rates.OutBytesRate)), top.Psize(int64(talker.Rates.InBytesRate)),
+			conn.Lang, conn.Version)
+		fmt.Fprintf(&buf, talkersValues, values...)
 	}
 
-	return text
+	return buf.String()
 }
 
 type ViewMode int
@@ -339,21 +654,69 @@ type ViewMode int
 const (
 	TopViewMode ViewMode = iota
 	HelpViewMode
+	InfoViewMode
+	StacksViewMode
+	LeafsViewMode
+	GatewaysViewMode
+	JetStreamViewMode
+	AccountsViewMode
+	SecurityViewMode
+	VersionsViewMode
+	DiffViewMode
 )
 
+// shownView is the view on screen, which is the only one generated
+// from the stats since they can have many connections.
+var shownView int32
+
+func setShownView(mode ViewMode) {
+	atomic.StoreInt32(&shownView, int32(mode))
+}
+
+// generateView returns the text of the view from the stats, or false
+// for the views which are not generated from them, e.g. the help.
+func generateView(engine *top.Engine, mode ViewMode, stats *top.Stats) (string, bool) {
+	switch mode {
+	case TopViewMode:
+		return generateParagraph(engine, stats), true
+	case InfoViewMode:
+		return generateServerInfo(engine, stats), true
+	case LeafsViewMode:
+		return generateLeafsTable(engine, stats), true
+	case GatewaysViewMode:
+		return generateGatewaysTable(engine, stats), true
+	case JetStreamViewMode:
+		return generateJetStreamView(engine, stats), true
+	case AccountsViewMode:
+		return generateAccountsView(engine, stats), true
+	case SecurityViewMode:
+		return generateSecurityView(engine, stats), true
+	case VersionsViewMode:
+		return generateVersionsTable(stats.Connz.Conns), true
+	case DiffViewMode:
+		return generateDiffView(engine, stats), true
+	}
+	return "", false
+}
+
 // StartUI periodically refreshes the screen using recent data.
 func StartUI(engine *top.Engine) {
 
 	cleanStats := &top.Stats{
-		Varz:  &gnatsd.Varz{},
-		Connz: &gnatsd.Connz{},
-		Rates: &top.Rates{},
-		Error: fmt.Errorf(""),
+		Varz:     &gnatsd.Varz{},
+		Connz:    &gnatsd.Connz{},
+		Routez:   &gnatsd.Routez{},
+		VarzExt:  &top.VarzExt{},
+		ConnsExt: make(map[uint64]*top.ConnInfoExt),
+		Rates:    &top.Rates{},
+		Error:    fmt.Errorf(""),
 	}
 
+	// Only the rows which fit in the screen are formatted
+	setScreenRows(ui.TermHeight())
+
 	// Show empty values on first display
-	text := generateParagraph(engine, cleanStats)
-	par := ui.NewPar(text)
+	par := ui.NewPar(generateParagraph(engine, cleanStats))
 	par.Height = ui.TermHeight()
 	par.Width = ui.TermWidth()
 	par.HasBorder = false
@@ -364,15 +727,95 @@ func StartUI(engine *top.Engine) {
 	helpPar.Width = ui.TermWidth()
 	helpPar.HasBorder = false
 
+	infoPar := ui.NewPar(generateServerInfo(engine, cleanStats))
+	infoPar.Height = ui.TermHeight()
+	infoPar.Width = ui.TermWidth()
+	infoPar.HasBorder = false
+
+	stacks := newStacksView()
+	stacksPar := ui.NewPar(stacks.generateStacks())
+	stacksPar.Height = ui.TermHeight()
+	stacksPar.Width = ui.TermWidth()
+	stacksPar.HasBorder = false
+
+	leafsPar := ui.NewPar(generateLeafsTable(engine, cleanStats))
+	leafsPar.Height = ui.TermHeight()
+	leafsPar.Width = ui.TermWidth()
+	leafsPar.HasBorder = false
+
+	gatewaysPar := ui.NewPar(generateGatewaysTable(engine, cleanStats))
+	gatewaysPar.Height = ui.TermHeight()
+	gatewaysPar.Width = ui.TermWidth()
+	gatewaysPar.HasBorder = false
+
+	jsPar := ui.NewPar(generateJetStreamView(engine, cleanStats))
+	jsPar.Height = ui.TermHeight()
+	jsPar.Width = ui.TermWidth()
+	jsPar.HasBorder = false
+
+	accountsPar := ui.NewPar(generateAccountsView(engine, cleanStats))
+	accountsPar.Height = ui.TermHeight()
+	accountsPar.Width = ui.TermWidth()
+	accountsPar.HasBorder = false
+
+	securityPar := ui.NewPar(generateSecurityView(engine, cleanStats))
+	securityPar.Height = ui.TermHeight()
+	securityPar.Width = ui.TermWidth()
+	securityPar.HasBorder = false
+
+	versionsPar := ui.NewPar(generateVersionsTable(cleanStats.Connz.Conns))
+	versionsPar.Height = ui.TermHeight()
+	versionsPar.Width = ui.TermWidth()
+	versionsPar.HasBorder = false
+
+	diffPar := ui.NewPar(generateDiffView(engine, cleanStats))
+	diffPar.Height = ui.TermHeight()
+	diffPar.Width = ui.TermWidth()
+	diffPar.HasBorder = false
+
 	// Top like view
 	paraRow := ui.NewRow(ui.NewCol(ui.TermWidth(), 0, par))
 
 	// Help view
 	helpParaRow := ui.NewRow(ui.NewCol(ui.TermWidth(), 0, helpPar))
 
+	// Server info view
+	infoParaRow := ui.NewRow(ui.NewCol(ui.TermWidth(), 0, infoPar))
+
+	// Goroutine stacks view
+	stacksParaRow := ui.NewRow(ui.NewCol(ui.TermWidth(), 0, stacksPar))
+
+	// Leafnodes and gateways views
+	leafsParaRow := ui.NewRow(ui.NewCol(ui.TermWidth(), 0, leafsPar))
+	gatewaysParaRow := ui.NewRow(ui.NewCol(ui.TermWidth(), 0, gatewaysPar))
+
+	// JetStream view
+	jsParaRow := ui.NewRow(ui.NewCol(ui.TermWidth(), 0, jsPar))
+
+	// Accounts view
+	accountsParaRow := ui.NewRow(ui.NewCol(ui.TermWidth(), 0, accountsPar))
+
+	// Security audit view
+	securityParaRow := ui.NewRow(ui.NewCol(ui.TermWidth(), 0, securityPar))
+
+	// Client versions view
+	versionsParaRow := ui.NewRow(ui.NewCol(ui.TermWidth(), 0, versionsPar))
+
+	// Comparison of the marked or frozen stats against the live ones
+	diffParaRow := ui.NewRow(ui.NewCol(ui.TermWidth(), 0, diffPar))
+
 	// Create grids that we'll be using to toggle what to render
 	topViewGrid := ui.NewGrid(paraRow)
 	helpViewGrid := ui.NewGrid(helpParaRow)
+	infoViewGrid := ui.NewGrid(infoParaRow)
+	stacksViewGrid := ui.NewGrid(stacksParaRow)
+	leafsViewGrid := ui.NewGrid(leafsParaRow)
+	gatewaysViewGrid := ui.NewGrid(gatewaysParaRow)
+	jsViewGrid := ui.NewGrid(jsParaRow)
+	accountsViewGrid := ui.NewGrid(accountsParaRow)
+	securityViewGrid := ui.NewGrid(securityParaRow)
+	versionsViewGrid := ui.NewGrid(versionsParaRow)
+	diffViewGrid := ui.NewGrid(diffParaRow)
 
 	// Start with the topviewGrid by default
 	ui.Body.Rows = topViewGrid.Rows
@@ -384,14 +827,61 @@ func StartUI(engine *top.Engine) {
 	// Used for pinging the IU to refresh the screen with new values
 	redraw := make(chan struct{})
 
+	// Stacks are fetched on demand, then handled along with the events
+	type stacksResult struct {
+		data []byte
+		err  error
+	}
+	stacksCh := make(chan stacksResult)
+	refreshStacks := func() {
+		go func() {
+			data, err := fetchStacks(engine)
+			stacksCh <- stacksResult{data, err}
+		}()
+	}
+
+	// Used for refreshing the views once frozen or marked
+	rerender := make(chan struct{}, 1)
+	requestRerender := func() {
+		select {
+		case rerender <- struct{}{}:
+		default:
+		}
+	}
+
+	// Texts of the views generated from the stats
+	viewPars := map[ViewMode]*ui.Par{
+		TopViewMode:       par,
+		InfoViewMode:      infoPar,
+		LeafsViewMode:     leafsPar,
+		GatewaysViewMode:  gatewaysPar,
+		JetStreamViewMode: jsPar,
+		AccountsViewMode:  accountsPar,
+		SecurityViewMode:  securityPar,
+		VersionsViewMode:  versionsPar,
+		DiffViewMode:      diffPar,
+	}
+
 	update := func() {
 		for {
-			receivedStats := <-engine.StatsCh
-			stats := receivedStats
+			var stats *top.Stats
+			select {
+			case receivedStats := <-engine.StatsCh:
+				// Frozen stats are shown while polling goes on
+				stats = compared.update(receivedStats)
+			case <-rerender:
+				stats = compared.shown()
+				if stats == nil {
+					continue
+				}
+			}
 
-			// Update top view text
-			text = generateParagraph(engine, stats)
-			par.Text = text
+			// Only the view on screen is generated, the rest are
+			// generated once switched to them.
+			mode := ViewMode(atomic.LoadInt32(&shownView))
+			if text, ok := generateView(engine, mode, stats); ok {
+				viewPars[mode].Text = text
+			}
 
 			redraw <- struct{}{}
 		}
@@ -400,18 +890,71 @@ func StartUI(engine *top.Engine) {
 	// Flags for capturing options
 	waitingSortOption := false
 	waitingLimitOption := false
-	displaySubscriptions := false
+	waitingGroupOption := false
+	waitingExpandOption := false
+	waitingSearchOption := false
+	waitingStackOption := false
+	waitingAccountOption := false
+	waitingJumpOption := false
+	waitingDelayOption := false
+	displaySubscriptions := engine.Settings().DisplaySubs
+
+	waitingOption := func() bool {
+		return waitingSortOption || waitingLimitOption || waitingGroupOption || waitingExpandOption ||
+			waitingSearchOption || waitingStackOption || waitingAccountOption || waitingJumpOption ||
+			waitingDelayOption
+	}
+
+	// The interval only applies when polling the server, rather
+	// than when attached to a daemon or replaying a recording.
+	polling := player == nil && *attachAddr == ""
+
+	// Top talkers, groups, JetStream and accounts are sorted by their own options
+	currentSortOpt := func() string {
+		views := currentViewSettings()
+		if viewMode == JetStreamViewMode {
+			return string(views.jsSortOpt)
+		}
+		if viewMode == AccountsViewMode {
+			return string(views.accountsSortOpt)
+		}
+		if views.talkersWindow > 0 {
+			return string(views.talkersSortOpt)
+		}
+		if views.groupBy != top.GroupByNone {
+			return string(views.groupSortOpt)
+		}
+		return string(engine.Settings().SortOpt)
+	}
+
+	// Options are prompted below the server header, other than in
+	// the JetStream and accounts views which have a single line header.
+	promptPos := func() string {
+		if viewMode == JetStreamViewMode || viewMode == AccountsViewMode {
+			return "\033[1;1H\033[2;1H"
+		}
+		return "\033[1;1H\033[8;1H"
+	}
 
 	optionBuf := ""
 	refreshOptionHeader := func() {
 		// Need to mask what was typed before
-		clrline := "\033[1;1H\033[6;1H                  "
+		clrline := promptPos() + "                  "
 
 		clrline += "  "
 		for i := 0; i < len(optionBuf); i++ {
 			clrline += "  "
 		}
-		fmt.Printf(clrline)
+		fmt.Print(clrline)
+	}
+
+	// Options in the stacks view are prompted at the top
+	refreshStacksHeader := func() {
+		clrline := "\033[1;1H\033[2;1H                  "
+		for i := 0; i < len(optionBuf); i++ {
+			clrline += "  "
+		}
+		fmt.Print(clrline)
 	}
 
 	evt := ui.EventCh()
@@ -420,7 +963,48 @@ func StartUI(engine *top.Engine) {
 
 	go update()
 
+	// Views which add up or rank the connections need all of them,
+	// rather than the ones polled with -n.
+	needsAllConns := func() bool {
+		return viewMode == AccountsViewMode || viewMode == SecurityViewMode ||
+			currentViewSettings().talkersWindow > 0
+	}
+
+	pollingJetStream, pollingAllConns := false, false
+	auditing, displayAuth := false, false
+	generatedView := viewMode
+	setShownView(viewMode)
 	for {
+		// Views are generated from the latest stats once switched to
+		if viewMode != generatedView {
+			generatedView = viewMode
+			setShownView(viewMode)
+			requestRerender()
+		}
+
+		// Streams and consumers are only polled while displayed
+		if polling := viewMode == JetStreamViewMode; polling != pollingJetStream {
+			pollingJetStream = polling
+			engine.UpdateSettings(func(s *top.Settings) { s.PollJetStream = polling })
+		}
+		if polling := needsAllConns(); polling != pollingAllConns {
+			pollingAllConns = polling
+			engine.UpdateSettings(func(s *top.Settings) { s.AllConns = polling })
+		}
+
+		// Auth details are needed to check the users while auditing,
+		// then the previous setting is restored once leaving the view.
+		if audit := viewMode == SecurityViewMode; audit != auditing {
+			auditing = audit
+			engine.UpdateSettings(func(s *top.Settings) {
+				if audit {
+					displayAuth, s.DisplayAuth = s.DisplayAuth, true
+				} else {
+					s.DisplayAuth = displayAuth
+				}
+			})
+		}
+
 		select {
 		case e := <-evt:
 
@@ -428,14 +1012,39 @@ func StartUI(engine *top.Engine) {
 
 				if e.Type == ui.EventKey && e.Key == ui.KeyEnter {
 
-					sortOpt := gnatsd.SortOpt(optionBuf)
-					if sortOpt.IsValid() {
-						engine.SortOpt = sortOpt
+					var valid bool
+					views := currentViewSettings()
+					if viewMode == JetStreamViewMode {
+						sortOpt := top.JSSortOpt(optionBuf)
+						if valid = sortOpt.IsValid(); valid {
+							updateViewSettings(func(v *viewSettings) { v.jsSortOpt = sortOpt })
+						}
+					} else if viewMode == AccountsViewMode {
+						sortOpt := top.GroupSortOpt(optionBuf)
+						if valid = sortOpt.IsValid(); valid {
+							updateViewSettings(func(v *viewSettings) { v.accountsSortOpt = sortOpt })
+						}
+					} else if views.talkersWindow > 0 {
+						sortOpt := top.TalkersSortOpt(optionBuf)
+						if valid = sortOpt.IsValid(); valid {
+							updateViewSettings(func(v *viewSettings) { v.talkersSortOpt = sortOpt })
+						}
+					} else if views.groupBy != top.GroupByNone {
+						sortOpt := top.GroupSortOpt(optionBuf)
+						if valid = sortOpt.IsValid(); valid {
+							updateViewSettings(func(v *viewSettings) { v.groupSortOpt = sortOpt })
+						}
 					} else {
+						sortOpt := gnatsd.SortOpt(optionBuf)
+						if valid = sortOpt.IsValid(); valid {
+							engine.UpdateSettings(func(s *top.Settings) { s.SortOpt = sortOpt })
+						}
+					}
+					if !valid {
 						go func() {
 							// Has to be at least of the same length as sort by header
 							emptyPadding := "       "
-							fmt.Printf("\033[1;1H\033[6;1Hinvalid order: %s%s", optionBuf, emptyPadding)
+							fmt.Printf(promptPos()+"invalid order: %s%s", optionBuf, emptyPadding)
 							waitingSortOption = false
 							time.Sleep(1 * time.Second)
 							refreshOptionHeader()
@@ -457,7 +1066,7 @@ func StartUI(engine *top.Engine) {
 				} else {
 					optionBuf += string(e.Ch)
 				}
-				fmt.Printf("\033[1;1H\033[6;1Hsort by [%s]: %s", engine.SortOpt, optionBuf)
+				fmt.Printf(promptPos()+"sort by [%s]: %s", currentSortOpt(), optionBuf)
 			}
 
 			if waitingLimitOption {
@@ -467,7 +1076,7 @@ func StartUI(engine *top.Engine) {
 					var n int
 					_, err := fmt.Sscanf(optionBuf, "%d", &n)
 					if err == nil {
-						engine.Conns = n
+						engine.UpdateSettings(func(s *top.Settings) { s.Conns = n })
 					}
 
 					waitingLimitOption = false
@@ -483,22 +1092,219 @@ func StartUI(engine *top.Engine) {
 				} else {
 					optionBuf += string(e.Ch)
 				}
-				fmt.Printf("\033[1;1H\033[6;1Hlimit   [%d]: %s", engine.Conns, optionBuf)
+				fmt.Printf("\033[1;1H\033[8;1Hlimit   [%d]: %s", engine.Settings().Conns, optionBuf)
+			}
+
+			if waitingDelayOption {
+
+				if e.Type == ui.EventKey && e.Key == ui.KeyEnter {
+					delay, err := top.ParseDelay(optionBuf)
+					if err != nil {
+						go func() {
+							emptyPadding := "       "
+							fmt.Printf(promptPos()+"%s%s", err, emptyPadding)
+							waitingDelayOption = false
+							time.Sleep(1 * time.Second)
+							refreshOptionHeader()
+							optionBuf = ""
+						}()
+						continue
+					}
+					engine.UpdateSettings(func(s *top.Settings) { s.Interval = delay })
+
+					waitingDelayOption = false
+					optionBuf = ""
+					refreshOptionHeader()
+					continue
+				}
+
+				// Handle backspace
+				if e.Type == ui.EventKey && len(optionBuf) > 0 && (e.Key == ui.KeyBackspace || e.Key == ui.KeyBackspace2) {
+					optionBuf = optionBuf[:len(optionBuf)-1]
+					refreshOptionHeader()
+				} else {
+					optionBuf += string(e.Ch)
+				}
+				fmt.Printf(promptPos()+"interval [%s]: %s", engine.Settings().PollInterval(), optionBuf)
+				continue
+			}
+
+			if waitingGroupOption {
+
+				if e.Type == ui.EventKey && e.Key == ui.KeyEnter {
+
+					groupOpt := top.GroupByOpt(optionBuf)
+					if optionBuf == "none" {
+						groupOpt = top.GroupByNone
+					}
+					if !groupOpt.IsValid() {
+						go func() {
+							// Has to be at least of the same length as group by header
+							emptyPadding := "        "
+							fmt.Printf("\033[1;1H\033[8;1Hinvalid group: %s%s", optionBuf, emptyPadding)
+							waitingGroupOption = false
+							time.Sleep(1 * time.Second)
+							refreshOptionHeader()
+							optionBuf = ""
+						}()
+						continue
+					}
+					updateViewSettings(func(v *viewSettings) {
+						if groupOpt != v.groupBy {
+							v.expandedGroup = ""
+						}
+						v.groupBy = groupOpt
+					})
+
+					refreshOptionHeader()
+					waitingGroupOption = false
+					optionBuf = ""
+					continue
+				}
+
+				// Handle backspace
+				if e.Type == ui.EventKey && len(optionBuf) > 0 && (e.Key == ui.KeyBackspace || e.Key == ui.KeyBackspace2) {
+					optionBuf = optionBuf[:len(optionBuf)-1]
+					refreshOptionHeader()
+				} else {
+					optionBuf += string(e.Ch)
+				}
+				fmt.Printf("\033[1;1H\033[8;1Hgroup by [%s]: %s", currentViewSettings().groupBy, optionBuf)
+			}
+
+			if waitingExpandOption {
+
+				if e.Type == ui.EventKey && e.Key == ui.KeyEnter {
+					// Empty value collapses the expanded group
+					expanded := optionBuf
+					updateViewSettings(func(v *viewSettings) { v.expandedGroup = expanded })
+
+					waitingExpandOption = false
+					optionBuf = ""
+					refreshOptionHeader()
+					continue
+				}
+
+				// Handle backspace
+				if e.Type == ui.EventKey && len(optionBuf) > 0 && (e.Key == ui.KeyBackspace || e.Key == ui.KeyBackspace2) {
+					optionBuf = optionBuf[:len(optionBuf)-1]
+					refreshOptionHeader()
+				} else {
+					optionBuf += string(e.Ch)
+				}
+				fmt.Printf("\033[1;1H\033[8;1Hexpand  [%s]: %s", currentViewSettings().expandedGroup, optionBuf)
+			}
+
+			if waitingAccountOption {
+
+				if e.Type == ui.EventKey && e.Key == ui.KeyEnter {
+					// Empty value goes back to all the accounts
+					account := optionBuf
+					engine.UpdateSettings(func(s *top.Settings) { s.Account = account })
+
+					waitingAccountOption = false
+					optionBuf = ""
+					refreshOptionHeader()
+					continue
+				}
+
+				// Handle backspace
+				if e.Type == ui.EventKey && len(optionBuf) > 0 && (e.Key == ui.KeyBackspace || e.Key == ui.KeyBackspace2) {
+					optionBuf = optionBuf[:len(optionBuf)-1]
+					refreshOptionHeader()
+				} else {
+					optionBuf += string(e.Ch)
+				}
+				fmt.Printf(promptPos()+"account [%s]: %s", engine.Settings().Account, optionBuf)
+				continue
+			}
+
+			if waitingJumpOption {
+
+				if e.Type == ui.EventKey && e.Key == ui.KeyEnter {
+					t, err := parseJumpTime(optionBuf, player.Status().Time)
+					if err != nil {
+						go func() {
+							emptyPadding := "       "
+							fmt.Printf(promptPos()+"%s%s", err, emptyPadding)
+							waitingJumpOption = false
+							time.Sleep(1 * time.Second)
+							refreshOptionHeader()
+							optionBuf = ""
+						}()
+						continue
+					}
+					player.Seek(t)
+
+					waitingJumpOption = false
+					optionBuf = ""
+					refreshOptionHeader()
+					continue
+				}
+
+				// Handle backspace
+				if e.Type == ui.EventKey && len(optionBuf) > 0 && (e.Key == ui.KeyBackspace || e.Key == ui.KeyBackspace2) {
+					optionBuf = optionBuf[:len(optionBuf)-1]
+					refreshOptionHeader()
+				} else {
+					optionBuf += string(e.Ch)
+				}
+				fmt.Printf(promptPos()+"jump to [hh:mm:ss]: %s", optionBuf)
+				continue
+			}
+
+			if waitingSearchOption || waitingStackOption {
+
+				if e.Type == ui.EventKey && e.Key == ui.KeyEnter {
+					if waitingSearchOption {
+						stacks.filter = optionBuf
+					} else {
+						var n int
+						_, err := fmt.Sscanf(optionBuf, "%d", &n)
+						if err == nil {
+							stacks.toggle(n)
+						}
+					}
+
+					waitingSearchOption = false
+					waitingStackOption = false
+					optionBuf = ""
+					refreshStacksHeader()
+					stacksPar.Text = stacks.generateStacks()
+					go func() { redraw <- struct{}{} }()
+					continue
+				}
+
+				// Handle backspace
+				if e.Type == ui.EventKey && len(optionBuf) > 0 && (e.Key == ui.KeyBackspace || e.Key == ui.KeyBackspace2) {
+					optionBuf = optionBuf[:len(optionBuf)-1]
+					refreshStacksHeader()
+				} else {
+					optionBuf += string(e.Ch)
+				}
+				if waitingSearchOption {
+					fmt.Printf("\033[1;1H\033[2;1Hsearch [%s]: %s", stacks.filter, optionBuf)
+				} else {
+					fmt.Printf("\033[1;1H\033[2;1Htoggle group: %s", optionBuf)
+				}
+				continue
 			}
 
 			if e.Type == ui.EventKey && (e.Ch == 'q' || e.Key == ui.KeyCtrlC) {
 				close(engine.ShutdownCh)
+				if engine.Recorder != nil {
+					engine.Recorder.Close()
+				}
 				cleanExit()
 			}
 
-			if e.Type == ui.EventKey && e.Ch == 's' && !(waitingLimitOption || waitingSortOption) {
-				if displaySubscriptions {
-					displaySubscriptions = false
-					engine.DisplaySubs = false
-				} else {
-					displaySubscriptions = true
-					engine.DisplaySubs = true
-				}
+			if e.Type == ui.EventKey && e.Ch == 's' && !waitingOption() {
+				displaySubscriptions = !displaySubscriptions
+				engine.UpdateSettings(func(s *top.Settings) { s.DisplaySubs = displaySubscriptions })
+			}
+
+			if e.Type == ui.EventKey && e.Ch == 'u' && !waitingOption() {
+				engine.UpdateSettings(func(s *top.Settings) { s.DisplayAuth = !s.DisplayAuth })
 			}
 
 			if e.Type == ui.EventKey && viewMode == HelpViewMode {
@@ -507,17 +1313,245 @@ func StartUI(engine *top.Engine) {
 				continue
 			}
 
-			if e.Type == ui.EventKey && e.Ch == 'o' && !waitingLimitOption && viewMode == TopViewMode {
-				fmt.Printf("\033[1;1H\033[6;1Hsort by [%s]:", engine.SortOpt)
+			if e.Type == ui.EventKey && e.Ch == 'o' && !(waitingOption() && !waitingSortOption) && (viewMode == TopViewMode || viewMode == JetStreamViewMode || viewMode == AccountsViewMode) {
+				fmt.Printf(promptPos()+"sort by [%s]:", currentSortOpt())
 				waitingSortOption = true
 			}
 
-			if e.Type == ui.EventKey && e.Ch == 'n' && !waitingSortOption && viewMode == TopViewMode {
-				fmt.Printf("\033[1;1H\033[6;1Hlimit   [%d]:", engine.Conns)
+			if e.Type == ui.EventKey && e.Ch == 'n' && !(waitingOption() && !waitingLimitOption) && viewMode == TopViewMode {
+				fmt.Printf("\033[1;1H\033[8;1Hlimit   [%d]:", engine.Settings().Conns)
 				waitingLimitOption = true
 			}
 
-			if e.Type == ui.EventKey && (e.Ch == '?' || e.Ch == 'h') && !(waitingSortOption || waitingLimitOption) {
+			if e.Type == ui.EventKey && e.Ch == 'p' && !waitingOption() && polling {
+				fmt.Printf(promptPos()+"interval [%s]:", engine.Settings().PollInterval())
+				waitingDelayOption = true
+				continue
+			}
+
+			if e.Type == ui.EventKey && e.Ch == 'b' && !waitingOption() && polling {
+				engine.UpdateSettings(func(s *top.Settings) { s.AdaptiveDelay = !s.AdaptiveDelay })
+			}
+
+			if e.Type == ui.EventKey && e.Ch == 'g' && !waitingOption() && viewMode == TopViewMode {
+				fmt.Printf("\033[1;1H\033[8;1Hgroup by [%s]:", currentViewSettings().groupBy)
+				waitingGroupOption = true
+				continue
+			}
+
+			if e.Type == ui.EventKey && e.Ch == 'e' && !waitingOption() && viewMode == TopViewMode && currentViewSettings().groupBy != top.GroupByNone {
+				fmt.Printf("\033[1;1H\033[8;1Hexpand  [%s]:", currentViewSettings().expandedGroup)
+				waitingExpandOption = true
+				continue
+			}
+
+			if e.Type == ui.EventKey && e.Ch == 'i' && !waitingOption() {
+				if viewMode == InfoViewMode {
+					ui.Body.Rows = topViewGrid.Rows
+					viewMode = TopViewMode
+				} else {
+					ui.Body.Rows = infoViewGrid.Rows
+					viewMode = InfoViewMode
+				}
+				go func() { redraw <- struct{}{} }()
+				continue
+			}
+
+			if e.Type == ui.EventKey && e.Ch == 'l' && !waitingOption() {
+				if viewMode == LeafsViewMode {
+					ui.Body.Rows = topViewGrid.Rows
+					viewMode = TopViewMode
+				} else {
+					ui.Body.Rows = leafsViewGrid.Rows
+					viewMode = LeafsViewMode
+				}
+				go func() { redraw <- struct{}{} }()
+				continue
+			}
+
+			if e.Type == ui.EventKey && e.Ch == 'w' && !waitingOption() {
+				if viewMode == GatewaysViewMode {
+					ui.Body.Rows = topViewGrid.Rows
+					viewMode = TopViewMode
+				} else {
+					ui.Body.Rows = gatewaysViewGrid.Rows
+					viewMode = GatewaysViewMode
+				}
+				go func() { redraw <- struct{}{} }()
+				continue
+			}
+
+			if e.Type == ui.EventKey && e.Ch == 'j' && !waitingOption() {
+				if viewMode == JetStreamViewMode {
+					ui.Body.Rows = topViewGrid.Rows
+					viewMode = TopViewMode
+				} else {
+					ui.Body.Rows = jsViewGrid.Rows
+					viewMode = JetStreamViewMode
+				}
+				go func() { redraw <- struct{}{} }()
+				continue
+			}
+
+			if e.Type == ui.EventKey && e.Ch == 'a' && !waitingOption() {
+				if viewMode == AccountsViewMode {
+					ui.Body.Rows = topViewGrid.Rows
+					viewMode = TopViewMode
+				} else {
+					ui.Body.Rows = accountsViewGrid.Rows
+					viewMode = AccountsViewMode
+				}
+				go func() { redraw <- struct{}{} }()
+				continue
+			}
+
+			if e.Type == ui.EventKey && e.Ch == 'e' && !waitingOption() && viewMode == AccountsViewMode {
+				fmt.Printf(promptPos()+"account [%s]:", engine.Settings().Account)
+				waitingAccountOption = true
+				continue
+			}
+
+			// In the stacks view 'f' refetches the stacks instead
+			if e.Type == ui.EventKey && e.Ch == 'f' && !waitingOption() && viewMode != StacksViewMode {
+				compared.toggleFreeze()
+				requestRerender()
+				continue
+			}
+
+			if e.Type == ui.EventKey && e.Ch == 'm' && !waitingOption() && viewMode != StacksViewMode {
+				compared.toggleMark()
+				requestRerender()
+				continue
+			}
+
+			if e.Type == ui.EventKey && scrollback != nil && !waitingOption() {
+				switch e.Key {
+				case ui.KeyArrowLeft:
+					compared.scrollOlder()
+					requestRerender()
+				case ui.KeyArrowRight:
+					compared.scrollNewer()
+					requestRerender()
+				case ui.KeyEnd:
+					compared.scrollLive()
+					requestRerender()
+				}
+			}
+
+			if e.Type == ui.EventKey && e.Ch == 'c' && !waitingOption() {
+				if viewMode == DiffViewMode {
+					ui.Body.Rows = topViewGrid.Rows
+					viewMode = TopViewMode
+				} else {
+					ui.Body.Rows = diffViewGrid.Rows
+					viewMode = DiffViewMode
+				}
+				requestRerender()
+				continue
+			}
+
+			if e.Type == ui.EventKey && e.Ch == 'x' && !waitingOption() {
+				if viewMode == SecurityViewMode {
+					ui.Body.Rows = topViewGrid.Rows
+					viewMode = TopViewMode
+				} else {
+					ui.Body.Rows = securityViewGrid.Rows
+					viewMode = SecurityViewMode
+				}
+				go func() { redraw <- struct{}{} }()
+				continue
+			}
+
+			if e.Type == ui.EventKey && e.Ch == 'v' && !waitingOption() {
+				if viewMode == VersionsViewMode {
+					ui.Body.Rows = topViewGrid.Rows
+					viewMode = TopViewMode
+				} else {
+					ui.Body.Rows = versionsViewGrid.Rows
+					viewMode = VersionsViewMode
+				}
+				go func() { redraw <- struct{}{} }()
+				continue
+			}
+
+			if e.Type == ui.EventKey && e.Ch == 'k' && !waitingOption() {
+				if viewMode == StacksViewMode {
+					ui.Body.Rows = topViewGrid.Rows
+					viewMode = TopViewMode
+				} else {
+					ui.Body.Rows = stacksViewGrid.Rows
+					viewMode = StacksViewMode
+					refreshStacks()
+				}
+				go func() { redraw <- struct{}{} }()
+				continue
+			}
+
+			// Other than help, keys are only for the stacks in their view
+			if e.Type == ui.EventKey && viewMode == StacksViewMode && !waitingOption() && e.Ch != '?' && e.Ch != 'h' {
+				switch {
+				case e.Ch == 'f':
+					refreshStacks()
+				case e.Ch == '/':
+					fmt.Printf("\033[1;1H\033[2;1Hsearch [%s]:", stacks.filter)
+					waitingSearchOption = true
+				case e.Ch == 'e':
+					fmt.Printf("\033[1;1H\033[2;1Htoggle group:")
+					waitingStackOption = true
+				case e.Ch == '+' || e.Ch == '-':
+					stacks.expandAll(e.Ch == '+')
+					stacksPar.Text = stacks.generateStacks()
+					go func() { redraw <- struct{}{} }()
+				}
+				continue
+			}
+
+			// Playback controls when replaying a recording
+			if e.Type == ui.EventKey && player != nil && !waitingOption() {
+				switch {
+				case e.Key == ui.KeySpace:
+					player.TogglePause()
+				case e.Ch == '>':
+					player.Faster()
+				case e.Ch == '<':
+					player.Slower()
+				case e.Ch == '.':
+					player.Step(1)
+				case e.Ch == ',':
+					player.Step(-1)
+				case e.Ch == '@':
+					fmt.Print(promptPos() + "jump to [hh:mm:ss]:")
+					waitingJumpOption = true
+					continue
+				}
+			}
+
+			if e.Type == ui.EventKey && e.Ch == 'r' && !waitingOption() {
+				updateViewSettings(func(v *viewSettings) { v.rateMode = v.rateMode.Next() })
+			}
+
+			if e.Type == ui.EventKey && e.Ch == 't' && !waitingOption() {
+				// Cycle through the windows, then back to disabled
+				talkersWindow := currentViewSettings().talkersWindow
+				next := time.Duration(0)
+				for i, window := range top.TalkersWindows {
+					if window == talkersWindow && i+1 < len(top.TalkersWindows) {
+						next = top.TalkersWindows[i+1]
+						break
+					}
+				}
+				if talkersWindow == 0 {
+					next = top.TalkersWindows[0]
+				}
+				updateViewSettings(func(v *viewSettings) { v.talkersWindow = next })
+
+				// Samples are only kept while displaying top talkers
+				if engine.History != nil {
+					engine.History.SetEnabled(next > 0)
+				}
+			}
+
+			if e.Type == ui.EventKey && (e.Ch == '?' || e.Ch == 'h') && !waitingOption() {
 				if viewMode == TopViewMode {
 					refreshOptionHeader()
 					optionBuf = ""
@@ -529,21 +1563,22 @@ func StartUI(engine *top.Engine) {
 				waitingSortOption = false
 			}
 
-			if e.Type == ui.EventKey && (e.Ch == 'd') && !(waitingSortOption || waitingLimitOption) {
-				switch *lookupDNS {
-				case true:
-					*lookupDNS = false
-				case false:
-					*lookupDNS = true
-				}
+			if e.Type == ui.EventKey && (e.Ch == 'd') && !waitingOption() {
+				setDNSLookup(!dnsLookupEnabled())
 			}
 
 			if e.Type == ui.EventResize {
+				setScreenRows(ui.TermHeight())
 				ui.Body.Width = ui.TermWidth()
 				ui.Body.Align()
 				go func() { redraw <- struct{}{} }()
 			}
 
+		case result := <-stacksCh:
+			stacks.update(result.data, result.err)
+			stacksPar.Text = stacks.generateStacks()
+			ui.Render(ui.Body)
+
 		case <-redraw:
 			ui.Render(ui.Body)
 		}
@@ -570,7 +1605,124 @@ n<limit>         Set sample size of connections to request from the server.
 
 s                Toggle displaying connection subscriptions.
 
-d                Toggle activating DNS address lookup for clients.
+u                Toggle requesting auth details from the server, displaying
+                 the user, TLS version and cipher of the connections.
+
+                 This can be set in the command line too with -auth flag.
+
+x                Toggle displaying the security audit of the connections,
+                 flagging plaintext connections, weak TLS versions and
+                 ciphers, and users with unusually many connections.
+                 All the connections and their auth details are requested
+                 while displaying the audit, regardless of -n and -auth.
+
+v                Toggle displaying the connections grouped by the language
+                 and version of their client library, flagging the ones
+                 older than the minimum version for their language.
+
+                 Minimum versions are set in the command line with the
+                 -min-versions flag, e.g. -min-versions go=1.31.0
+
+i                Toggle displaying all the info from the server.
+
+k                Toggle displaying the goroutine stacks from the server,
+                 grouping the goroutines with identical stacks.
+
+                 While displaying the stacks the following commands
+                 are available:
+
+                 f         Fetch the stacks again from the server.
+                 /<func>   Only show stacks with a matching function.
+                 e<n>      Expand or collapse the group number <n>.
+                 +, -      Expand or collapse all groups.
+
+l                Toggle displaying the leafnode connections of the server,
+                 along with their traffic and rates.
+
+w                Toggle displaying the outbound and inbound gateway
+                 connections of the server, along with their traffic
+                 and rates.
+
+j                Toggle displaying the JetStream streams and consumers
+                 per account, along with their rates.
+
+                 While displaying JetStream, the sort key can be one of:
+                 {account|name|msgs|bytes|first_seq|last_seq|consumers|
+                 msgs_rate|bytes_rate|stream|pending|ack_pending|
+                 redelivered|waiting|delivered_rate|ack_rate}
+
+a                Toggle displaying the connections, subscriptions, msgs
+                 and bytes rolled up per account, from all the connections
+                 of the server rather than up to -n of them.
+
+                 While displaying accounts, the sort key can be one of:
+                 {key|conns|subs|pending|msgs_to|msgs_from|bytes_to|
+                 bytes_from}, and the following command is available:
+
+                 e<account>  Drill into the connections of an account,
+                             or go back to all accounts when none given.
+
+d                Toggle activating DNS address lookup for clients, which
+                 shows ip:port until the lookup in the background resolves.
+
+g<option>        Group connections by <option>.
+
+                 Option can be one of: {none|name|lang|version|ip|host}
+
+                 When grouping, the sort key can be one of: {key|conns|subs|
+                 pending|msgs_to|msgs_from|bytes_to|bytes_from}
+
+                 This can be set in the command line too with -group flag.
+
+e<group>         Expand a group to display its connections, or collapse it
+                 when no group is given.
+
+t                Cycle through displaying top talkers over the last 10s,
+                 1m and 5m, then back to all connections. Talkers are
+                 ranked among all the connections rather than up to -n.
+
+                 While displaying top talkers, the sort key can be one of:
+                 {msgs_to|msgs_from|bytes_to|bytes_from}
+
+f                Toggle freezing the stats on screen while polling goes on
+                 in the background, other than in the stacks view.
+
+m                Toggle marking the stats on screen, to compare them
+                 against the live ones later, other than in the stacks view.
+
+left, right      Scroll back and forth through the recent stats, up to the
+                 number set with the -scrollback flag, or fewer once they
+                 have over 64k connections, while polling goes on.
+                 The header shows how far back they are, or LIVE.
+
+end              Go back to the live stats.
+
+c                Toggle displaying the changes from the marked stats, or
+                 from the frozen ones when none are marked, up to the live
+                 ones, both server wide and for each connection.
+
+p<interval>      Set the refresh interval, as a duration such as 250ms or 5s.
+
+                 This can be set in the command line too with -d flag.
+
+b                Toggle backing off the refresh interval while the server
+                 takes more than a quarter of it to respond to /connz.
+
+                 This can be set in the command line too with -adaptive flag.
+
+r                Cycle through the averaging of the rates shown next to the
+                 instantaneous ones: {instant|ewma|1m|5m|15m}
+
+                 This can be set in the command line too with -rates flag.
+
+space            Pause or resume the playback when replaying a recording
+                 with the -replay flag, in which case the following commands
+                 are available as well:
+
+                 <, >        Play slower or faster, from 0.25x to 16x.
+                 ,, .        Step back or forward one snapshot.
+                 @<time>     Jump to a time of the day as hh:mm:ss,
+                             or to an RFC 3339 timestamp.
 
 q                Quit nats-top.
 
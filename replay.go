@@ -0,0 +1,53 @@
+// Copyright (c) 2016 NATS Messaging System
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	top "github.com/nats-io/nats-top/util"
+)
+
+// replayTimelineWidth is the number of characters of the timeline.
+const replayTimelineWidth = 30
+
+// player of the recording, nil unless replaying.
+var player *top.Player
+
+// generatePlayback returns the timeline shown in the header when
+// replaying, with the position, time and speed of the playback.
+func generatePlayback(p *top.Playback) string {
+	progress := 0
+	if total := p.End.Sub(p.Start); total > 0 {
+		progress = int(float64(replayTimelineWidth-1) * float64(p.Time.Sub(p.Start)) / float64(total))
+	}
+	timeline := strings.Repeat("=", progress) + ">" + strings.Repeat("-", replayTimelineWidth-1-progress)
+
+	state := "PLAYING"
+	if p.Paused {
+		state = "PAUSED"
+	} else if p.Pos == p.Len-1 {
+		state = "END"
+	}
+
+	return fmt.Sprintf("REPLAY %s [%s] %d/%d %gx %s",
+		p.Time.Local().Format("2006-01-02 15:04:05"), timeline, p.Pos+1, p.Len, p.Speed, state)
+}
+
+// parseJumpTime takes either a time of the day, which is on the
+// same day as the current position of the playback, or a full
+// RFC 3339 timestamp to jump to.
+func parseJumpTime(s string, current time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	clock, err := time.ParseInLocation("15:04:05", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time: %s", s)
+	}
+	day := current.Local()
+	return time.Date(day.Year(), day.Month(), day.Day(),
+		clock.Hour(), clock.Minute(), clock.Second(), 0, time.Local), nil
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2016 NATS Messaging System
+package main
+
+import (
+	"fmt"
+
+	top "github.com/nats-io/nats-top/util"
+)
+
+// scrollback of the live stats, nil when disabled or replaying.
+var scrollback *top.Scrollback
+
+// scrollOlder moves back to the stats before the ones being shown.
+func (c *comparison) scrollOlder() {
+	c.Lock()
+	defer c.Unlock()
+	if scrollback == nil {
+		return
+	}
+
+	seq := c.back
+	if seq == 0 {
+		seq, _ = scrollback.Latest()
+	}
+	if seq > 1 {
+		seq--
+	}
+	c.back, _ = scrollback.At(seq)
+}
+
+// scrollNewer moves forward to the stats after the ones being
+// shown, going back to the live ones once reaching the latest.
+func (c *comparison) scrollNewer() {
+	c.Lock()
+	defer c.Unlock()
+	if scrollback == nil || c.back == 0 {
+		return
+	}
+
+	if latest, _ := scrollback.Latest(); c.back+1 >= latest {
+		c.back = 0
+	} else {
+		c.back++
+	}
+}
+
+// scrollLive goes back to showing the live stats.
+func (c *comparison) scrollLive() {
+	c.Lock()
+	defer c.Unlock()
+	c.back = 0
+}
+
+// scrollStatusLocked returns LIVE, or how far back the stats
+// being shown are from the latest ones as -mm:ss.
+func (c *comparison) scrollStatusLocked() string {
+	if c.back == 0 {
+		return "LIVE"
+	}
+	_, latest := scrollback.Latest()
+	_, stats := scrollback.At(c.back)
+	secs := int(latest.Time.Sub(stats.Time).Seconds() + 0.5)
+	return fmt.Sprintf("-%02d:%02d", secs/60, secs%60)
+}
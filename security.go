@@ -0,0 +1,46 @@
+// Copyright (c) 2016 NATS Messaging System
+package main
+
+import (
+	"fmt"
+
+	top "github.com/nats-io/nats-top/util"
+)
+
+const (
+	// Chopped: KIND CID ADDRESS USER...
+	securityHeaderFormat = "%-12s  %-6s  %-21s  %-16s  %s\n"
+	securityRowFormat    = "%-12s  %-6s  %-21s  %-16s  %s\n"
+)
+
+// generateSecurityView returns the plaintext connections, the ones using
+// weak TLS versions or ciphers and the users with unusually many connections.
+func generateSecurityView(engine *top.Engine, stats *top.Stats) string {
+	findings := top.AuditSecurity(stats.Connz.Conns)
+
+	counts := make(map[top.FindingKind]int)
+	for _, finding := range findings {
+		counts[finding.Kind]++
+	}
+
+	text := fmt.Sprintf("Security audit: %d findings in %d connections  %s\n",
+		len(findings), len(stats.Connz.Conns), stats.Error)
+	text += fmt.Sprintf("  Plaintext: %d  Weak TLS: %d  Unknown TLS: %d  Users with many connections: %d\n",
+		counts[top.FindingPlaintext], counts[top.FindingWeakTLS], counts[top.FindingUnknownTLS], counts[top.FindingUserConns])
+	if !engine.Settings().DisplayAuth {
+		text += "  Users are not checked unless requesting auth details.\n"
+	}
+	text += "\n"
+
+	text += fmt.Sprintf(DEFAULT_PADDING+securityHeaderFormat, "KIND", "CID", "ADDRESS", "USER", "DETAIL")
+	for _, finding := range findings {
+		var cid, address string
+		if finding.Kind != top.FindingUserConns {
+			cid = fmt.Sprintf("%d", finding.Cid)
+			address = fmt.Sprintf("%s:%d", finding.IP, finding.Port)
+		}
+		text += fmt.Sprintf(DEFAULT_PADDING+securityRowFormat, finding.Kind, cid, address, finding.User, finding.Detail)
+	}
+
+	return text
+}
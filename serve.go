@@ -0,0 +1,67 @@
+// Copyright (c) 2016 NATS Messaging System
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	top "github.com/nats-io/nats-top/util"
+)
+
+// runServe polls the server once for all the instances of
+// nats-top attached to the daemon at the address.
+func runServe(engine *top.Engine, addr string) error {
+	// Attached clients sort and limit the connections locally, so the
+	// daemon polls all of them. Auth details are only requested with
+	// -auth and subscriptions with -subs, since the snapshots are
+	// streamed to anyone who can reach the daemon.
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "n" || f.Name == "sort" {
+			err = fmt.Errorf("-%s is set by each attached instance rather than by the daemon", f.Name)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	engine.AllConns = true
+	engine.PollJetStream = true
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := top.NewSnapshotServer()
+	go func() {
+		log.Fatalf("nats-top: %s", server.Serve(l))
+	}()
+	log.Printf("nats-top: serving snapshots at %s", l.Addr())
+
+	last := time.Now()
+	for engine.WaitNextPoll(last) {
+		last = time.Now()
+		snap := engine.Poll()
+		if err := engine.Record(snap); err != nil {
+			log.Printf("nats-top: %s", err)
+		}
+		if err := server.Publish(snap); err != nil {
+			log.Printf("nats-top: %s", err)
+		}
+	}
+	return nil
+}
+
+// monitorStats polls the server, unless attached to a daemon
+// which polls it instead or replaying a recording.
+func monitorStats(engine *top.Engine) error {
+	if player != nil {
+		return engine.ReplayStats(player)
+	}
+	if *attachAddr != "" {
+		return engine.AttachStats(*attachAddr)
+	}
+	return engine.MonitorStats()
+}
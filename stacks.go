@@ -0,0 +1,119 @@
+// Copyright (c) 2016 NATS Messaging System
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	top "github.com/nats-io/nats-top/util"
+)
+
+// stacksView holds the goroutine stacks fetched from the server
+// along with the filter and the groups which have been expanded.
+type stacksView struct {
+	groups    []*top.StackGroup
+	fetchedAt time.Time
+	err       error
+	filter    string
+	expanded  map[string]bool
+
+	// Groups as displayed after filtering, used to find
+	// which group to expand from its number on the screen.
+	displayed []*top.StackGroup
+}
+
+func newStacksView() *stacksView {
+	return &stacksView{expanded: make(map[string]bool)}
+}
+
+// fetchStacks requests the goroutines dump from the server.
+func fetchStacks(engine *top.Engine) ([]byte, error) {
+	result, err := engine.Request("/stacksz")
+	if err != nil {
+		return nil, err
+	}
+	data, ok := result.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("could not get /stacksz from server")
+	}
+	return data, nil
+}
+
+// update replaces the stacks with a new goroutines dump.
+func (v *stacksView) update(data []byte, err error) {
+	v.fetchedAt = time.Now()
+	v.err = err
+	if err == nil {
+		v.groups = top.ParseStacks(data)
+	}
+}
+
+// toggle expands or collapses a group by its number on the screen.
+func (v *stacksView) toggle(n int) {
+	if n < 1 || n > len(v.displayed) {
+		return
+	}
+	key := v.displayed[n-1].Key
+	v.expanded[key] = !v.expanded[key]
+}
+
+// expandAll expands or collapses all the displayed groups.
+func (v *stacksView) expandAll(expand bool) {
+	for _, group := range v.displayed {
+		v.expanded[group.Key] = expand
+	}
+}
+
+// generateStacks returns the goroutine stacks grouped by identical
+// stacks, showing all the frames only for the expanded groups.
+func (v *stacksView) generateStacks() string {
+	if v.fetchedAt.IsZero() {
+		return "Fetching goroutine stacks from server...\n"
+	}
+
+	v.displayed = top.FilterStacks(v.groups, v.filter)
+
+	var goroutines int
+	for _, group := range v.displayed {
+		goroutines += group.Count
+	}
+
+	var filter string
+	if v.filter != "" {
+		filter = fmt.Sprintf(" matching '%s'", v.filter)
+	}
+
+	// Second line is left empty for prompting options
+	text := fmt.Sprintf("Goroutine stacks: %d goroutines in %d groups%s (fetched: %s) %s\n\n",
+		goroutines, len(v.displayed), filter, v.fetchedAt.Format(time.RFC3339), errorString(v.err))
+	text += fmt.Sprintf("  %-4s  %-6s  %-20s  %s\n", "#", "COUNT", "STATE", "FUNCTION")
+
+	for i, group := range v.displayed {
+		marker := "+"
+		if v.expanded[group.Key] {
+			marker = "-"
+		}
+		text += fmt.Sprintf("%s %-4d  %-6d  %-20s  %s\n", marker, i+1, group.Count, group.State, group.TopFunc())
+		if !v.expanded[group.Key] {
+			continue
+		}
+		for _, frame := range group.Frames {
+			text += fmt.Sprintf("%38s%s\n", "", frame.Func)
+			if frame.File != "" {
+				text += fmt.Sprintf("%42s%s\n", "", frame.File)
+			}
+		}
+		text += "\n"
+	}
+
+	return text
+}
+
+// errorString returns the message from an error or empty otherwise.
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return strings.TrimSpace(err.Error())
+}
@@ -0,0 +1,249 @@
+// Copyright (c) 2016 NATS Messaging System
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"sync/atomic"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+	top "github.com/nats-io/nats-top/util"
+)
+
+// Columns of the connections tables which are as wide as their values
+const (
+	hostColumn = iota
+	nameColumn
+	userColumn
+	tlsCipherColumn
+	extColumnsStart
+)
+
+// screenRows is the height of the screen, which limits how many rows
+// of the tables are formatted since the rest would not be visible.
+// It is zero when unknown, in which case all of them are formatted.
+var screenRows int32
+
+func setScreenRows(rows int) {
+	atomic.StoreInt32(&screenRows, int32(rows))
+}
+
+// rowsLeft returns the number of lines still visible below the text.
+func rowsLeft(text string) int {
+	rows := int(atomic.LoadInt32(&screenRows))
+	if rows <= 0 {
+		return math.MaxInt32
+	}
+	if left := rows - strings.Count(text, "\n"); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// connsTable formats a table of connections, keeping the widths of
+// its columns and its buffers from one render to the next. Tables are
+// only rendered from the goroutine updating the views.
+type connsTable struct {
+	widths    *top.ColumnWidths
+	rowWidths []int
+	args      []interface{}
+	buf       bytes.Buffer
+}
+
+func newConnsTable() *connsTable {
+	columns := extColumnsStart + len(newConnExtColumns())
+	return &connsTable{
+		widths:    top.NewColumnWidths(columns),
+		rowWidths: make([]int, columns),
+	}
+}
+
+// Tables of the connections polled, of the expanded group and of the
+// account being drilled into, which are made of different connections.
+var (
+	polledConnsTable  = newConnsTable()
+	groupConnsTable   = newConnsTable()
+	accountConnsTable = newConnsTable()
+)
+
+// hostLen returns the length of the address from resolveHost
+// without formatting it.
+func hostLen(conn gnatsd.ConnInfo) int {
+	if dnsLookupEnabled() {
+		if hostname, ok := resolver.Lookup(conn.IP); ok {
+			return len(hostname)
+		}
+	}
+	digits := 1
+	for port := conn.Port; port >= 10; port /= 10 {
+		digits++
+	}
+	return len(conn.IP) + 1 + digits
+}
+
+// generate returns the formatted header and the rows for the
+// connections, up to the number of rows which fit in the screen.
+func (t *connsTable) generate(engine *top.Engine, conns []gnatsd.ConnInfo, ext map[uint64]*top.ConnInfoExt, maxRows int) string {
+	settings := engine.Settings()
+	displaySubs := settings.DisplaySubs
+	displayAuth := settings.DisplayAuth
+	extColumns := newConnExtColumns()
+
+	// Every connection is measured, which only takes the lengths of its
+	// values, while the widest ones are only looked for again among the
+	// columns of the connections which changed.
+	empty := &top.ConnInfoExt{}
+	for _, conn := range conns {
+		t.rowWidths[hostColumn] = hostLen(conn)
+		t.rowWidths[nameColumn] = len(conn.Name)
+		t.rowWidths[userColumn] = len(conn.AuthorizedUser)
+		t.rowWidths[tlsCipherColumn] = len(conn.TLSCipher)
+
+		connExt, ok := ext[conn.Cid]
+		if !ok {
+			connExt = empty
+		}
+		for i, col := range extColumns {
+			t.rowWidths[extColumnsStart+i] = len(col.value(connExt))
+		}
+		t.widths.Set(conn.Cid, t.rowWidths)
+	}
+	t.widths.Sweep()
+
+	hostSize := DEFAULT_HOST_PADDING_SIZE
+	if size := t.widths.Width(hostColumn); size > hostSize {
+		hostSize = size + DEFAULT_PADDING_SIZE
+	}
+
+	// Disable name unless we have seen one using it
+	nameSize := 0
+	if size := t.widths.Width(nameColumn); size > 0 {
+		nameSize = size + DEFAULT_PADDING_SIZE
+
+		// If using name, ensure that it is not too small...
+		if minLen := len("NAME"); nameSize < minLen {
+			nameSize = minLen
+		}
+	}
+
+	// User and TLS columns are shown when requesting auth details
+	userSize := len("USER") + DEFAULT_PADDING_SIZE
+	tlsVersionSize := len("TLS_VERSION") + DEFAULT_PADDING_SIZE
+	tlsCipherSize := len("TLS_CIPHER") + DEFAULT_PADDING_SIZE
+	if displayAuth {
+		if size := t.widths.Width(userColumn) + DEFAULT_PADDING_SIZE; size > userSize {
+			userSize = size
+		}
+		if size := t.widths.Width(tlsCipherColumn) + DEFAULT_PADDING_SIZE; size > tlsCipherSize {
+			tlsCipherSize = size
+		}
+	}
+
+	// Optional columns are disabled unless we have seen a value for them
+	shownExtColumns := make([]*connExtColumn, 0)
+	for i, col := range extColumns {
+		if size := t.widths.Width(extColumnsStart + i); size > 0 {
+			col.size = size + DEFAULT_PADDING_SIZE
+			if col.size < len(col.header) {
+				col.size = len(col.header)
+			}
+			shownExtColumns = append(shownExtColumns, col)
+		}
+	}
+
+	// Dynamically add columns and padding depending
+	header := make([]interface{}, 0)
+	connHeader := DEFAULT_PADDING
+	connValues := DEFAULT_PADDING
+
+	// HOST: e.g. 192.168.1.1:78901
+	header = append(header, "HOST")
+	connHeader += "%-" + fmt.Sprintf("%d", hostSize) + "s "
+	connValues += "%-" + fmt.Sprintf("%d", hostSize) + "s "
+
+	// CID: e.g. 1234
+	header = append(header, "CID")
+	connHeader += " %-6s "
+	connValues += " %-6d "
+
+	// NAME: e.g. hello
+	if nameSize > 0 {
+		header = append(header, "NAME")
+		connHeader += "%-" + fmt.Sprintf("%d", nameSize) + "s "
+		connValues += "%-" + fmt.Sprintf("%d", nameSize) + "s "
+	}
+
+	// KIND: e.g. Client, ACCOUNT: e.g. $G, RTT: e.g. 1.2ms
+	for _, col := range shownExtColumns {
+		header = append(header, col.header)
+		connHeader += "%-" + fmt.Sprintf("%d", col.size) + "s "
+		connValues += "%-" + fmt.Sprintf("%d", col.size) + "s "
+	}
+
+	// USER: e.g. alice, TLS_VERSION: e.g. 1.2, TLS_CIPHER: e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	if displayAuth {
+		header = append(header, "USER", "TLS_VERSION", "TLS_CIPHER")
+		for _, size := range []int{userSize, tlsVersionSize, tlsCipherSize} {
+			connHeader += "%-" + fmt.Sprintf("%d", size) + "s "
+			connValues += "%-" + fmt.Sprintf("%d", size) + "s "
+		}
+	}
+
+	header = append(header, "SUBS", "PENDING", "MSGS_TO", "MSGS_FROM", "BYTES_TO", "BYTES_FROM", "LANG", "VERSION", "UPTIME", "LAST ACTIVITY")
+	connHeader += defaultHeaderFormat
+	connValues += defaultRowFormat
+	if displaySubs {
+		header = append(header, "SUBSCRIPTIONS")
+		connHeader += "%13s"
+		connValues += "%s"
+	}
+	connHeader += "\n"
+	connValues += "\n"
+
+	t.buf.Reset()
+	fmt.Fprintf(&t.buf, connHeader, header...)
+
+	// Rows below the screen would not be visible
+	rows := len(conns)
+	if maxRows-1 < rows {
+		rows = maxRows - 1
+	}
+	if rows < 0 {
+		rows = 0
+	}
+	for _, conn := range conns[:rows] {
+		t.args = append(t.args[:0], resolveHost(conn), conn.Cid)
+
+		// Name not included unless present
+		if nameSize > 0 {
+			t.args = append(t.args, conn.Name)
+		}
+
+		connExt, ok := ext[conn.Cid]
+		if !ok {
+			connExt = empty
+		}
+		for _, col := range shownExtColumns {
+			t.args = append(t.args, col.value(connExt))
+		}
+
+		if displayAuth {
+			t.args = append(t.args, conn.AuthorizedUser, conn.TLSVersion, conn.TLSCipher)
+		}
+
+		t.args = append(t.args, conn.NumSubs,
+			top.Psize(int64(conn.Pending)), top.Psize(conn.OutMsgs), top.Psize(conn.InMsgs),
+			top.Psize(conn.OutBytes), top.Psize(conn.InBytes),
+			conn.Lang, conn.Version, conn.Uptime, conn.LastActivity)
+
+		if displaySubs {
+			t.args = append(t.args, strings.Join(conn.Subs, ", "))
+		}
+
+		fmt.Fprintf(&t.buf, connValues, t.args...)
+	}
+
+	return t.buf.String()
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+	top "github.com/nats-io/nats-top/util"
+)
+
+func benchmarkConns(n int) []gnatsd.ConnInfo {
+	conns := make([]gnatsd.ConnInfo, n)
+	for i := range conns {
+		conns[i] = gnatsd.ConnInfo{
+			Cid:      uint64(i + 1),
+			IP:       fmt.Sprintf("10.0.%d.%d", i/256%256, i%256),
+			Port:     40000 + i%20000,
+			Name:     fmt.Sprintf("client-%d", i),
+			NumSubs:  uint32(i % 10),
+			InMsgs:   int64(i * 10),
+			OutMsgs:  int64(i * 20),
+			InBytes:  int64(i * 1000),
+			OutBytes: int64(i * 2000),
+			Lang:     "go",
+			Version:  "1.2.0",
+			Uptime:   "1h2m3s",
+		}
+	}
+	return conns
+}
+
+func TestConnsTableVisibleRows(t *testing.T) {
+	defer setScreenRows(0)
+
+	engine := top.NewEngine("127.0.0.1", 8222, 1024, 1)
+	conns := benchmarkConns(100)
+	conns[99].Name = "a-much-longer-client-name"
+	table := newConnsTable()
+
+	setScreenRows(10)
+	text := table.generate(engine, conns, nil, rowsLeft("line\n"))
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if len(lines) != 9 {
+		t.Fatalf("Expected the header and 8 rows to be formatted. got: %d", len(lines))
+	}
+
+	// Columns are as wide as the values of rows which are not shown
+	if !strings.Contains(lines[0], "NAME"+strings.Repeat(" ", len("a-much-longer-client-name")-len("NAME"))) {
+		t.Fatalf("Expected name column to fit the longest name. got: %q", lines[0])
+	}
+
+	// Widths shrink once the rows are gone
+	text = table.generate(engine, conns[:99], nil, rowsLeft(""))
+	header := strings.SplitN(text, "\n", 2)[0]
+	if strings.Contains(header, "NAME"+strings.Repeat(" ", len("a-much-longer-client-name")-len("NAME"))) {
+		t.Fatalf("Expected name column to shrink. got: %q", header)
+	}
+
+	setScreenRows(0)
+	text = table.generate(engine, conns, nil, rowsLeft(""))
+	if rows := strings.Count(text, "\n"); rows != 101 {
+		t.Fatalf("Expected all rows to be formatted when the screen size is unknown. got: %d", rows)
+	}
+}
+
+func TestGroupsAndTalkersVisibleRows(t *testing.T) {
+	defer setScreenRows(0)
+
+	engine := top.NewEngine("127.0.0.1", 8222, 1024, 1)
+	conns := benchmarkConns(100)
+	stats := &top.Stats{Connz: &gnatsd.Connz{Conns: conns}, Rates: &top.Rates{}}
+
+	setScreenRows(10)
+	text := generateGroupsTable(engine, stats, top.GroupByName, rowsLeft("line\n"))
+	if lines := strings.Count(text, "\n"); lines != 9 {
+		t.Fatalf("Expected the title, header and 7 groups to be formatted. got: %d", lines)
+	}
+
+	// Groups below the screen can still be expanded
+	updateViewSettings(func(v *viewSettings) { v.expandedGroup = "client-99" })
+	defer updateViewSettings(func(v *viewSettings) { v.expandedGroup = "" })
+	setScreenRows(0)
+	text = generateGroupsTable(engine, stats, top.GroupByName, rowsLeft(""))
+	if !strings.Contains(text, "Connections in name client-99: 1") || strings.Count(text, "\n") != 106 {
+		t.Fatalf("Expected all groups and the expanded one to be formatted. got: %q", text)
+	}
+
+	engine.History = top.NewConnHistory(time.Minute)
+	engine.History.SetEnabled(true)
+	now := time.Now()
+	engine.History.Add(now.Add(-time.Second), conns)
+	engine.History.Add(now, conns)
+
+	setScreenRows(10)
+	text = generateTalkersTable(engine, time.Minute, rowsLeft("line\n"))
+	if lines := strings.Count(text, "\n"); lines != 9 {
+		t.Fatalf("Expected the title, header and 7 talkers to be formatted. got: %d", lines)
+	}
+	setScreenRows(0)
+	text = generateTalkersTable(engine, time.Minute, rowsLeft(""))
+	if lines := strings.Count(text, "\n"); lines != 102 {
+		t.Fatalf("Expected all talkers to be formatted when the screen size is unknown. got: %d", lines)
+	}
+}
+
+func benchmarkConnsTable(b *testing.B, rows int) {
+	defer setScreenRows(0)
+
+	engine := top.NewEngine("127.0.0.1", 8222, 100000, 1)
+	conns := benchmarkConns(100000)
+	table := newConnsTable()
+	setScreenRows(rows)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Some of the connections change between polls
+		conns[i%len(conns)].Name += "-"
+		table.generate(engine, conns, nil, rowsLeft(""))
+	}
+}
+
+func BenchmarkConnsTable100kVisible(b *testing.B) {
+	benchmarkConnsTable(b, 50)
+}
+
+func BenchmarkConnsTable100kAll(b *testing.B) {
+	benchmarkConnsTable(b, 0)
+}
+
+func benchmarkStats(n int) *top.Stats {
+	conns := benchmarkConns(n)
+	rates := make(map[uint64]*top.ConnRates, n)
+	for _, conn := range conns {
+		rates[conn.Cid] = &top.ConnRates{InMsgsRate: 1, OutMsgsRate: 2}
+	}
+	return &top.Stats{
+		Varz:     &gnatsd.Varz{},
+		Connz:    &gnatsd.Connz{NumConns: n, Total: n, Conns: conns},
+		Routez:   &gnatsd.Routez{},
+		VarzExt:  &top.VarzExt{},
+		ConnsExt: make(map[uint64]*top.ConnInfoExt),
+		Rates:    &top.Rates{Connections: rates},
+		Error:    fmt.Errorf(""),
+	}
+}
+
+// BenchmarkUpdate100k generates the top view on screen, which is
+// what the UI does with the stats from every poll.
+func BenchmarkUpdate100k(b *testing.B) {
+	defer setScreenRows(0)
+
+	engine := top.NewEngine("127.0.0.1", 8222, 100000, 1)
+	stats := benchmarkStats(100000)
+	setScreenRows(50)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		stats.Connz.Conns[i%len(stats.Connz.Conns)].Name += "-"
+		if _, ok := generateView(engine, TopViewMode, stats); !ok {
+			b.Fatal("Expected the top view to be generated")
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2016 NATS Messaging System
+package main
+
+import (
+	"fmt"
+
+	top "github.com/nats-io/nats-top/util"
+)
+
+const (
+	// Chopped: ADDRESS NAME ACCOUNT...
+	leafsHeaderFormat = "%-8s  %-6s  %-10s  %-10s  %-10s  %-10s  %-11s  %-13s  %-12s  %-14s"
+	leafsRowFormat    = "%-8s  %-6d  %-10s  %-10s  %-10s  %-10s  %-11.1f  %-13.1f  %-12s  %-14s"
+
+	// Chopped: GATEWAY...
+	gatewaysHeaderFormat = "%-9s  %-6s  %-21s  %-10s  %-8s  %-6s  %-10s  %-10s  %-10s  %-10s  %-10s  %-11s  %-13s  %-12s  %-14s"
+	gatewaysRowFormat    = "%-9s  %-6d  %-21s  %-10t  %-8s  %-6d  %-10s  %-10s  %-10s  %-10s  %-10s  %-11.1f  %-13.1f  %-12s  %-14s"
+)
+
+// unavailableEndpoint returns the message shown in place
+// of a view when the server does not have its endpoint.
+func unavailableEndpoint(engine *top.Engine, path string) string {
+	version := "unknown"
+	if engine.Capabilities != nil && engine.Capabilities.Version != "" {
+		version = engine.Capabilities.Version
+	}
+	return fmt.Sprintf("%s is not available from this server (version: %s)\n", path, version)
+}
+
+// generateLeafsTable returns the formatted header and rows
+// for the leafnode connections of the server.
+func generateLeafsTable(engine *top.Engine, stats *top.Stats) string {
+	if engine.Capabilities != nil && !engine.Capabilities.HasEndpoint("/leafz") {
+		return unavailableEndpoint(engine, "/leafz")
+	}
+	leafz := stats.Leafz
+	if leafz == nil {
+		leafz = &top.Leafz{}
+	}
+
+	addrSize := DEFAULT_HOST_PADDING_SIZE
+	nameSize := len("NAME")
+	accountSize := len("ACCOUNT")
+	for _, leaf := range leafz.Leafs {
+		if size := len(leaf.Key()) + DEFAULT_PADDING_SIZE; size > addrSize {
+			addrSize = size
+		}
+		if size := len(leaf.Name) + DEFAULT_PADDING_SIZE; size > nameSize {
+			nameSize = size
+		}
+		if size := len(leaf.Account) + DEFAULT_PADDING_SIZE; size > accountSize {
+			accountSize = size
+		}
+	}
+
+	text := fmt.Sprintf("Leafnodes: %d  %s\n", leafz.NumLeafs, stats.EndpointError("/leafz"))
+
+	leafHeader := DEFAULT_PADDING
+	leafHeader += "%-" + fmt.Sprintf("%d", addrSize) + "s "
+	leafHeader += "%-" + fmt.Sprintf("%d", nameSize) + "s "
+	leafHeader += "%-" + fmt.Sprintf("%d", accountSize) + "s "
+	leafHeader += leafsHeaderFormat + "\n"
+	text += fmt.Sprintf(leafHeader, "ADDRESS", "NAME", "ACCOUNT", "RTT", "SUBS",
+		"MSGS_TO", "MSGS_FROM", "BYTES_TO", "BYTES_FROM",
+		"MSGS_TO/S", "MSGS_FROM/S", "BYTES_TO/S", "BYTES_FROM/S")
+
+	leafValues := DEFAULT_PADDING
+	leafValues += "%-" + fmt.Sprintf("%d", addrSize) + "s "
+	leafValues += "%-" + fmt.Sprintf("%d", nameSize) + "s "
+	leafValues += "%-" + fmt.Sprintf("%d", accountSize) + "s "
+	leafValues += leafsRowFormat + "\n"
+
+	for _, leaf := range leafz.Leafs {
+		rates := stats.Rates.Leafs[leaf.Key()]
+		if rates == nil {
+			rates = &top.ConnRates{}
+		}
+		text += fmt.Sprintf(leafValues, leaf.Key(), leaf.Name, leaf.Account, leaf.RTT, leaf.NumSubs,
+			top.Psize(leaf.OutMsgs), top.Psize(leaf.InMsgs),
+			top.Psize(leaf.OutBytes), top.Psize(leaf.InBytes),
+			rates.OutMsgsRate, rates.InMsgsRate,
+			top.Psize(int64(rates.OutBytesRate)), top.Psize(int64(rates.InBytesRate)))
+	}
+
+	return text
+}
+
+// generateGatewaysTable returns the formatted header and rows for
+// the outbound and inbound gateway connections of the server.
+func generateGatewaysTable(engine *top.Engine, stats *top.Stats) string {
+	if engine.Capabilities != nil && !engine.Capabilities.HasEndpoint("/gatewayz") {
+		return unavailableEndpoint(engine, "/gatewayz")
+	}
+	gatewayz := stats.Gatewayz
+	if gatewayz == nil {
+		gatewayz = &top.Gatewayz{}
+	}
+	peers := top.GatewayPeers(gatewayz)
+
+	nameSize := len("GATEWAY") + DEFAULT_PADDING_SIZE
+	for _, peer := range peers {
+		if size := len(peer.Name) + DEFAULT_PADDING_SIZE; size > nameSize {
+			nameSize = size
+		}
+	}
+
+	var outbound, inbound int
+	for _, peer := range peers {
+		if peer.Direction == top.GatewayOutbound {
+			outbound++
+		} else {
+			inbound++
+		}
+	}
+
+	text := fmt.Sprintf("Gateway: %s  Outbound: %d  Inbound: %d  %s\n",
+		gatewayz.Name, outbound, inbound, stats.EndpointError("/gatewayz"))
+
+	gatewayHeader := DEFAULT_PADDING
+	gatewayHeader += "%-" + fmt.Sprintf("%d", nameSize) + "s "
+	gatewayHeader += gatewaysHeaderFormat + "\n"
+	text += fmt.Sprintf(gatewayHeader, "GATEWAY", "DIRECTION", "CID", "ADDRESS", "CONFIGURED", "RTT", "SUBS",
+		"PENDING", "MSGS_TO", "MSGS_FROM", "BYTES_TO", "BYTES_FROM",
+		"MSGS_TO/S", "MSGS_FROM/S", "BYTES_TO/S", "BYTES_FROM/S")
+
+	gatewayValues := DEFAULT_PADDING
+	gatewayValues += "%-" + fmt.Sprintf("%d", nameSize) + "s "
+	gatewayValues += gatewaysRowFormat + "\n"
+
+	for _, peer := range peers {
+		conn := peer.Conn
+		rates := stats.Rates.Gateways[peer.Key()]
+		if rates == nil {
+			rates = &top.ConnRates{}
+		}
+		text += fmt.Sprintf(gatewayValues, peer.Name, peer.Direction, conn.Cid,
+			fmt.Sprintf("%s:%d", conn.IP, conn.Port), peer.IsConfigured, conn.RTT, conn.NumSubs,
+			top.Psize(int64(conn.Pending)), top.Psize(conn.OutMsgs), top.Psize(conn.InMsgs),
+			top.Psize(conn.OutBytes), top.Psize(conn.InBytes),
+			rates.OutMsgsRate, rates.InMsgsRate,
+			top.Psize(int64(rates.OutBytesRate)), top.Psize(int64(rates.InBytesRate)))
+	}
+
+	return text
+}
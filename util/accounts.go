@@ -0,0 +1,62 @@
+package toputils
+
+import (
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+// Accountz represents the accounts from /accountz, which is
+// only available in newer versions of the server.
+type Accountz struct {
+	ID            string   `json:"server_id"`
+	SystemAccount string   `json:"system_account,omitempty"`
+	Accounts      []string `json:"accounts,omitempty"`
+}
+
+// AccountConns aggregates the connections by the account they belong
+// to, summing up their counters and rates. Accounts known from /accountz
+// are included even if none of the polled connections belong to them.
+func AccountConns(
+	conns []gnatsd.ConnInfo,
+	ext map[uint64]*ConnInfoExt,
+	rates map[uint64]*ConnRates,
+	accounts []string,
+) []*ConnGroup {
+	groups := make([]*ConnGroup, 0)
+	index := make(map[string]*ConnGroup)
+
+	group := func(key string) *ConnGroup {
+		g, ok := index[key]
+		if !ok {
+			g = &ConnGroup{Key: key, Rates: &ConnRates{}}
+			index[key] = g
+			groups = append(groups, g)
+		}
+		return g
+	}
+
+	for _, account := range accounts {
+		if account != "" {
+			group(account)
+		}
+	}
+	for _, conn := range conns {
+		key := UnknownGroupKey
+		if connExt, ok := ext[conn.Cid]; ok && connExt.Account != "" {
+			key = connExt.Account
+		}
+		group(key).add(conn, rates[conn.Cid])
+	}
+
+	return groups
+}
+
+// HasAccounts returns whether any of the connections reported
+// the account they belong to, which older servers do not.
+func HasAccounts(ext map[uint64]*ConnInfoExt) bool {
+	for _, connExt := range ext {
+		if connExt != nil && connExt.Account != "" {
+			return true
+		}
+	}
+	return false
+}
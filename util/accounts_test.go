@@ -0,0 +1,148 @@
+package toputils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+func TestAccountConns(t *testing.T) {
+	conns := []gnatsd.ConnInfo{
+		{Cid: 1, NumSubs: 2, InMsgs: 10, OutBytes: 100},
+		{Cid: 2, NumSubs: 3, InMsgs: 20, OutBytes: 200},
+		{Cid: 3, NumSubs: 1, InMsgs: 5},
+		{Cid: 4},
+	}
+	ext := map[uint64]*ConnInfoExt{
+		1: {Cid: 1, Account: "ORDERS"},
+		2: {Cid: 2, Account: "ORDERS"},
+		3: {Cid: 3, Account: "$G"},
+	}
+	rates := map[uint64]*ConnRates{
+		1: {InMsgsRate: 1.5},
+		2: {InMsgsRate: 2.5},
+	}
+
+	groups := AccountConns(conns, ext, rates, []string{"$G", "BILLING", "ORDERS"})
+	SortGroups(groups, GroupSortByKey)
+
+	expected := []string{"$G", "-", "BILLING", "ORDERS"}
+	if len(groups) != len(expected) {
+		t.Fatalf("Wrong number of accounts. expected: %v, got: %v", len(expected), len(groups))
+	}
+	for i, key := range expected {
+		if groups[i].Key != key {
+			t.Fatalf("Wrong account at %d. expected: %v, got: %v", i, key, groups[i].Key)
+		}
+	}
+
+	orders := groups[3]
+	if orders.NumConns != 2 || orders.NumSubs != 5 || orders.InMsgs != 30 || orders.OutBytes != 300 {
+		t.Fatalf("Wrong totals for account. got: %+v", orders)
+	}
+	if orders.Rates.InMsgsRate != 4 {
+		t.Fatalf("Wrong rates for account. expected: %v, got: %v", 4, orders.Rates.InMsgsRate)
+	}
+	if groups[2].NumConns != 0 {
+		t.Fatalf("Expected account without connections. got: %+v", groups[2])
+	}
+
+	if !HasAccounts(ext) || HasAccounts(map[uint64]*ConnInfoExt{1: {Cid: 1}}) {
+		t.Fatalf("Wrong detection of connections with accounts")
+	}
+}
+
+func TestFetchingAccountConns(t *testing.T) {
+	ts := runFixtureServer()
+	defer ts.Close()
+
+	engine := newFixtureEngine(ts)
+	if err := engine.SetupCapabilities(); err != nil {
+		t.Fatalf("Failed detecting capabilities: %v", err)
+	}
+	engine.Account = "ORDERS"
+
+	go engine.MonitorStats()
+	defer close(engine.ShutdownCh)
+
+	var stats *Stats
+	select {
+	case stats = <-engine.StatsCh:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out waiting for stats")
+	}
+	if stats.Error.Error() != "" {
+		t.Fatalf("Failed polling the fixture server: %v", stats.Error)
+	}
+
+	if stats.Accountz == nil || len(stats.Accountz.Accounts) != 4 || stats.Accountz.SystemAccount != "$SYS" {
+		t.Fatalf("Wrong accountz from server. got: %+v", stats.Accountz)
+	}
+	if stats.AccountConnz == nil || len(stats.AccountConnz.Conns) != 1 {
+		t.Fatalf("Expected the connections from the account. got: %+v", stats.AccountConnz)
+	}
+	if conn := stats.AccountConnz.Conns[0]; conn.Cid != 5 || stats.AccountConnsExt[5].Account != "ORDERS" {
+		t.Fatalf("Wrong connection from the account. got: %+v", conn)
+	}
+}
+
+func TestAccountConnsAllConns(t *testing.T) {
+	const total, accounts = 3000, 30
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/connz" {
+			w.Write([]byte("{}"))
+			return
+		}
+		q := r.URL.Query()
+		offset, _ := strconv.Atoi(q.Get("offset"))
+		limit, _ := strconv.Atoi(q.Get("limit"))
+
+		// Each account has its connections spread over all the cids
+		conns := make([]map[string]interface{}, 0)
+		for cid := offset + 1; cid <= total && cid <= offset+limit; cid++ {
+			conns = append(conns, map[string]interface{}{
+				"cid": cid, "subscriptions": 2, "in_msgs": 10,
+				"account": fmt.Sprintf("ACC%d", cid%accounts),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"total": total, "offset": offset, "limit": limit,
+			"num_connections": len(conns), "connections": conns,
+		})
+	}))
+	defer ts.Close()
+
+	engine := NewEngine("", 0, 10, 1)
+	engine.HttpClient = &http.Client{}
+	engine.Uri = ts.URL
+
+	// Rollup of the polled connections only covers a few accounts
+	stats := &Stats{}
+	if err := engine.poll(true).decode(stats); err != nil {
+		t.Fatalf("Failed polling: %v", err)
+	}
+	if groups := AccountConns(stats.Connz.Conns, stats.ConnsExt, nil, nil); len(groups) != 10 {
+		t.Fatalf("Expected accounts from the polled connections. got: %d", len(groups))
+	}
+
+	engine.UpdateSettings(func(s *Settings) { s.AllConns = true })
+	stats = &Stats{}
+	if err := engine.poll(true).decode(stats); err != nil {
+		t.Fatalf("Failed polling all the connections: %v", err)
+	}
+	groups := AccountConns(stats.Connz.Conns, stats.ConnsExt, nil, nil)
+	if len(groups) != accounts {
+		t.Fatalf("Expected all the accounts. got: %d", len(groups))
+	}
+	for _, group := range groups {
+		if group.NumConns != total/accounts || group.NumSubs != 2*total/accounts || group.InMsgs != 10*total/accounts {
+			t.Fatalf("Wrong totals of account %s. got: %+v", group.Key, group)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package toputils
+
+import (
+	"context"
+	"fmt"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+// The typed requests give up once the deadline of their context is
+// exceeded, or after the RequestTimeout of the engine in case it is
+// shorter, so that each request can have its own timeout with
+// context.WithTimeout without affecting the others.
+
+// Varz returns the general stats of the server from /varz.
+func (engine *Engine) Varz(ctx context.Context) (*gnatsd.Varz, error) {
+	varz := &gnatsd.Varz{}
+	if err := engine.requestInto(ctx, "/varz", nil, varz); err != nil {
+		return nil, err
+	}
+	return varz, nil
+}
+
+// Connz returns the connections of the server from /connz,
+// requested with the options instead of the ones of the engine.
+func (engine *Engine) Connz(ctx context.Context, opts ConnzOptions) (*gnatsd.Connz, error) {
+	connz, _, err := engine.fetchConnz(ctx, &opts)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return connz, nil
+}
+
+// Routez returns the routes of the server from /routez.
+func (engine *Engine) Routez(ctx context.Context) (*gnatsd.Routez, error) {
+	routez := &gnatsd.Routez{}
+	if err := engine.requestInto(ctx, "/routez", nil, routez); err != nil {
+		return nil, err
+	}
+	return routez, nil
+}
+
+// Subsz returns the stats of the subscriptions of the server from /subsz.
+func (engine *Engine) Subsz(ctx context.Context) (*gnatsd.Subsz, error) {
+	subsz := &gnatsd.Subsz{}
+	if err := engine.requestInto(ctx, "/subsz", nil, subsz); err != nil {
+		return nil, err
+	}
+	return subsz, nil
+}
+
+// requestInto fetches the path and decodes the response into v.
+// In case the context is done the error from the context is
+// returned as is, so that callers can tell it apart.
+func (engine *Engine) requestInto(ctx context.Context, path string, opts *ConnzOptions, v interface{}) error {
+	body, err := engine.fetch(ctx, path, opts)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	if err := unmarshalTolerant(body, v); err != nil {
+		return fmt.Errorf("could not unmarshal json: %v\n", err)
+	}
+	return nil
+}
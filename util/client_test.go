@@ -0,0 +1,114 @@
+package toputils
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/gnatsd/server"
+)
+
+func TestTypedRequests(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+	engine.SetupHTTP()
+	s := runMonitorServer(server.DEFAULT_HTTP_PORT)
+	defer s.Shutdown()
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", GNATSD_PORT))
+	if err != nil {
+		t.Fatalf("Could not create connection to NATS: %s", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "CONNECT {}\r\nSUB hello.world 90\r\nPING\r\n")
+	time.Sleep(100 * time.Millisecond)
+
+	ctx := context.Background()
+	varz, err := engine.Varz(ctx)
+	if err != nil || varz.Cores < 1 {
+		t.Fatalf("Could not get varz. got: %+v, %v", varz, err)
+	}
+	connz, err := engine.Connz(ctx, ConnzOptions{Limit: 1, Subs: true})
+	if err != nil || len(connz.Conns) != 1 || len(connz.Conns[0].Subs) != 1 {
+		t.Fatalf("Could not get connz with subscriptions. got: %+v, %v", connz, err)
+	}
+	if _, err := engine.Routez(ctx); err != nil {
+		t.Fatalf("Could not get routez: %v", err)
+	}
+	subsz, err := engine.Subsz(ctx)
+	if err != nil || subsz.SublistStats == nil || subsz.NumSubs != 1 {
+		t.Fatalf("Could not get subsz. got: %+v, %v", subsz, err)
+	}
+}
+
+func TestTypedRequestsTimeouts(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(3 * time.Second):
+		}
+	}))
+	defer ts.Close()
+
+	engine := newFixtureEngine(ts)
+	engine.RequestTimeout = 50 * time.Millisecond
+	start := time.Now()
+	_, err := engine.Varz(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "could not get stats") {
+		t.Fatalf("Expected request to time out. got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Request took too long to time out: %v", elapsed)
+	}
+
+	// Deadlines and cancellation of the context are returned as they are
+	engine.RequestTimeout = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := engine.Connz(ctx, ConnzOptions{}); err != context.DeadlineExceeded {
+		t.Fatalf("Expected deadline to be exceeded. got: %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := engine.Subsz(ctx); err != context.Canceled {
+		t.Fatalf("Expected request to be canceled. got: %v", err)
+	}
+}
+
+func TestTypedRequestsOwnDeadlines(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(200 * time.Millisecond):
+		}
+		body, _ := ioutil.ReadFile(fmt.Sprintf("test%s_v2.json", r.URL.Path))
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	// A deadline only applies to the request it was given to
+	engine := newFixtureEngine(ts)
+	done := make(chan error, 1)
+	go func() {
+		_, err := engine.Varz(context.Background())
+		done <- err
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := engine.Connz(ctx, ConnzOptions{}); err != context.DeadlineExceeded {
+		t.Fatalf("Expected deadline to be exceeded. got: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Expected request without deadline to succeed. got: %v", err)
+	}
+	if connz, err := engine.Connz(context.Background(), ConnzOptions{}); err != nil || len(connz.Conns) == 0 {
+		t.Fatalf("Expected following request to succeed. got: %+v, %v", connz, err)
+	}
+}
@@ -0,0 +1,278 @@
+package toputils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+// DecodeConnz decodes a /connz response as it is read, one connection
+// at a time, so that the whole response is never kept in memory. Both
+// the connections and their newer fields are returned, the latter
+// indexed by their cid. Subscriptions are skipped unless subs is set,
+// since they can be most of the response of a large server. Memory is
+// then bounded by the number of connections in the response, each of
+// them kept decoded once, rather than by the size of the response.
+func DecodeConnz(r io.Reader, subs bool) (*gnatsd.Connz, map[uint64]*ConnInfoExt, error) {
+	dec := json.NewDecoder(r)
+	connz := &gnatsd.Connz{}
+	ext := make(map[uint64]*ConnInfoExt)
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, nil, err
+	}
+
+	// Fields other than the connections are few, and decoded at the end
+	var fields bytes.Buffer
+	fields.WriteByte('{')
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key, _ := tok.(string)
+		if key == "connections" {
+			if err := decodeConns(dec, connz, ext, subs); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		if fields.Len() > 1 {
+			fields.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		fields.Write(name)
+		fields.WriteByte(':')
+		fields.Write(value)
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, nil, err
+	}
+	fields.WriteByte('}')
+
+	if err := unmarshalTolerant(fields.Bytes(), connz); err != nil {
+		return nil, nil, err
+	}
+	return connz, ext, nil
+}
+
+// connInfoWithExt decodes a connection along with its newer fields
+// at once, the cid being the only field which both of them have.
+type connInfoWithExt struct {
+	*gnatsd.ConnInfo
+	*ConnInfoExt
+	Cid uint64 `json:"cid"`
+}
+
+// connInfoWithExtWithoutSubs decodes a connection along with its
+// newer fields ignoring its subscriptions.
+type connInfoWithExtWithoutSubs struct {
+	connInfoWithExt
+	Subs skippedJSON `json:"subscriptions_list"`
+}
+
+// skippedJSON is a value which is not decoded at all.
+type skippedJSON struct{}
+
+func (skippedJSON) UnmarshalJSON([]byte) error {
+	return nil
+}
+
+func decodeConns(dec *json.Decoder, connz *gnatsd.Connz, ext map[uint64]*ConnInfoExt, subs bool) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("expected array of connections, got: %v", tok)
+	}
+
+	// Each connection is decoded straight into its place in the slice
+	for dec.More() {
+		connz.Conns = append(connz.Conns, gnatsd.ConnInfo{})
+		conn := &connInfoWithExt{
+			ConnInfo:    &connz.Conns[len(connz.Conns)-1],
+			ConnInfoExt: &ConnInfoExt{},
+		}
+		if subs {
+			err = dec.Decode(conn)
+		} else {
+			withoutSubs := &connInfoWithExtWithoutSubs{connInfoWithExt: *conn}
+			err = dec.Decode(withoutSubs)
+			conn.Cid = withoutSubs.Cid
+		}
+		if _, ok := err.(*json.UnmarshalTypeError); err != nil && !ok {
+			return err
+		}
+		conn.ConnInfo.Cid = conn.Cid
+		conn.ConnInfoExt.Cid = conn.Cid
+		ext[conn.Cid] = conn.ConnInfoExt
+	}
+
+	return expectDelim(dec, ']')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("expected '%v', got: %v", delim, tok)
+	}
+	return nil
+}
+
+// fetchConnz gets the connections from /connz with the options,
+// decoding the response as it arrives when the source can stream
+// it, otherwise once fetched.
+func (engine *Engine) fetchConnz(ctx context.Context, opts *ConnzOptions) (*gnatsd.Connz, map[uint64]*ConnInfoExt, error) {
+	ctx, cancel := engine.requestContext(ctx)
+	defer cancel()
+
+	var body io.Reader
+	if source, ok := engine.source().(StreamSource); ok {
+		stream, err := source.Open(ctx, "/connz", opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer stream.Close()
+		body = stream
+	} else {
+		data, err := engine.source().Fetch(ctx, "/connz", opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	connz, ext, err := DecodeConnz(body, opts.Subs)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, fmt.Errorf("could not read response body: %v\n", ctx.Err())
+		}
+		return nil, nil, fmt.Errorf("could not unmarshal json: %v\n", err)
+	}
+	return connz, ext, nil
+}
+
+// connzPageSize is the number of connections requested at once
+// when polling all the connections of the server.
+const connzPageSize = 1024
+
+// connzPage is a page of connections from /connz, which are kept
+// as they are so that the pages can be put back together.
+type connzPage struct {
+	Total int               `json:"total"`
+	Conns []json.RawMessage `json:"connections"`
+}
+
+// FetchAllConnz returns the raw response from /connz with all the
+// connections of the server, which are requested a page at a time
+// sorted by cid so that they can be sorted and limited afterwards,
+// e.g. by the instances attached to a daemon. The response is the
+// same as if all of them were requested at once, so the raw json of
+// every connection of the server is kept until it is returned, which
+// is what recordings and the daemon of serve take on every poll.
+func (engine *Engine) FetchAllConnz() ([]byte, error) {
+	opts := engine.connzOptions()
+	opts.Sort = "cid"
+	opts.Limit = connzPageSize
+
+	var fields map[string]json.RawMessage
+	conns := make([]json.RawMessage, 0)
+	for {
+		body, err := engine.fetch(context.Background(), "/connz", opts)
+		if err != nil {
+			return nil, err
+		}
+		page := &connzPage{}
+		if err := unmarshalTolerant(body, page); err != nil {
+			return nil, fmt.Errorf("could not unmarshal json: %v\n", err)
+		}
+		if fields == nil {
+			if err := json.Unmarshal(body, &fields); err != nil {
+				return nil, fmt.Errorf("could not unmarshal json: %v\n", err)
+			}
+		}
+		conns = append(conns, page.Conns...)
+
+		// Connections closed meanwhile shift the ones after them
+		// to previous pages, which are then polled the next time.
+		opts.Offset += len(page.Conns)
+		if len(page.Conns) < opts.Limit || opts.Offset >= page.Total {
+			break
+		}
+	}
+
+	// Other fields are kept as they were in the first page
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for key, value := range fields {
+		switch key {
+		case "connections", "num_connections", "offset", "limit":
+			continue
+		}
+		name, _ := json.Marshal(key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+		buf.WriteByte(',')
+	}
+	fmt.Fprintf(&buf, `"num_connections":%d,"offset":0,"limit":%d,"connections":[`, len(conns), len(conns))
+	for i, conn := range conns {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(conn)
+	}
+	buf.WriteString("]}")
+	return buf.Bytes(), nil
+}
+
+// fetchAllConnz gets all the connections of the server from /connz
+// a page at a time like FetchAllConnz does, decoding each page as it
+// arrives so that only the decoded connections are kept.
+func (engine *Engine) fetchAllConnz(ctx context.Context) (*gnatsd.Connz, map[uint64]*ConnInfoExt, error) {
+	opts := engine.connzOptions()
+	opts.Sort = "cid"
+	opts.Limit = connzPageSize
+
+	var connz *gnatsd.Connz
+	ext := make(map[uint64]*ConnInfoExt)
+	for {
+		page, pageExt, err := engine.fetchConnz(ctx, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		if connz == nil {
+			connz = page
+		} else {
+			connz.Conns = append(connz.Conns, page.Conns...)
+		}
+		for cid, connExt := range pageExt {
+			ext[cid] = connExt
+		}
+
+		opts.Offset += len(page.Conns)
+		if len(page.Conns) < opts.Limit || opts.Offset >= page.Total {
+			break
+		}
+	}
+	connz.NumConns = len(connz.Conns)
+	connz.Offset = 0
+	connz.Limit = len(connz.Conns)
+	return connz, ext, nil
+}
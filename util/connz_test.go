@@ -0,0 +1,175 @@
+package toputils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+func TestDecodeConnz(t *testing.T) {
+	body, err := ioutil.ReadFile("test/connz_v2.json")
+	if err != nil {
+		t.Fatalf("Could not read fixture: %v", err)
+	}
+	body = bytes.Replace(body, []byte(`"subscriptions": 3,`),
+		[]byte(`"subscriptions": 3, "subscriptions_list": ["orders.>", "orders.new", "_INBOX.x"],`), 1)
+
+	result, err := Decode("/connz", body)
+	if err != nil {
+		t.Fatalf("Failed decoding connz: %v", err)
+	}
+	expected := result.(*gnatsd.Connz)
+	expectedExt := DecodeConnsExt(body)
+
+	connz, ext, err := DecodeConnz(bytes.NewReader(body), true)
+	if err != nil {
+		t.Fatalf("Failed decoding connz as it is read: %v", err)
+	}
+	if !reflect.DeepEqual(connz, expected) {
+		t.Fatalf("Wrong connz. expected: %+v, got: %+v", expected, connz)
+	}
+	if !reflect.DeepEqual(ext, expectedExt) {
+		t.Fatalf("Wrong newer fields of connections. expected: %+v, got: %+v", expectedExt, ext)
+	}
+	if len(connz.Conns[0].Subs) != 3 {
+		t.Fatalf("Expected subscriptions to be decoded. got: %v", connz.Conns[0].Subs)
+	}
+
+	// Subscriptions are skipped unless needed
+	connz, ext, err = DecodeConnz(bytes.NewReader(body), false)
+	if err != nil {
+		t.Fatalf("Failed decoding connz without subscriptions: %v", err)
+	}
+	if connz.Conns[0].Subs != nil || connz.Conns[0].NumSubs != 3 || connz.Conns[0].Name != "orders" {
+		t.Fatalf("Wrong connection without subscriptions. got: %+v", connz.Conns[0])
+	}
+	if !reflect.DeepEqual(ext, expectedExt) {
+		t.Fatalf("Wrong newer fields of connections without subscriptions. expected: %+v, got: %+v", expectedExt, ext)
+	}
+
+	// Connections are missing from servers without any
+	connz, ext, err = DecodeConnz(strings.NewReader(`{"num_connections":0,"connections":null}`), false)
+	if err != nil || len(connz.Conns) != 0 || len(ext) != 0 {
+		t.Fatalf("Wrong connz without connections. got: %+v, %v", connz, err)
+	}
+
+	for _, body := range []string{"", "[]", `{"connections":{}}`, `{"connections":[{"cid":1}`} {
+		if _, _, err := DecodeConnz(strings.NewReader(body), false); err == nil {
+			t.Fatalf("Expected error decoding %q", body)
+		}
+	}
+}
+
+func TestRequestConnzGzip(t *testing.T) {
+	body, err := ioutil.ReadFile("test/connz_v2.json")
+	if err != nil {
+		t.Fatalf("Could not read fixture: %v", err)
+	}
+
+	var encodings []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Accept-Encoding"))
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write(body)
+		gz.Close()
+	}))
+	defer ts.Close()
+
+	engine := NewEngine("", 0, 10, 1)
+	engine.HttpClient = &http.Client{}
+	engine.Uri = ts.URL
+
+	result, err := engine.Request("/connz")
+	if err != nil {
+		t.Fatalf("Failed requesting connz: %v", err)
+	}
+	if connz, ok := result.(*gnatsd.Connz); !ok || len(connz.Conns) != 2 || connz.Conns[0].Name != "orders" {
+		t.Fatalf("Wrong connz from compressed response. got: %+v", result)
+	}
+
+	// Raw responses are decompressed as well
+	raw, err := engine.Fetch("/connz")
+	if err != nil || !bytes.Equal(raw, body) {
+		t.Fatalf("Wrong raw response from compressed one. got: %q, %v", raw, err)
+	}
+	for _, encoding := range encodings {
+		if encoding != "gzip" {
+			t.Fatalf("Expected responses to be requested compressed. got: %q", encoding)
+		}
+	}
+
+	// Connections are decoded while polling unless recording
+	snap := engine.poll(true)
+	if snap.Error != "" || len(snap.Connz) != 0 || len(snap.connz.Conns) != 2 {
+		t.Fatalf("Expected connections to be decoded while polling. got: %+v", snap)
+	}
+	stats := &Stats{}
+	if err := snap.decode(stats); err != nil || len(stats.Connz.Conns) != 2 || stats.ConnsExt[5].Account != "ORDERS" {
+		t.Fatalf("Wrong stats from decoded connections. got: %+v, %v", stats.Connz, err)
+	}
+	if snap := engine.Poll(); !bytes.Equal(snap.Connz, body) {
+		t.Fatalf("Expected raw connz in snapshot. got: %q", snap.Connz)
+	}
+
+	// Requests are abandoned while the response is read
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := engine.Connz(ctx, ConnzOptions{}); err != context.Canceled {
+		t.Fatalf("Expected canceled request. got: %v", err)
+	}
+}
+
+func largeConnz(conns, subs int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`{"now":"2023-11-02T12:20:01.112736Z","num_connections":`)
+	fmt.Fprintf(&buf, "%d", conns)
+	buf.WriteString(`,"connections":[`)
+	list := make([]string, subs)
+	for i := range list {
+		list[i] = fmt.Sprintf("orders.%d.updates", i)
+	}
+	subsList, _ := json.Marshal(list)
+	for i := 0; i < conns; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, `{"cid":%d,"kind":"Client","ip":"10.0.0.1","port":%d,"in_msgs":%d,"subscriptions":%d,"subscriptions_list":%s}`,
+			i+1, 4000+i, i*10, subs, subsList)
+	}
+	buf.WriteString(`]}`)
+	return buf.Bytes()
+}
+
+func BenchmarkDecodeConnz(b *testing.B) {
+	body := largeConnz(10000, 50)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := DecodeConnz(bytes.NewReader(body), false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeConnzUnmarshal(b *testing.B) {
+	body := largeConnz(10000, 50)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Decode("/connz", body); err != nil {
+			b.Fatal(err)
+		}
+		DecodeConnsExt(body)
+	}
+}
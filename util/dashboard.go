@@ -0,0 +1,266 @@
+package toputils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+	"golang.org/x/net/websocket"
+)
+
+// DashboardSnapshot is the data pushed to the browsers
+// from the dashboard on each poll.
+type DashboardSnapshot struct {
+	Now    time.Time        `json:"now"`
+	Server *DashboardServer `json:"server"`
+	Conns  []*DashboardConn `json:"connections"`
+	Error  string           `json:"error,omitempty"`
+}
+
+// DashboardServer has the server stats shown in the header.
+type DashboardServer struct {
+	Version          string         `json:"version"`
+	Name             string         `json:"name,omitempty"`
+	Uptime           string         `json:"uptime"`
+	CPU              float64        `json:"cpu"`
+	Mem              int64          `json:"mem"`
+	SlowConsumers    int64          `json:"slow_consumers"`
+	Connections      int            `json:"connections"`
+	TotalConnections uint64         `json:"total_connections"`
+	Subscriptions    uint32         `json:"subscriptions"`
+	InMsgs           int64          `json:"in_msgs"`
+	OutMsgs          int64          `json:"out_msgs"`
+	InBytes          int64          `json:"in_bytes"`
+	OutBytes         int64          `json:"out_bytes"`
+	Rates            DashboardRates `json:"rates"`
+}
+
+// DashboardConn has the columns of a connection shown in the
+// dashboard along with its rates, leaving out the rest such as
+// its user and TLS details which are not sent to the browsers.
+type DashboardConn struct {
+	Cid          uint64         `json:"cid"`
+	IP           string         `json:"ip"`
+	Port         int            `json:"port"`
+	Name         string         `json:"name,omitempty"`
+	NumSubs      uint32         `json:"subscriptions"`
+	Pending      int            `json:"pending_bytes"`
+	InMsgs       int64          `json:"in_msgs"`
+	OutMsgs      int64          `json:"out_msgs"`
+	InBytes      int64          `json:"in_bytes"`
+	OutBytes     int64          `json:"out_bytes"`
+	Lang         string         `json:"lang,omitempty"`
+	Version      string         `json:"version,omitempty"`
+	Uptime       string         `json:"uptime"`
+	LastActivity time.Time      `json:"last_activity"`
+	Rates        DashboardRates `json:"rates"`
+}
+
+func newDashboardConn(conn gnatsd.ConnInfo) *DashboardConn {
+	return &DashboardConn{
+		Cid:          conn.Cid,
+		IP:           conn.IP,
+		Port:         conn.Port,
+		Name:         conn.Name,
+		NumSubs:      conn.NumSubs,
+		Pending:      conn.Pending,
+		InMsgs:       conn.InMsgs,
+		OutMsgs:      conn.OutMsgs,
+		InBytes:      conn.InBytes,
+		OutBytes:     conn.OutBytes,
+		Lang:         conn.Lang,
+		Version:      conn.Version,
+		Uptime:       conn.Uptime,
+		LastActivity: conn.LastActivity,
+	}
+}
+
+// DashboardRates are the in/out msgs and bytes rates.
+type DashboardRates struct {
+	InMsgs   float64 `json:"in_msgs"`
+	OutMsgs  float64 `json:"out_msgs"`
+	InBytes  float64 `json:"in_bytes"`
+	OutBytes float64 `json:"out_bytes"`
+}
+
+// NewDashboardSnapshot returns the data for the dashboard from the stats.
+func NewDashboardSnapshot(stats *Stats) *DashboardSnapshot {
+	snapshot := &DashboardSnapshot{
+		Now:    time.Now(),
+		Server: &DashboardServer{},
+		Conns:  make([]*DashboardConn, 0),
+	}
+	if stats.Error != nil {
+		snapshot.Error = stats.Error.Error()
+	}
+
+	if varz := stats.Varz; varz != nil {
+		server := snapshot.Server
+		if varz.Info != nil {
+			server.Version = varz.Info.Version
+		}
+		server.Uptime = varz.Uptime
+		server.CPU = varz.CPU
+		server.Mem = varz.Mem
+		server.SlowConsumers = varz.SlowConsumers
+		server.Connections = varz.Connections
+		server.TotalConnections = varz.TotalConnections
+		server.Subscriptions = varz.Subscriptions
+		server.InMsgs = varz.InMsgs
+		server.OutMsgs = varz.OutMsgs
+		server.InBytes = varz.InBytes
+		server.OutBytes = varz.OutBytes
+	}
+	if stats.VarzExt != nil {
+		snapshot.Server.Name = stats.VarzExt.ServerName
+	}
+
+	rates := stats.Rates
+	if rates == nil {
+		rates = &Rates{}
+	}
+	snapshot.Server.Rates = DashboardRates{rates.InMsgsRate, rates.OutMsgsRate, rates.InBytesRate, rates.OutBytesRate}
+
+	if stats.Connz != nil {
+		for _, conn := range stats.Connz.Conns {
+			c := newDashboardConn(conn)
+			if r, ok := rates.Connections[conn.Cid]; ok && r != nil {
+				c.Rates = DashboardRates{r.InMsgsRate, r.OutMsgsRate, r.InBytesRate, r.OutBytesRate}
+			}
+			snapshot.Conns = append(snapshot.Conns, c)
+		}
+	}
+
+	return snapshot
+}
+
+// dashboardClientBuffer is the number of snapshots buffered for each
+// browser, after which the oldest ones are dropped for slow browsers.
+const dashboardClientBuffer = 4
+
+// Dashboard serves a web page which displays the stats
+// pushed to the browsers over a WebSocket.
+type Dashboard struct {
+	sync.Mutex
+	clients map[chan []byte]struct{}
+	last    []byte
+}
+
+// NewDashboard returns a dashboard without any stats yet.
+func NewDashboard() *Dashboard {
+	return &Dashboard{clients: make(map[chan []byte]struct{})}
+}
+
+// Publish sends the stats to all the connected browsers.
+func (d *Dashboard) Publish(stats *Stats) error {
+	data, err := json.Marshal(NewDashboardSnapshot(stats))
+	if err != nil {
+		return fmt.Errorf("could not marshal stats: %v", err)
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	d.last = data
+	for ch := range d.clients {
+		sendDropOldest(ch, data)
+	}
+	return nil
+}
+
+// NumClients returns the number of connected browsers.
+func (d *Dashboard) NumClients() int {
+	d.Lock()
+	defer d.Unlock()
+	return len(d.clients)
+}
+
+// sendDropOldest sends without blocking, discarding the oldest
+// buffered value in case the channel is full.
+func sendDropOldest(ch chan []byte, data []byte) {
+	for {
+		select {
+		case ch <- data:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// Handler returns the handler for the page at / and the
+// WebSocket at /ws from which browsers get the stats.
+func (d *Dashboard) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, dashboardHTML)
+	})
+	mux.Handle("/ws", websocket.Server{Handler: d.serveWebSocket, Handshake: checkSameOrigin})
+	return mux
+}
+
+// checkSameOrigin only accepts WebSockets opened by the dashboard page
+// itself, rather than by any other page visited by the browser.
+func checkSameOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin == nil || origin.Host != r.Host {
+		return fmt.Errorf("origin not allowed: %v", origin)
+	}
+	config.Origin = origin
+	return nil
+}
+
+func (d *Dashboard) serveWebSocket(ws *websocket.Conn) {
+	defer ws.Close()
+
+	ch := make(chan []byte, dashboardClientBuffer)
+	d.Lock()
+	d.clients[ch] = struct{}{}
+	if d.last != nil {
+		ch <- d.last
+	}
+	d.Unlock()
+
+	defer func() {
+		d.Lock()
+		delete(d.clients, ch)
+		d.Unlock()
+	}()
+
+	// Browsers do not send anything, so reading
+	// only detects when they are gone.
+	done := make(chan struct{})
+	go func() {
+		var msg string
+		for {
+			if err := websocket.Message.Receive(ws, &msg); err != nil {
+				close(done)
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case data := <-ch:
+			if err := websocket.Message.Send(ws, string(data)); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package toputils
+
+// dashboardHTML is the page served by the dashboard, which renders
+// the snapshots received over the WebSocket the same way as the
+// top view, allowing to sort the connections by any column.
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>nats-top</title>
+<style>
+  body { font-family: monospace; font-size: 13px; margin: 16px; background: #fff; color: #222; }
+  pre { margin: 0 0 12px 0; }
+  table { border-collapse: collapse; }
+  th, td { padding: 2px 10px 2px 0; text-align: left; white-space: nowrap; }
+  th { cursor: pointer; border-bottom: 1px solid #999; user-select: none; }
+  th.sorted { text-decoration: underline; }
+  #status { color: #a00; }
+</style>
+</head>
+<body>
+<pre id="header">Waiting for stats...</pre>
+<div id="status"></div>
+<table>
+  <thead><tr id="columns"></tr></thead>
+  <tbody id="rows"></tbody>
+</table>
+<script>
+(function() {
+  // Same columns as the top view, MSGS_TO being the msgs sent to the client
+  var columns = [
+    ["HOST", function(c) { return c.ip + ":" + c.port; }, "str"],
+    ["CID", function(c) { return c.cid; }, "num"],
+    ["NAME", function(c) { return c.name || ""; }, "str"],
+    ["SUBS", function(c) { return c.subscriptions; }, "num"],
+    ["PENDING", function(c) { return c.pending_bytes; }, "size"],
+    ["MSGS_TO", function(c) { return c.out_msgs; }, "size"],
+    ["MSGS_FROM", function(c) { return c.in_msgs; }, "size"],
+    ["BYTES_TO", function(c) { return c.out_bytes; }, "size"],
+    ["BYTES_FROM", function(c) { return c.in_bytes; }, "size"],
+    ["MSGS_TO/S", function(c) { return c.rates.out_msgs; }, "rate"],
+    ["MSGS_FROM/S", function(c) { return c.rates.in_msgs; }, "rate"],
+    ["BYTES_TO/S", function(c) { return c.rates.out_bytes; }, "size"],
+    ["BYTES_FROM/S", function(c) { return c.rates.in_bytes; }, "size"],
+    ["LANG", function(c) { return c.lang || ""; }, "str"],
+    ["VERSION", function(c) { return c.version || ""; }, "str"],
+    ["UPTIME", function(c) { return c.uptime; }, "str"],
+    ["LAST ACTIVITY", function(c) { return c.last_activity; }, "str"]
+  ];
+  var sortBy = 1, descending = false, last = null;
+
+  function psize(s) {
+    if (s < 1024) return s.toFixed(0);
+    if (s < 1024 * 1024) return (s / 1024).toFixed(1) + "K";
+    if (s < 1024 * 1024 * 1024) return (s / 1024 / 1024).toFixed(1) + "M";
+    return (s / 1024 / 1024 / 1024).toFixed(1) + "G";
+  }
+
+  function format(value, kind) {
+    if (kind === "size") return psize(value || 0);
+    if (kind === "rate") return (value || 0).toFixed(1);
+    return String(value);
+  }
+
+  function renderColumns() {
+    var tr = document.getElementById("columns");
+    tr.innerHTML = "";
+    columns.forEach(function(col, i) {
+      var th = document.createElement("th");
+      th.textContent = col[0] + (i === sortBy ? (descending ? " ▼" : " ▲") : "");
+      th.className = i === sortBy ? "sorted" : "";
+      th.onclick = function() {
+        // Numbers are sorted in descending order first, as in the top view
+        descending = i === sortBy ? !descending : col[2] !== "str";
+        sortBy = i;
+        renderColumns();
+        if (last) render(last);
+      };
+      tr.appendChild(th);
+    });
+  }
+
+  function render(snapshot) {
+    var s = snapshot.server;
+    var name = s.name ? " [" + s.name + "]" : "";
+    document.getElementById("header").textContent =
+      "NATS server version " + s.version + name + " (uptime: " + s.uptime + ")\n" +
+      "Server:\n" +
+      "  Load: CPU: " + s.cpu.toFixed(1) + "%  Memory: " + psize(s.mem) + "  Slow Consumers: " + s.slow_consumers + "\n" +
+      "  In:   Msgs: " + psize(s.in_msgs) + "  Bytes: " + psize(s.in_bytes) +
+      "  Msgs/Sec: " + s.rates.in_msgs.toFixed(1) + "  Bytes/Sec: " + psize(s.rates.in_bytes) + "\n" +
+      "  Out:  Msgs: " + psize(s.out_msgs) + "  Bytes: " + psize(s.out_bytes) +
+      "  Msgs/Sec: " + s.rates.out_msgs.toFixed(1) + "  Bytes/Sec: " + psize(s.rates.out_bytes) + "\n" +
+      "  Conns: Total: " + s.total_connections + "  Subs: " + s.subscriptions + "\n\n" +
+      "Connections Polled: " + snapshot.connections.length;
+    document.getElementById("status").textContent = snapshot.error || "";
+
+    var get = columns[sortBy][1];
+    var conns = snapshot.connections.slice().sort(function(a, b) {
+      var x = get(a), y = get(b);
+      var cmp = x < y ? -1 : (x > y ? 1 : a.cid - b.cid);
+      return descending ? -cmp : cmp;
+    });
+
+    var tbody = document.createElement("tbody");
+    tbody.id = "rows";
+    conns.forEach(function(conn) {
+      var tr = document.createElement("tr");
+      columns.forEach(function(col) {
+        var td = document.createElement("td");
+        td.textContent = format(col[1](conn), col[2]);
+        tr.appendChild(td);
+      });
+      tbody.appendChild(tr);
+    });
+    var old = document.getElementById("rows");
+    old.parentNode.replaceChild(tbody, old);
+  }
+
+  function connect() {
+    var proto = location.protocol === "https:" ? "wss:" : "ws:";
+    var ws = new WebSocket(proto + "//" + location.host + "/ws");
+    ws.onmessage = function(e) {
+      last = JSON.parse(e.data);
+      render(last);
+    };
+    ws.onclose = function() {
+      document.getElementById("status").textContent = "Disconnected from nats-top, reconnecting...";
+      setTimeout(connect, 2000);
+    };
+  }
+
+  renderColumns();
+  connect();
+})();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,111 @@
+package toputils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+	"golang.org/x/net/websocket"
+)
+
+func TestDashboard(t *testing.T) {
+	dashboard := NewDashboard()
+	ts := httptest.NewServer(dashboard.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatalf("Failed getting dashboard page: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "new WebSocket(") {
+		t.Fatalf("Expected dashboard page. got: %s", body)
+	}
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+	ws, err := websocket.Dial(wsURL, "", ts.URL)
+	if err != nil {
+		t.Fatalf("Failed connecting to dashboard websocket: %v", err)
+	}
+	defer ws.Close()
+
+	// Wait for the browser to be registered
+	for i := 0; dashboard.NumClients() == 0; i++ {
+		if i > 100 {
+			t.Fatalf("Timed out waiting for websocket client")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stats := &Stats{
+		Varz: &gnatsd.Varz{
+			Info:   &gnatsd.Info{Version: "0.9.6"},
+			InMsgs: 100,
+		},
+		Connz: &gnatsd.Connz{Conns: []gnatsd.ConnInfo{{Cid: 7, Name: "orders", OutMsgs: 10,
+			AuthorizedUser: "alice", TLSVersion: "1.2", TLSCipher: "TLS_AES_128_GCM_SHA256"}}},
+		Rates: &Rates{
+			InMsgsRate:  5,
+			Connections: map[uint64]*ConnRates{7: {OutMsgsRate: 2.5}},
+		},
+		Error: fmt.Errorf(""),
+	}
+	if err := dashboard.Publish(stats); err != nil {
+		t.Fatalf("Failed publishing stats: %v", err)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(3 * time.Second))
+	var msg string
+	if err := websocket.Message.Receive(ws, &msg); err != nil {
+		t.Fatalf("Failed receiving stats from dashboard: %v", err)
+	}
+	snapshot := &DashboardSnapshot{}
+	if err := json.Unmarshal([]byte(msg), snapshot); err != nil {
+		t.Fatalf("Failed decoding snapshot: %v", err)
+	}
+	if snapshot.Server.Version != "0.9.6" || snapshot.Server.InMsgs != 100 || snapshot.Server.Rates.InMsgs != 5 {
+		t.Fatalf("Wrong server in snapshot. got: %+v", snapshot.Server)
+	}
+	if len(snapshot.Conns) != 1 || snapshot.Conns[0].Name != "orders" || snapshot.Conns[0].Rates.OutMsgs != 2.5 {
+		t.Fatalf("Wrong connections in snapshot. got: %+v", snapshot.Conns)
+	}
+	if snapshot.Error != "" {
+		t.Fatalf("Unexpected error in snapshot. got: %v", snapshot.Error)
+	}
+	if strings.Contains(msg, "authorized_user") || strings.Contains(msg, "tls_") {
+		t.Fatalf("Expected only the columns shown to be sent. got: %s", msg)
+	}
+
+	// Browsers which connect later get the latest stats right away
+	late, err := websocket.Dial(wsURL, "", ts.URL)
+	if err != nil {
+		t.Fatalf("Failed connecting to dashboard websocket: %v", err)
+	}
+	defer late.Close()
+	late.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if err := websocket.Message.Receive(late, &msg); err != nil {
+		t.Fatalf("Failed receiving latest stats from dashboard: %v", err)
+	}
+
+	// Pages from other sites cannot open the WebSocket
+	if _, err := websocket.Dial(wsURL, "", "http://evil.example.com"); err == nil {
+		t.Fatalf("Expected WebSocket from another origin to be rejected")
+	}
+}
+
+func TestSendDropOldest(t *testing.T) {
+	ch := make(chan []byte, 2)
+	for _, s := range []string{"a", "b", "c"} {
+		sendDropOldest(ch, []byte(s))
+	}
+	if got := string(<-ch) + string(<-ch); got != "bc" {
+		t.Fatalf("Expected oldest value to be dropped. got: %v", got)
+	}
+}
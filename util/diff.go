@@ -0,0 +1,140 @@
+package toputils
+
+import (
+	"sort"
+	"time"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+// ConnDeltaState tells whether a connection was polled at both
+// of the moments being compared or only at one of them.
+type ConnDeltaState string
+
+const (
+	ConnDeltaKept ConnDeltaState = ""
+	ConnDeltaNew  ConnDeltaState = "new"
+	ConnDeltaGone ConnDeltaState = "gone"
+)
+
+// ConnDelta is how much the counters of a connection changed between
+// two moments. New connections count from zero, while the ones which
+// are gone, either closed or no longer among the polled ones, are left
+// without changes since their latest counters are unknown.
+type ConnDelta struct {
+	Conn     gnatsd.ConnInfo
+	State    ConnDeltaState
+	NumSubs  int64
+	Pending  int64
+	InMsgs   int64
+	OutMsgs  int64
+	InBytes  int64
+	OutBytes int64
+}
+
+// StatsDiff has the server wide and per connection changes
+// between two stats, e.g. a marked snapshot and the live one.
+type StatsDiff struct {
+	From time.Time
+	To   time.Time
+
+	InMsgs        int64
+	OutMsgs       int64
+	InBytes       int64
+	OutBytes      int64
+	NumConns      int64
+	TotalConns    int64
+	Subs          int64
+	SlowConsumers int64
+	Mem           int64
+
+	// Connections polled at either moment, in the order they were
+	// polled at the later one followed by the ones which are gone.
+	Conns []*ConnDelta
+}
+
+// DiffStats returns the changes from the stats to the later ones.
+func DiffStats(from, to *Stats) *StatsDiff {
+	diff := &StatsDiff{
+		From:          from.Varz.Now,
+		To:            to.Varz.Now,
+		InMsgs:        to.Varz.InMsgs - from.Varz.InMsgs,
+		OutMsgs:       to.Varz.OutMsgs - from.Varz.OutMsgs,
+		InBytes:       to.Varz.InBytes - from.Varz.InBytes,
+		OutBytes:      to.Varz.OutBytes - from.Varz.OutBytes,
+		NumConns:      int64(to.Varz.Connections) - int64(from.Varz.Connections),
+		TotalConns:    int64(to.Varz.TotalConnections) - int64(from.Varz.TotalConnections),
+		Subs:          int64(to.Varz.Subscriptions) - int64(from.Varz.Subscriptions),
+		SlowConsumers: to.Varz.SlowConsumers - from.Varz.SlowConsumers,
+		Mem:           to.Varz.Mem - from.Varz.Mem,
+	}
+
+	before := make(map[uint64]gnatsd.ConnInfo, len(from.Connz.Conns))
+	for _, conn := range from.Connz.Conns {
+		before[conn.Cid] = conn
+	}
+
+	polled := make(map[uint64]bool, len(to.Connz.Conns))
+	for _, conn := range to.Connz.Conns {
+		polled[conn.Cid] = true
+
+		last, ok := before[conn.Cid]
+		delta := &ConnDelta{Conn: conn}
+		if !ok {
+			delta.State = ConnDeltaNew
+		}
+		delta.NumSubs = int64(conn.NumSubs) - int64(last.NumSubs)
+		delta.Pending = int64(conn.Pending) - int64(last.Pending)
+		delta.InMsgs = conn.InMsgs - last.InMsgs
+		delta.OutMsgs = conn.OutMsgs - last.OutMsgs
+		delta.InBytes = conn.InBytes - last.InBytes
+		delta.OutBytes = conn.OutBytes - last.OutBytes
+		diff.Conns = append(diff.Conns, delta)
+	}
+	for _, conn := range from.Connz.Conns {
+		if !polled[conn.Cid] {
+			diff.Conns = append(diff.Conns, &ConnDelta{Conn: conn, State: ConnDeltaGone})
+		}
+	}
+
+	return diff
+}
+
+// SortConnDeltas sorts the connections by the change in the value
+// of the sort option, or by their value when it is not a counter.
+func SortConnDeltas(deltas []*ConnDelta, by gnatsd.SortOpt) {
+	sort.Stable(connDeltasByOpt{deltas, by})
+}
+
+type connDeltasByOpt struct {
+	deltas []*ConnDelta
+	by     gnatsd.SortOpt
+}
+
+func (d connDeltasByOpt) Len() int {
+	return len(d.deltas)
+}
+
+func (d connDeltasByOpt) Swap(i, j int) {
+	d.deltas[i], d.deltas[j] = d.deltas[j], d.deltas[i]
+}
+
+func (d connDeltasByOpt) Less(i, j int) bool {
+	a, b := d.deltas[i], d.deltas[j]
+	switch d.by {
+	case "subs":
+		return a.NumSubs > b.NumSubs
+	case "pending":
+		return a.Pending > b.Pending
+	case "msgs_to":
+		return a.OutMsgs > b.OutMsgs
+	case "msgs_from":
+		return a.InMsgs > b.InMsgs
+	case "bytes_to":
+		return a.OutBytes > b.OutBytes
+	case "bytes_from":
+		return a.InBytes > b.InBytes
+	default:
+		return connsByOpt{[]gnatsd.ConnInfo{a.Conn, b.Conn}, d.by}.Less(0, 1)
+	}
+}
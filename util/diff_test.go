@@ -0,0 +1,82 @@
+package toputils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+func TestDiffStats(t *testing.T) {
+	now := time.Now()
+	from := &Stats{
+		Varz: &gnatsd.Varz{
+			Now: now, InMsgs: 100, OutMsgs: 200, InBytes: 1000, OutBytes: 2000,
+			Connections: 3, TotalConnections: 10, Subscriptions: 5, Mem: 4096,
+		},
+		Connz: &gnatsd.Connz{Conns: []gnatsd.ConnInfo{
+			{Cid: 1, NumSubs: 2, InMsgs: 50, OutMsgs: 100, InBytes: 500, OutBytes: 1000},
+			{Cid: 2, NumSubs: 3, InMsgs: 50, OutMsgs: 100, InBytes: 500, OutBytes: 1000},
+			{Cid: 3, Pending: 10},
+		}},
+	}
+	to := &Stats{
+		Varz: &gnatsd.Varz{
+			Now: now.Add(30 * time.Second), InMsgs: 150, OutMsgs: 400, InBytes: 1500, OutBytes: 4000,
+			Connections: 3, TotalConnections: 11, Subscriptions: 4, SlowConsumers: 1, Mem: 2048,
+		},
+		Connz: &gnatsd.Connz{Conns: []gnatsd.ConnInfo{
+			{Cid: 1, NumSubs: 1, InMsgs: 60, OutMsgs: 250, InBytes: 600, OutBytes: 2500},
+			{Cid: 2, NumSubs: 3, InMsgs: 90, OutMsgs: 120, InBytes: 900, OutBytes: 1200},
+			{Cid: 4, NumSubs: 1, OutMsgs: 30, OutBytes: 300},
+		}},
+	}
+
+	diff := DiffStats(from, to)
+	if diff.To.Sub(diff.From) != 30*time.Second {
+		t.Fatalf("Wrong time between the stats. got: %v", diff.To.Sub(diff.From))
+	}
+	if diff.InMsgs != 50 || diff.OutMsgs != 200 || diff.InBytes != 500 || diff.OutBytes != 2000 {
+		t.Fatalf("Wrong server deltas. got: %+v", diff)
+	}
+	if diff.NumConns != 0 || diff.TotalConns != 1 || diff.Subs != -1 || diff.SlowConsumers != 1 || diff.Mem != -2048 {
+		t.Fatalf("Wrong server deltas. got: %+v", diff)
+	}
+
+	if len(diff.Conns) != 4 {
+		t.Fatalf("Expected 4 connections to be compared. got: %d", len(diff.Conns))
+	}
+	expected := []ConnDelta{
+		{State: ConnDeltaKept, NumSubs: -1, InMsgs: 10, OutMsgs: 150, InBytes: 100, OutBytes: 1500},
+		{State: ConnDeltaKept, InMsgs: 40, OutMsgs: 20, InBytes: 400, OutBytes: 200},
+		{State: ConnDeltaNew, NumSubs: 1, OutMsgs: 30, OutBytes: 300},
+		{State: ConnDeltaGone},
+	}
+	for i, delta := range diff.Conns {
+		delta.Conn = gnatsd.ConnInfo{}
+		if !reflect.DeepEqual(*delta, expected[i]) {
+			t.Fatalf("Wrong delta of connection. expected: %+v, got: %+v", expected[i], delta)
+		}
+	}
+
+	// Sorted by the change rather than the value
+	diff = DiffStats(from, to)
+	tests := []struct {
+		by   gnatsd.SortOpt
+		cids []uint64
+	}{
+		{"cid", []uint64{1, 2, 3, 4}},
+		{"msgs_to", []uint64{1, 4, 2, 3}},
+		{"msgs_from", []uint64{2, 1, 4, 3}},
+		{"subs", []uint64{4, 2, 3, 1}},
+	}
+	for _, test := range tests {
+		SortConnDeltas(diff.Conns, test.by)
+		for i, cid := range test.cids {
+			if diff.Conns[i].Conn.Cid != cid {
+				t.Fatalf("Wrong order sorting by %s. expected: %v, got: %d at %d", test.by, test.cids, diff.Conns[i].Conn.Cid, i)
+			}
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package toputils
+
+import (
+	"fmt"
+	"sort"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+// GroupByOpt is the value by which connections are aggregated.
+type GroupByOpt string
+
+const (
+	GroupByNone    GroupByOpt = ""
+	GroupByName    GroupByOpt = "name"
+	GroupByLang    GroupByOpt = "lang"
+	GroupByVersion GroupByOpt = "version"
+	GroupByIP      GroupByOpt = "ip"
+	GroupByHost    GroupByOpt = "host"
+)
+
+// IsValid determines if a group by option is valid.
+func (g GroupByOpt) IsValid() bool {
+	switch g {
+	case GroupByNone, GroupByName, GroupByLang, GroupByVersion, GroupByIP, GroupByHost:
+		return true
+	default:
+		return false
+	}
+}
+
+// GroupSortOpt is the value by which groups of connections are sorted.
+type GroupSortOpt string
+
+const (
+	GroupSortByKey      GroupSortOpt = "key"
+	GroupSortByConns    GroupSortOpt = "conns"
+	GroupSortBySubs     GroupSortOpt = "subs"
+	GroupSortByPending  GroupSortOpt = "pending"
+	GroupSortByOutMsgs  GroupSortOpt = "msgs_to"
+	GroupSortByInMsgs   GroupSortOpt = "msgs_from"
+	GroupSortByOutBytes GroupSortOpt = "bytes_to"
+	GroupSortByInBytes  GroupSortOpt = "bytes_from"
+)
+
+// IsValid determines if a group sort option is valid.
+func (s GroupSortOpt) IsValid() bool {
+	switch s {
+	case GroupSortByKey, GroupSortByConns, GroupSortBySubs, GroupSortByPending,
+		GroupSortByOutMsgs, GroupSortByInMsgs, GroupSortByOutBytes, GroupSortByInBytes:
+		return true
+	default:
+		return false
+	}
+}
+
+// UnknownGroupKey is used as the key of the group of connections
+// which did not report a value for the grouped field.
+const UnknownGroupKey = "-"
+
+// ConnGroup represents the aggregated stats from a set of connections.
+type ConnGroup struct {
+	Key      string
+	NumConns int
+	NumSubs  uint32
+	Pending  int
+	InMsgs   int64
+	OutMsgs  int64
+	InBytes  int64
+	OutBytes int64
+	Rates    *ConnRates
+	Conns    []gnatsd.ConnInfo
+}
+
+// GroupConns aggregates the connections by the given option, summing up
+// their counters and rates. The hostname func is used to resolve the key
+// when grouping by host, so that it can make use of DNS lookups.
+func GroupConns(
+	conns []gnatsd.ConnInfo,
+	rates map[uint64]*ConnRates,
+	by GroupByOpt,
+	hostname func(conn gnatsd.ConnInfo) string,
+) []*ConnGroup {
+	groups := make([]*ConnGroup, 0)
+	index := make(map[string]*ConnGroup)
+
+	for _, conn := range conns {
+		var key string
+		switch by {
+		case GroupByName:
+			key = conn.Name
+		case GroupByLang:
+			key = conn.Lang
+		case GroupByVersion:
+			key = conn.Version
+		case GroupByIP:
+			key = conn.IP
+		case GroupByHost:
+			if hostname != nil {
+				key = hostname(conn)
+			} else {
+				key = fmt.Sprintf("%s:%d", conn.IP, conn.Port)
+			}
+		}
+		if key == "" {
+			key = UnknownGroupKey
+		}
+
+		group, ok := index[key]
+		if !ok {
+			group = &ConnGroup{Key: key, Rates: &ConnRates{}}
+			index[key] = group
+			groups = append(groups, group)
+		}
+		group.add(conn, rates[conn.Cid])
+	}
+
+	return groups
+}
+
+// add sums up the counters and rates of a connection to the group.
+func (group *ConnGroup) add(conn gnatsd.ConnInfo, rates *ConnRates) {
+	group.NumConns++
+	group.NumSubs += conn.NumSubs
+	group.Pending += conn.Pending
+	group.InMsgs += conn.InMsgs
+	group.OutMsgs += conn.OutMsgs
+	group.InBytes += conn.InBytes
+	group.OutBytes += conn.OutBytes
+	group.Conns = append(group.Conns, conn)
+
+	if rates != nil {
+		group.Rates.InMsgsRate += rates.InMsgsRate
+		group.Rates.OutMsgsRate += rates.OutMsgsRate
+		group.Rates.InBytesRate += rates.InBytesRate
+		group.Rates.OutBytesRate += rates.OutBytesRate
+	}
+}
+
+// SortGroups sorts the groups in place. Groups are sorted in descending
+// order, except when sorting by key which is in ascending order.
+func SortGroups(groups []*ConnGroup, by GroupSortOpt) {
+	sort.Stable(groupsByOpt{groups, by})
+}
+
+type groupsByOpt struct {
+	groups []*ConnGroup
+	by     GroupSortOpt
+}
+
+func (g groupsByOpt) Len() int {
+	return len(g.groups)
+}
+
+func (g groupsByOpt) Swap(i, j int) {
+	g.groups[i], g.groups[j] = g.groups[j], g.groups[i]
+}
+
+func (g groupsByOpt) Less(i, j int) bool {
+	a, b := g.groups[i], g.groups[j]
+	switch g.by {
+	case GroupSortByConns:
+		return a.NumConns > b.NumConns
+	case GroupSortBySubs:
+		return a.NumSubs > b.NumSubs
+	case GroupSortByPending:
+		return a.Pending > b.Pending
+	case GroupSortByOutMsgs:
+		return a.OutMsgs > b.OutMsgs
+	case GroupSortByInMsgs:
+		return a.InMsgs > b.InMsgs
+	case GroupSortByOutBytes:
+		return a.OutBytes > b.OutBytes
+	case GroupSortByInBytes:
+		return a.InBytes > b.InBytes
+	default:
+		return a.Key < b.Key
+	}
+}
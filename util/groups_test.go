@@ -0,0 +1,94 @@
+package toputils
+
+import (
+	"testing"
+
+	"github.com/nats-io/gnatsd/server"
+)
+
+func TestGroupConns(t *testing.T) {
+	conns := []server.ConnInfo{
+		{Cid: 1, IP: "10.0.0.1", Port: 4001, Name: "api", Lang: "go", Version: "1.2.2", NumSubs: 2, InMsgs: 10, OutMsgs: 100},
+		{Cid: 2, IP: "10.0.0.1", Port: 4002, Name: "api", Lang: "go", Version: "1.2.0", NumSubs: 3, InMsgs: 20, OutMsgs: 200},
+		{Cid: 3, IP: "10.0.0.2", Port: 4003, Name: "worker", Lang: "node", Version: "0.6.4", NumSubs: 1, InMsgs: 30, OutMsgs: 300},
+		{Cid: 4, IP: "10.0.0.3", Port: 4004, Lang: "ruby", Version: "0.8.0"},
+	}
+	rates := map[uint64]*ConnRates{
+		1: {InMsgsRate: 1.0, OutMsgsRate: 10.0},
+		2: {InMsgsRate: 2.0, OutMsgsRate: 20.0},
+	}
+
+	groups := GroupConns(conns, rates, GroupByName, nil)
+	if len(groups) != 3 {
+		t.Fatalf("Wrong number of groups. expected: %v, got: %v", 3, len(groups))
+	}
+
+	api := groups[0]
+	if api.Key != "api" {
+		t.Fatalf("Wrong group key. expected: %v, got: %v", "api", api.Key)
+	}
+	if api.NumConns != 2 || len(api.Conns) != 2 {
+		t.Fatalf("Wrong number of connections in group. expected: %v, got: %v", 2, api.NumConns)
+	}
+	if api.NumSubs != 5 {
+		t.Fatalf("Wrong number of subscriptions in group. expected: %v, got: %v", 5, api.NumSubs)
+	}
+	if api.InMsgs != 30 || api.OutMsgs != 300 {
+		t.Fatalf("Wrong msgs in group. expected: %v/%v, got: %v/%v", 30, 300, api.InMsgs, api.OutMsgs)
+	}
+	if api.Rates.InMsgsRate != 3.0 || api.Rates.OutMsgsRate != 30.0 {
+		t.Fatalf("Wrong rates in group. expected: %v/%v, got: %v/%v", 3.0, 30.0, api.Rates.InMsgsRate, api.Rates.OutMsgsRate)
+	}
+
+	// Connections without a name are grouped together
+	if groups[2].Key != UnknownGroupKey {
+		t.Fatalf("Wrong group key. expected: %v, got: %v", UnknownGroupKey, groups[2].Key)
+	}
+
+	groups = GroupConns(conns, rates, GroupByIP, nil)
+	if len(groups) != 3 {
+		t.Fatalf("Wrong number of groups. expected: %v, got: %v", 3, len(groups))
+	}
+
+	hostname := func(conn server.ConnInfo) string {
+		if conn.IP == "10.0.0.3" {
+			return "db.example.com"
+		}
+		return "app.example.com"
+	}
+	groups = GroupConns(conns, rates, GroupByHost, hostname)
+	if len(groups) != 2 {
+		t.Fatalf("Wrong number of groups. expected: %v, got: %v", 2, len(groups))
+	}
+	if groups[0].Key != "app.example.com" || groups[0].NumConns != 3 {
+		t.Fatalf("Wrong group by host. expected: %v with %v conns, got: %v with %v conns",
+			"app.example.com", 3, groups[0].Key, groups[0].NumConns)
+	}
+}
+
+func TestSortGroups(t *testing.T) {
+	groups := []*ConnGroup{
+		{Key: "b", NumConns: 1, OutBytes: 300},
+		{Key: "c", NumConns: 3, OutBytes: 100},
+		{Key: "a", NumConns: 2, OutBytes: 200},
+	}
+
+	SortGroups(groups, GroupSortByKey)
+	if groups[0].Key != "a" || groups[2].Key != "c" {
+		t.Fatalf("Wrong order sorting by key. got: %v, %v, %v", groups[0].Key, groups[1].Key, groups[2].Key)
+	}
+
+	SortGroups(groups, GroupSortByConns)
+	if groups[0].Key != "c" || groups[2].Key != "b" {
+		t.Fatalf("Wrong order sorting by conns. got: %v, %v, %v", groups[0].Key, groups[1].Key, groups[2].Key)
+	}
+
+	SortGroups(groups, GroupSortByOutBytes)
+	if groups[0].Key != "b" || groups[2].Key != "c" {
+		t.Fatalf("Wrong order sorting by bytes_to. got: %v, %v, %v", groups[0].Key, groups[1].Key, groups[2].Key)
+	}
+
+	if GroupSortOpt("idle").IsValid() {
+		t.Fatalf("Expected idle to be an invalid group sort option")
+	}
+}
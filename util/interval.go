@@ -0,0 +1,84 @@
+package toputils
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// MinDelay is the shortest interval at which the server can be polled.
+const MinDelay = 100 * time.Millisecond
+
+// adaptiveDelayRatio is how many times the response time of /connz
+// the interval is kept at when adapting it, so that the server does
+// not spend more than a fraction of the time serving the requests.
+const adaptiveDelayRatio = 4
+
+// adaptiveDelayStep is what backed off intervals are rounded up to.
+const adaptiveDelayStep = 10 * time.Millisecond
+
+// ParseDelay returns the interval from either a duration such as
+// 250ms or 5s, or a number of seconds as taken by earlier versions.
+func ParseDelay(s string) (time.Duration, error) {
+	delay, err := time.ParseDuration(s)
+	if err != nil {
+		secs, nerr := strconv.ParseFloat(s, 64)
+		if nerr != nil {
+			return 0, fmt.Errorf("invalid refresh interval: %s", s)
+		}
+		delay = time.Duration(secs * float64(time.Second))
+	}
+	if delay < MinDelay {
+		return 0, fmt.Errorf("refresh interval should be at least %s: %s", MinDelay, s)
+	}
+	return delay, nil
+}
+
+// EffectiveDelay returns the interval at which the server is being
+// polled, which is longer than the delay in case it was backed off.
+func (engine *Engine) EffectiveDelay() time.Duration {
+	engine.mu.RLock()
+	defer engine.mu.RUnlock()
+
+	delay := Settings{Delay: engine.Delay, Interval: engine.Interval}.PollInterval()
+	if engine.AdaptiveDelay {
+		backoff := adaptiveDelayRatio * engine.connzTime
+		backoff = (backoff + adaptiveDelayStep - 1) / adaptiveDelayStep * adaptiveDelayStep
+		if backoff > delay {
+			delay = backoff
+		}
+	}
+	return delay
+}
+
+// observeConnzTime tracks how long the server takes to respond to
+// /connz, backing off right away when it gets slower and coming back
+// gradually once it gets faster so that the interval does not flap.
+func (engine *Engine) observeConnzTime(d time.Duration) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+
+	if d > engine.connzTime {
+		engine.connzTime = d
+	} else {
+		engine.connzTime = (engine.connzTime + d) / 2
+	}
+}
+
+// WaitNextPoll waits until the effective delay has elapsed since the
+// last poll started, starting over in case the delay is changed through
+// UpdateSettings meanwhile. It returns false once the engine is shut down.
+func (engine *Engine) WaitNextPoll(last time.Time) bool {
+	for {
+		timer := time.NewTimer(last.Add(engine.EffectiveDelay()).Sub(time.Now()))
+		select {
+		case <-engine.ShutdownCh:
+			timer.Stop()
+			return false
+		case <-engine.delayChanged:
+			timer.Stop()
+		case <-timer.C:
+			return true
+		}
+	}
+}
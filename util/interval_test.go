@@ -0,0 +1,122 @@
+package toputils
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseDelay(t *testing.T) {
+	for s, expected := range map[string]time.Duration{
+		"250ms": 250 * time.Millisecond,
+		"5s":    5 * time.Second,
+		"1m30s": 90 * time.Second,
+		"2":     2 * time.Second,
+		"0.5":   500 * time.Millisecond,
+	} {
+		delay, err := ParseDelay(s)
+		if err != nil || delay != expected {
+			t.Fatalf("Wrong interval for %q. expected: %v, got: %v, %v", s, expected, delay, err)
+		}
+	}
+
+	for _, s := range []string{"", "fast", "10ms", "0", "-1s"} {
+		if _, err := ParseDelay(s); err == nil {
+			t.Fatalf("Expected error for interval %q", s)
+		}
+	}
+}
+
+func TestPollInterval(t *testing.T) {
+	// Delay is in seconds as taken by earlier versions
+	engine := NewEngine("", 0, 10, 1)
+	if delay := engine.Settings().PollInterval(); delay != time.Second || engine.EffectiveDelay() != time.Second {
+		t.Fatalf("Expected interval of a second. got: %v", delay)
+	}
+
+	engine.Interval = 250 * time.Millisecond
+	if delay := engine.Settings().PollInterval(); delay != 250*time.Millisecond || engine.EffectiveDelay() != delay {
+		t.Fatalf("Expected interval to take precedence over the delay. got: %v", delay)
+	}
+}
+
+func TestEffectiveDelay(t *testing.T) {
+	engine := NewEngine("", 0, 10, 1)
+
+	// Slow responses are ignored unless adapting the interval
+	engine.observeConnzTime(400 * time.Millisecond)
+	if delay := engine.EffectiveDelay(); delay != time.Second {
+		t.Fatalf("Expected interval to be the one set. got: %v", delay)
+	}
+
+	engine.UpdateSettings(func(s *Settings) { s.AdaptiveDelay = true })
+	if delay := engine.EffectiveDelay(); delay != 1600*time.Millisecond {
+		t.Fatalf("Expected interval to be backed off. got: %v", delay)
+	}
+
+	// Faster responses bring it back gradually
+	engine.observeConnzTime(200 * time.Millisecond)
+	if delay := engine.EffectiveDelay(); delay != 1200*time.Millisecond {
+		t.Fatalf("Expected interval to still be backed off. got: %v", delay)
+	}
+	engine.observeConnzTime(time.Millisecond)
+	if delay := engine.EffectiveDelay(); delay != time.Second {
+		t.Fatalf("Expected interval to be the one set. got: %v", delay)
+	}
+}
+
+// slowSource delays the responses from /connz.
+type slowSource struct {
+	*FakeSource
+	delay time.Duration
+}
+
+func (s *slowSource) Fetch(ctx context.Context, path string, opts *ConnzOptions) ([]byte, error) {
+	if path == "/connz" {
+		time.Sleep(s.delay)
+	}
+	return s.FakeSource.Fetch(ctx, path, opts)
+}
+
+func TestMonitorStatsInterval(t *testing.T) {
+	engine := newFakeEngine(t)
+	engine.Source = &slowSource{FakeSource: engine.Source.(*FakeSource), delay: 50 * time.Millisecond}
+	engine.Delay = 3600
+	go engine.MonitorStats()
+	defer close(engine.ShutdownCh)
+
+	// Changing the interval applies to the poll being waited on
+	engine.UpdateSettings(func(s *Settings) { s.Interval = 50 * time.Millisecond })
+
+	var stats *Stats
+	for i := 0; i < 3; i++ {
+		select {
+		case stats = <-engine.StatsCh:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("Timed out waiting for stats after changing the interval")
+		}
+	}
+	if stats.Interval < 40*time.Millisecond || stats.Interval > 150*time.Millisecond {
+		t.Fatalf("Wrong interval between polls. got: %v", stats.Interval)
+	}
+
+	// Slow responses from /connz back off the interval
+	engine.UpdateSettings(func(s *Settings) { s.AdaptiveDelay = true })
+
+	for i := 0; i < 3; i++ {
+		select {
+		case stats = <-engine.StatsCh:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("Timed out waiting for stats while backing off")
+		}
+	}
+	if stats.ConnzTime < 50*time.Millisecond {
+		t.Fatalf("Expected response time of /connz to be tracked. got: %v", stats.ConnzTime)
+	}
+	if stats.Interval < 4*stats.ConnzTime-10*time.Millisecond {
+		t.Fatalf("Expected interval to be backed off. got: %v, /connz: %v", stats.Interval, stats.ConnzTime)
+	}
+	if delay := engine.EffectiveDelay(); delay < 200*time.Millisecond {
+		t.Fatalf("Expected effective interval to be backed off. got: %v", delay)
+	}
+}
@@ -0,0 +1,322 @@
+package toputils
+
+import (
+	"sort"
+	"time"
+)
+
+// JSInfo represents the JetStream usage from /jsz, which is
+// only available in newer versions of the server.
+type JSInfo struct {
+	ID             string       `json:"server_id"`
+	Now            time.Time    `json:"now"`
+	Disabled       bool         `json:"disabled,omitempty"`
+	Memory         uint64       `json:"memory"`
+	Storage        uint64       `json:"storage"`
+	Accounts       int          `json:"accounts"`
+	Streams        int          `json:"streams"`
+	Consumers      int          `json:"consumers"`
+	Messages       uint64       `json:"messages"`
+	Bytes          uint64       `json:"bytes"`
+	AccountDetails []*JSAccount `json:"account_details,omitempty"`
+}
+
+// JSAccount has the streams from a single account.
+type JSAccount struct {
+	Name    string      `json:"name"`
+	ID      string      `json:"id"`
+	Streams []*JSStream `json:"stream_detail,omitempty"`
+}
+
+// JSStream has the state of a stream along with its consumers.
+type JSStream struct {
+	Name      string        `json:"name"`
+	State     JSStreamState `json:"state"`
+	Consumers []*JSConsumer `json:"consumer_detail,omitempty"`
+
+	// Account is not part of the response, it is
+	// filled from the account which has the stream.
+	Account string `json:"-"`
+}
+
+// JSStreamState is the number of messages in a stream
+// and the range of their sequences.
+type JSStreamState struct {
+	Msgs      uint64 `json:"messages"`
+	Bytes     uint64 `json:"bytes"`
+	FirstSeq  uint64 `json:"first_seq"`
+	LastSeq   uint64 `json:"last_seq"`
+	Consumers int    `json:"consumer_count"`
+}
+
+// JSConsumer has the delivery state of a consumer.
+type JSConsumer struct {
+	Stream         string         `json:"stream_name"`
+	Name           string         `json:"name"`
+	Delivered      JSSequenceInfo `json:"delivered"`
+	AckFloor       JSSequenceInfo `json:"ack_floor"`
+	NumAckPending  int            `json:"num_ack_pending"`
+	NumRedelivered int            `json:"num_redelivered"`
+	NumWaiting     int            `json:"num_waiting"`
+	NumPending     uint64         `json:"num_pending"`
+
+	// Account is filled from the account which has the stream.
+	Account string `json:"-"`
+}
+
+// JSSequenceInfo has the consumer and stream sequences
+// which have been either delivered or acknowledged.
+type JSSequenceInfo struct {
+	Consumer uint64 `json:"consumer_seq"`
+	Stream   uint64 `json:"stream_seq"`
+}
+
+// Key identifies a stream across polls.
+func (s *JSStream) Key() string {
+	return s.Account + "/" + s.Name
+}
+
+// Key identifies a consumer across polls.
+func (c *JSConsumer) Key() string {
+	return c.Account + "/" + c.Stream + "/" + c.Name
+}
+
+// JSStreams returns the streams from all the accounts, which are
+// copies with their account filled so that the stats they come from
+// are left as decoded, since these are shared with subscribers.
+func JSStreams(jsz *JSInfo) []*JSStream {
+	streams := make([]*JSStream, 0)
+	if jsz == nil {
+		return streams
+	}
+	for _, account := range jsz.AccountDetails {
+		if account == nil {
+			continue
+		}
+		for _, stream := range account.Streams {
+			if stream == nil {
+				continue
+			}
+			copied := *stream
+			copied.Account = account.Name
+			streams = append(streams, &copied)
+		}
+	}
+	return streams
+}
+
+// JSConsumers returns the consumers from all the streams, which are
+// copies with their account and stream filled like JSStreams does.
+func JSConsumers(jsz *JSInfo) []*JSConsumer {
+	consumers := make([]*JSConsumer, 0)
+	for _, stream := range JSStreams(jsz) {
+		for _, consumer := range stream.Consumers {
+			if consumer == nil {
+				continue
+			}
+			copied := *consumer
+			copied.Account = stream.Account
+			if copied.Stream == "" {
+				copied.Stream = stream.Name
+			}
+			consumers = append(consumers, &copied)
+		}
+	}
+	return consumers
+}
+
+// StreamRates represents the rate of messages and bytes
+// stored by a stream.
+type StreamRates struct {
+	MsgsRate  float64
+	BytesRate float64
+}
+
+// ConsumerRates represents the rate of messages delivered
+// and acknowledged by a consumer.
+type ConsumerRates struct {
+	DeliveredRate float64
+	AckRate       float64
+}
+
+// streamRates calculates the rates of the streams from the state of
+// the previous poll, returning the states to use for the next one.
+// Messages are counted from the last sequence so that the rate is
+// not affected by the limits of the stream removing older ones.
+func streamRates(jsz *JSInfo, last map[string]JSStreamState, tdelta time.Duration, rates map[string]*StreamRates) map[string]JSStreamState {
+	states := make(map[string]JSStreamState)
+	for _, stream := range JSStreams(jsz) {
+		cur := stream.State
+		states[stream.Key()] = cur
+
+		prev, seen := last[stream.Key()]
+		if !seen || cur.LastSeq < prev.LastSeq {
+			rates[stream.Key()] = &StreamRates{}
+			continue
+		}
+		rates[stream.Key()] = &StreamRates{
+			MsgsRate:  float64(cur.LastSeq-prev.LastSeq) / tdelta.Seconds(),
+			BytesRate: (float64(cur.Bytes) - float64(prev.Bytes)) / tdelta.Seconds(),
+		}
+	}
+	return states
+}
+
+// consumerRates calculates the rates of the consumers from the state
+// of the previous poll, returning the states to use for the next one.
+func consumerRates(jsz *JSInfo, last map[string]*JSConsumer, tdelta time.Duration, rates map[string]*ConsumerRates) map[string]*JSConsumer {
+	consumers := make(map[string]*JSConsumer)
+	for _, cur := range JSConsumers(jsz) {
+		consumers[cur.Key()] = cur
+
+		prev, seen := last[cur.Key()]
+		if !seen || cur.Delivered.Consumer < prev.Delivered.Consumer || cur.AckFloor.Consumer < prev.AckFloor.Consumer {
+			rates[cur.Key()] = &ConsumerRates{}
+			continue
+		}
+		rates[cur.Key()] = &ConsumerRates{
+			DeliveredRate: float64(cur.Delivered.Consumer-prev.Delivered.Consumer) / tdelta.Seconds(),
+			AckRate:       float64(cur.AckFloor.Consumer-prev.AckFloor.Consumer) / tdelta.Seconds(),
+		}
+	}
+	return consumers
+}
+
+// JSSortOpt is the value by which streams and consumers are sorted.
+// Options which only apply to either streams or consumers leave the
+// other ones sorted by account and name.
+type JSSortOpt string
+
+const (
+	JSSortByAccount       JSSortOpt = "account"
+	JSSortByName          JSSortOpt = "name"
+	JSSortByMsgs          JSSortOpt = "msgs"
+	JSSortByBytes         JSSortOpt = "bytes"
+	JSSortByFirstSeq      JSSortOpt = "first_seq"
+	JSSortByLastSeq       JSSortOpt = "last_seq"
+	JSSortByConsumers     JSSortOpt = "consumers"
+	JSSortByMsgsRate      JSSortOpt = "msgs_rate"
+	JSSortByBytesRate     JSSortOpt = "bytes_rate"
+	JSSortByStream        JSSortOpt = "stream"
+	JSSortByPending       JSSortOpt = "pending"
+	JSSortByAckPending    JSSortOpt = "ack_pending"
+	JSSortByRedelivered   JSSortOpt = "redelivered"
+	JSSortByWaiting       JSSortOpt = "waiting"
+	JSSortByDeliveredRate JSSortOpt = "delivered_rate"
+	JSSortByAckRate       JSSortOpt = "ack_rate"
+)
+
+// IsValid determines if a JetStream sort option is valid.
+func (s JSSortOpt) IsValid() bool {
+	switch s {
+	case JSSortByAccount, JSSortByName, JSSortByMsgs, JSSortByBytes, JSSortByFirstSeq,
+		JSSortByLastSeq, JSSortByConsumers, JSSortByMsgsRate, JSSortByBytesRate,
+		JSSortByStream, JSSortByPending, JSSortByAckPending, JSSortByRedelivered,
+		JSSortByWaiting, JSSortByDeliveredRate, JSSortByAckRate:
+		return true
+	default:
+		return false
+	}
+}
+
+// SortStreams sorts the streams by the given option,
+// using their rates when sorting by them.
+func SortStreams(streams []*JSStream, rates map[string]*StreamRates, by JSSortOpt) {
+	sort.Stable(streamsByOpt{streams, rates, by})
+}
+
+type streamsByOpt struct {
+	streams []*JSStream
+	rates   map[string]*StreamRates
+	by      JSSortOpt
+}
+
+func (s streamsByOpt) Len() int {
+	return len(s.streams)
+}
+
+func (s streamsByOpt) Swap(i, j int) {
+	s.streams[i], s.streams[j] = s.streams[j], s.streams[i]
+}
+
+func (s streamsByOpt) rate(stream *JSStream) *StreamRates {
+	if r, ok := s.rates[stream.Key()]; ok && r != nil {
+		return r
+	}
+	return &StreamRates{}
+}
+
+func (s streamsByOpt) Less(i, j int) bool {
+	a, b := s.streams[i], s.streams[j]
+	switch s.by {
+	case JSSortByName:
+		return a.Name < b.Name
+	case JSSortByMsgs:
+		return a.State.Msgs > b.State.Msgs
+	case JSSortByBytes:
+		return a.State.Bytes > b.State.Bytes
+	case JSSortByFirstSeq:
+		return a.State.FirstSeq > b.State.FirstSeq
+	case JSSortByLastSeq:
+		return a.State.LastSeq > b.State.LastSeq
+	case JSSortByConsumers:
+		return a.State.Consumers > b.State.Consumers
+	case JSSortByMsgsRate:
+		return s.rate(a).MsgsRate > s.rate(b).MsgsRate
+	case JSSortByBytesRate:
+		return s.rate(a).BytesRate > s.rate(b).BytesRate
+	default:
+		return a.Key() < b.Key()
+	}
+}
+
+// SortConsumers sorts the consumers by the given option,
+// using their rates when sorting by them.
+func SortConsumers(consumers []*JSConsumer, rates map[string]*ConsumerRates, by JSSortOpt) {
+	sort.Stable(consumersByOpt{consumers, rates, by})
+}
+
+type consumersByOpt struct {
+	consumers []*JSConsumer
+	rates     map[string]*ConsumerRates
+	by        JSSortOpt
+}
+
+func (c consumersByOpt) Len() int {
+	return len(c.consumers)
+}
+
+func (c consumersByOpt) Swap(i, j int) {
+	c.consumers[i], c.consumers[j] = c.consumers[j], c.consumers[i]
+}
+
+func (c consumersByOpt) rate(consumer *JSConsumer) *ConsumerRates {
+	if r, ok := c.rates[consumer.Key()]; ok && r != nil {
+		return r
+	}
+	return &ConsumerRates{}
+}
+
+func (c consumersByOpt) Less(i, j int) bool {
+	a, b := c.consumers[i], c.consumers[j]
+	switch c.by {
+	case JSSortByName:
+		return a.Name < b.Name
+	case JSSortByStream:
+		return a.Stream < b.Stream
+	case JSSortByPending:
+		return a.NumPending > b.NumPending
+	case JSSortByAckPending:
+		return a.NumAckPending > b.NumAckPending
+	case JSSortByRedelivered:
+		return a.NumRedelivered > b.NumRedelivered
+	case JSSortByWaiting:
+		return a.NumWaiting > b.NumWaiting
+	case JSSortByDeliveredRate:
+		return c.rate(a).DeliveredRate > c.rate(b).DeliveredRate
+	case JSSortByAckRate:
+		return c.rate(a).AckRate > c.rate(b).AckRate
+	default:
+		return a.Key() < b.Key()
+	}
+}
@@ -0,0 +1,139 @@
+package toputils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFetchingJsz(t *testing.T) {
+	ts := runFixtureServer()
+	defer ts.Close()
+
+	engine := newFixtureEngine(ts)
+	result, err := engine.Request("/jsz")
+	if err != nil {
+		t.Fatalf("Failed getting /jsz: %v", err)
+	}
+	jsz, ok := result.(*JSInfo)
+	if !ok {
+		t.Fatalf("Wrong type for /jsz. got: %T", result)
+	}
+	if jsz.Streams != 3 || jsz.Consumers != 3 || len(jsz.AccountDetails) != 2 {
+		t.Fatalf("Wrong jsz from server. got: %+v", jsz)
+	}
+
+	streams := JSStreams(jsz)
+	if len(streams) != 3 {
+		t.Fatalf("Wrong number of streams. expected: %v, got: %v", 3, len(streams))
+	}
+	orders := streams[0]
+	if orders.Key() != "ORDERS/ORDERS" || orders.State.Msgs != 12000 || orders.State.FirstSeq != 3001 || orders.State.LastSeq != 15000 {
+		t.Fatalf("Wrong stream. got: %+v", orders)
+	}
+	if streams[2].Account != "$G" {
+		t.Fatalf("Wrong account for stream. expected: %v, got: %v", "$G", streams[2].Account)
+	}
+
+	consumers := JSConsumers(jsz)
+	if len(consumers) != 3 {
+		t.Fatalf("Wrong number of consumers. expected: %v, got: %v", 3, len(consumers))
+	}
+	shipping := consumers[0]
+	if shipping.Key() != "ORDERS/ORDERS/shipping" || shipping.NumAckPending != 50 || shipping.NumRedelivered != 7 || shipping.NumPending != 10 {
+		t.Fatalf("Wrong consumer. got: %+v", shipping)
+	}
+
+	// Decoded stats are shared with subscribers, so they are left untouched
+	for _, account := range jsz.AccountDetails {
+		for _, stream := range account.Streams {
+			if stream.Account != "" {
+				t.Fatalf("Expected decoded stream to be left untouched. got: %+v", stream)
+			}
+			for _, consumer := range stream.Consumers {
+				if consumer.Account != "" {
+					t.Fatalf("Expected decoded consumer to be left untouched. got: %+v", consumer)
+				}
+			}
+		}
+	}
+}
+
+func TestJetStreamRates(t *testing.T) {
+	jsz := &JSInfo{AccountDetails: []*JSAccount{{
+		Name: "A",
+		Streams: []*JSStream{{
+			Name:      "S",
+			State:     JSStreamState{Msgs: 100, Bytes: 1000, LastSeq: 100},
+			Consumers: []*JSConsumer{{Name: "C", Delivered: JSSequenceInfo{Consumer: 50}, AckFloor: JSSequenceInfo{Consumer: 40}}},
+		}},
+	}}}
+
+	streamStates := streamRates(jsz, nil, time.Second, make(map[string]*StreamRates))
+	consumerStates := consumerRates(jsz, nil, time.Second, make(map[string]*ConsumerRates))
+
+	// Older messages are removed by limits, which does not affect the rates
+	next := &JSInfo{AccountDetails: []*JSAccount{{
+		Name: "A",
+		Streams: []*JSStream{{
+			Name:      "S",
+			State:     JSStreamState{Msgs: 100, Bytes: 1200, LastSeq: 140},
+			Consumers: []*JSConsumer{{Name: "C", Delivered: JSSequenceInfo{Consumer: 90}, AckFloor: JSSequenceInfo{Consumer: 60}}},
+		}},
+	}}}
+	rates := make(map[string]*StreamRates)
+	streamRates(next, streamStates, 2*time.Second, rates)
+	if r := rates["A/S"]; r.MsgsRate != 20 || r.BytesRate != 100 {
+		t.Fatalf("Wrong stream rates. got: %+v", r)
+	}
+	crates := make(map[string]*ConsumerRates)
+	consumerRates(next, consumerStates, 2*time.Second, crates)
+	if r := crates["A/S/C"]; r.DeliveredRate != 20 || r.AckRate != 10 {
+		t.Fatalf("Wrong consumer rates. got: %+v", r)
+	}
+}
+
+func TestSortStreamsAndConsumers(t *testing.T) {
+	streams := []*JSStream{
+		{Account: "B", Name: "X", State: JSStreamState{Msgs: 5, LastSeq: 10}},
+		{Account: "A", Name: "Y", State: JSStreamState{Msgs: 50, LastSeq: 5}},
+		{Account: "A", Name: "Z", State: JSStreamState{Msgs: 1, LastSeq: 20}},
+	}
+	rates := map[string]*StreamRates{"A/Z": {MsgsRate: 3}, "B/X": {MsgsRate: 7}}
+
+	tests := []struct {
+		by       JSSortOpt
+		expected []string
+	}{
+		{JSSortByAccount, []string{"Y", "Z", "X"}},
+		{JSSortByMsgs, []string{"Y", "X", "Z"}},
+		{JSSortByLastSeq, []string{"Z", "X", "Y"}},
+		{JSSortByMsgsRate, []string{"X", "Z", "Y"}},
+		// Consumer options leave streams sorted by account and name
+		{JSSortByAckPending, []string{"Y", "Z", "X"}},
+	}
+	for _, test := range tests {
+		SortStreams(streams, rates, test.by)
+		for i, name := range test.expected {
+			if streams[i].Name != name {
+				t.Fatalf("Wrong stream sorting by %v at %d. expected: %v, got: %v", test.by, i, name, streams[i].Name)
+			}
+		}
+	}
+
+	consumers := []*JSConsumer{
+		{Account: "A", Stream: "S", Name: "c1", NumAckPending: 1, NumPending: 100},
+		{Account: "A", Stream: "S", Name: "c2", NumAckPending: 9, NumPending: 10},
+	}
+	SortConsumers(consumers, nil, JSSortByAckPending)
+	if consumers[0].Name != "c2" {
+		t.Fatalf("Wrong consumer sorting by %v. got: %v", JSSortByAckPending, consumers[0].Name)
+	}
+	SortConsumers(consumers, nil, JSSortByPending)
+	if consumers[0].Name != "c1" {
+		t.Fatalf("Wrong consumer sorting by %v. got: %v", JSSortByPending, consumers[0].Name)
+	}
+
+	if !JSSortOpt("redelivered").IsValid() || JSSortOpt("cid").IsValid() {
+		t.Fatalf("Wrong validation of JetStream sort options")
+	}
+}
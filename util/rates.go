@@ -0,0 +1,141 @@
+package toputils
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// RateMode is the averaging used for displaying the rates.
+type RateMode int
+
+const (
+	RateModeInstant RateMode = iota
+	RateModeEWMA
+	RateMode1m
+	RateMode5m
+	RateMode15m
+)
+
+// RateModes are all the rate modes in the order they are cycled.
+var RateModes = []RateMode{RateModeInstant, RateModeEWMA, RateMode1m, RateMode5m, RateMode15m}
+
+// EWMAAlpha is the smoothing factor applied on each poll
+// when using the exponentially weighted moving average.
+const EWMAAlpha = 0.3
+
+func (m RateMode) String() string {
+	switch m {
+	case RateModeInstant:
+		return "instant"
+	case RateModeEWMA:
+		return "ewma"
+	case RateMode1m:
+		return "1m"
+	case RateMode5m:
+		return "5m"
+	case RateMode15m:
+		return "15m"
+	default:
+		return "unknown"
+	}
+}
+
+// Next returns the mode which follows when cycling through them.
+func (m RateMode) Next() RateMode {
+	return RateModes[(int(m)+1)%len(RateModes)]
+}
+
+// ParseRateMode returns the rate mode from its name.
+func ParseRateMode(name string) (RateMode, error) {
+	for _, mode := range RateModes {
+		if mode.String() == name {
+			return mode, nil
+		}
+	}
+	return RateModeInstant, fmt.Errorf("invalid rate mode: %s", name)
+}
+
+// RateAverage is an exponentially weighted moving average of a rate.
+// When it has a period, the weight is derived from the time elapsed
+// between updates, same as the load averages from the kernel.
+// Otherwise the fixed alpha is used on each update.
+type RateAverage struct {
+	Period time.Duration
+	Alpha  float64
+	Value  float64
+	primed bool
+}
+
+// Update adds a sample to the average and returns the new value.
+func (a *RateAverage) Update(rate float64, elapsed time.Duration) float64 {
+	if !a.primed {
+		a.Value = rate
+		a.primed = true
+		return a.Value
+	}
+
+	alpha := a.Alpha
+	if a.Period > 0 {
+		alpha = 1 - math.Exp(-elapsed.Seconds()/a.Period.Seconds())
+	}
+	a.Value += alpha * (rate - a.Value)
+
+	return a.Value
+}
+
+// RatesAverages tracks the averages of the server rates for
+// each one of the rate modes.
+type RatesAverages struct {
+	averages map[RateMode][]*RateAverage
+}
+
+// NewRatesAverages returns averages for all the rate modes.
+func NewRatesAverages() *RatesAverages {
+	r := &RatesAverages{averages: make(map[RateMode][]*RateAverage)}
+	for _, mode := range RateModes {
+		var period time.Duration
+		switch mode {
+		case RateModeInstant:
+			continue
+		case RateMode1m:
+			period = time.Minute
+		case RateMode5m:
+			period = 5 * time.Minute
+		case RateMode15m:
+			period = 15 * time.Minute
+		}
+
+		// One per tracked rate: in/out msgs and bytes
+		for i := 0; i < 4; i++ {
+			r.averages[mode] = append(r.averages[mode], &RateAverage{
+				Period: period,
+				Alpha:  EWMAAlpha,
+			})
+		}
+	}
+	return r
+}
+
+// Update adds the latest rates into the averages and returns
+// the averaged rates for each one of the rate modes.
+func (r *RatesAverages) Update(rates *Rates, elapsed time.Duration) map[RateMode]*Rates {
+	result := make(map[RateMode]*Rates)
+	result[RateModeInstant] = &Rates{
+		InMsgsRate:   rates.InMsgsRate,
+		OutMsgsRate:  rates.OutMsgsRate,
+		InBytesRate:  rates.InBytesRate,
+		OutBytesRate: rates.OutBytesRate,
+	}
+
+	for mode, averages := range r.averages {
+		result[mode] = &Rates{
+			InMsgsRate:   averages[0].Update(rates.InMsgsRate, elapsed),
+			OutMsgsRate:  averages[1].Update(rates.OutMsgsRate, elapsed),
+			InBytesRate:  averages[2].Update(rates.InBytesRate, elapsed),
+			OutBytesRate: averages[3].Update(rates.OutBytesRate, elapsed),
+		}
+	}
+
+	return result
+}
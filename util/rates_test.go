@@ -0,0 +1,70 @@
+package toputils
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRateAverage(t *testing.T) {
+	avg := &RateAverage{Alpha: 0.5}
+
+	// First sample primes the average
+	got := avg.Update(100, time.Second)
+	if got != 100 {
+		t.Fatalf("Wrong initial average. expected: %v, got: %v", 100, got)
+	}
+	got = avg.Update(200, time.Second)
+	if got != 150 {
+		t.Fatalf("Wrong average. expected: %v, got: %v", 150, got)
+	}
+
+	// With a period the weight depends on the elapsed time,
+	// so after a full period it has moved ~63% towards the rate.
+	avg = &RateAverage{Period: time.Minute}
+	avg.Update(0, time.Second)
+	got = avg.Update(1000, time.Minute)
+	expected := 1000 * (1 - math.Exp(-1))
+	if math.Abs(got-expected) > 0.001 {
+		t.Fatalf("Wrong average. expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestRatesAverages(t *testing.T) {
+	averages := NewRatesAverages()
+	averages.Update(&Rates{InMsgsRate: 1000}, time.Second)
+
+	// Spike on a single interval
+	result := averages.Update(&Rates{InMsgsRate: 10000}, time.Second)
+	if result[RateModeInstant].InMsgsRate != 10000 {
+		t.Fatalf("Wrong instant rate. expected: %v, got: %v", 10000, result[RateModeInstant].InMsgsRate)
+	}
+
+	// Longer periods are less affected by the spike
+	ewma := result[RateModeEWMA].InMsgsRate
+	oneMin := result[RateMode1m].InMsgsRate
+	fiveMin := result[RateMode5m].InMsgsRate
+	fifteenMin := result[RateMode15m].InMsgsRate
+	if !(ewma > oneMin && oneMin > fiveMin && fiveMin > fifteenMin && fifteenMin > 1000) {
+		t.Fatalf("Expected averages to be smoothed by their period, got: ewma=%v 1m=%v 5m=%v 15m=%v",
+			ewma, oneMin, fiveMin, fifteenMin)
+	}
+}
+
+func TestRateModes(t *testing.T) {
+	mode := RateModeInstant
+	for i := 0; i < len(RateModes); i++ {
+		parsed, err := ParseRateMode(mode.String())
+		if err != nil || parsed != mode {
+			t.Fatalf("Could not parse rate mode %v. got: %v, %v", mode, parsed, err)
+		}
+		mode = mode.Next()
+	}
+	if mode != RateModeInstant {
+		t.Fatalf("Expected to cycle back to instant rates, got: %v", mode)
+	}
+
+	if _, err := ParseRateMode("1h"); err == nil {
+		t.Fatalf("Expected error parsing invalid rate mode")
+	}
+}
@@ -0,0 +1,142 @@
+package toputils
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Recorder writes the snapshots polled from the server to a file,
+// one json object per line, so that they can be analyzed afterwards.
+type Recorder struct {
+	sync.Mutex
+	path     string
+	compress bool
+	maxSize  int64
+
+	file    *os.File
+	gz      *gzip.Writer
+	w       io.Writer
+	size    int64
+	seq     uint64
+	rotated int
+	closed  bool
+}
+
+// NewRecorder creates the file at path, which is gzip compressed in
+// case compress is set. Once a file is larger than maxSize bytes it
+// is rotated to path.1, path.2 and so on, or never when maxSize is 0.
+func NewRecorder(path string, compress bool, maxSize int64) (*Recorder, error) {
+	r := &Recorder{path: path, compress: compress, maxSize: maxSize}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Recorder) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("could not create recording: %v\n", err)
+	}
+	r.file = file
+	r.size = 0
+	r.w = &countingWriter{file, &r.size}
+	if r.compress {
+		r.gz = gzip.NewWriter(r.w)
+		r.w = r.gz
+	}
+	return nil
+}
+
+// Record appends the snapshot to the recording, numbering
+// it by its position since the recording started.
+func (r *Recorder) Record(snap *Snapshot) error {
+	r.Lock()
+	defer r.Unlock()
+	if r.closed {
+		return fmt.Errorf("recording already closed\n")
+	}
+
+	r.seq++
+	recorded := *snap
+	recorded.Seq = r.seq
+	data, err := json.Marshal(&recorded)
+	if err != nil {
+		data, err = json.Marshal(&Snapshot{
+			Seq:   recorded.Seq,
+			Time:  recorded.Time,
+			Error: fmt.Sprintf("could not marshal snapshot: %v\n", err),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	data = append(data, '\n')
+
+	if _, err := r.w.Write(data); err != nil {
+		return fmt.Errorf("could not write recording: %v\n", err)
+	}
+	// Flush each snapshot so that the recording can
+	// be used even if nats-top does not exit cleanly.
+	if r.gz != nil {
+		if err := r.gz.Flush(); err != nil {
+			return fmt.Errorf("could not write recording: %v\n", err)
+		}
+	}
+
+	if r.maxSize > 0 && r.size >= r.maxSize {
+		return r.rotate()
+	}
+	return nil
+}
+
+// rotate moves the current file aside and starts a new one.
+func (r *Recorder) rotate() error {
+	if err := r.closeFile(); err != nil {
+		return err
+	}
+	r.rotated++
+	if err := os.Rename(r.path, fmt.Sprintf("%s.%d", r.path, r.rotated)); err != nil {
+		return fmt.Errorf("could not rotate recording: %v\n", err)
+	}
+	return r.open()
+}
+
+func (r *Recorder) closeFile() error {
+	if r.gz != nil {
+		if err := r.gz.Close(); err != nil {
+			r.file.Close()
+			return fmt.Errorf("could not write recording: %v\n", err)
+		}
+		r.gz = nil
+	}
+	return r.file.Close()
+}
+
+// Close finishes the recording.
+func (r *Recorder) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	return r.closeFile()
+}
+
+// countingWriter keeps track of the bytes written to a file,
+// which are compressed already in case of compression.
+type countingWriter struct {
+	w    io.Writer
+	size *int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.size += int64(n)
+	return n, err
+}
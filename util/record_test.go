@@ -0,0 +1,125 @@
+package toputils
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readRecording(t *testing.T, path string, compressed bool) []*Snapshot {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Could not open recording: %v", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if compressed {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("Could not decompress recording: %v", err)
+		}
+		r = gz
+	}
+
+	snaps := make([]*Snapshot, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		snap := &Snapshot{}
+		if err := json.Unmarshal(scanner.Bytes(), snap); err != nil {
+			t.Fatalf("Could not unmarshal snapshot: %v", err)
+		}
+		snaps = append(snaps, snap)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Could not read recording: %v", err)
+	}
+	return snaps
+}
+
+func TestRecorder(t *testing.T) {
+	ts := runFixtureServer()
+	defer ts.Close()
+
+	engine := newFixtureEngine(ts)
+	if err := engine.SetupCapabilities(); err != nil {
+		t.Fatalf("Failed detecting capabilities: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "nats-top")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, compressed := range []bool{false, true} {
+		path := filepath.Join(dir, "session.ndjson")
+		recorder, err := NewRecorder(path, compressed, 0)
+		if err != nil {
+			t.Fatalf("Could not start recording: %v", err)
+		}
+		engine.Recorder = recorder
+		for i := 0; i < 3; i++ {
+			if err := engine.Record(engine.Poll()); err != nil {
+				t.Fatalf("Failed recording snapshot: %v", err)
+			}
+		}
+		if err := recorder.Close(); err != nil {
+			t.Fatalf("Failed closing recording: %v", err)
+		}
+
+		snaps := readRecording(t, path, compressed)
+		if len(snaps) != 3 {
+			t.Fatalf("Wrong number of recorded snapshots. expected: 3, got: %d", len(snaps))
+		}
+		for i, snap := range snaps {
+			if snap.Seq != uint64(i+1) || snap.Time.IsZero() {
+				t.Fatalf("Wrong sequence or time of snapshot. got: %d at %v", snap.Seq, snap.Time)
+			}
+		}
+
+		stats := newStatsTracker(nil).update(snaps[0])
+		if stats.Error.Error() != "" {
+			t.Fatalf("Failed decoding recorded snapshot: %v", stats.Error)
+		}
+		if len(stats.Connz.Conns) != 2 || stats.Jsz == nil {
+			t.Fatalf("Expected the responses to be recorded. got: %+v", stats)
+		}
+	}
+}
+
+func TestRecorderRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nats-top")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "session.ndjson")
+	recorder, err := NewRecorder(path, false, 10)
+	if err != nil {
+		t.Fatalf("Could not start recording: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := recorder.Record(&Snapshot{Error: "timeout"}); err != nil {
+			t.Fatalf("Failed recording snapshot: %v", err)
+		}
+	}
+	recorder.Close()
+
+	for i, name := range []string{"session.ndjson.1", "session.ndjson.2", "session.ndjson.3"} {
+		snaps := readRecording(t, filepath.Join(dir, name), false)
+		if len(snaps) != 1 || snaps[0].Seq != uint64(i+1) {
+			t.Fatalf("Wrong snapshots in rotated %s. got: %+v", name, snaps)
+		}
+	}
+	if snaps := readRecording(t, path, false); len(snaps) != 0 {
+		t.Fatalf("Expected new recording to be empty. got: %+v", snaps)
+	}
+}
@@ -0,0 +1,469 @@
+package toputils
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+// ReplaySpeeds are the playback speeds, from slowest to fastest.
+var ReplaySpeeds = []float64{0.25, 0.5, 1, 2, 4, 8, 16}
+
+// replaySpeedNormal is the index of the real time speed.
+const replaySpeedNormal = 2
+
+// Recording is a file written with -record, along with the files it
+// was rotated to, whose snapshots are indexed when it is opened and
+// only decoded when needed, so that replaying it takes about as much
+// memory as the snapshots being shown no matter how long it is.
+type Recording struct {
+	sync.Mutex
+	files []recordingFile
+	snaps []recordedSnapshot
+
+	// reader is the last file read from, which is kept open so that
+	// compressed files can be read forward without starting over.
+	reader *recordingReader
+}
+
+// recordingFile is one of the files of a recording.
+type recordingFile struct {
+	path       string
+	compressed bool
+}
+
+// recordedSnapshot is where a snapshot is in the files of a recording,
+// the offset being within the decompressed file in case of compression.
+type recordedSnapshot struct {
+	file   int
+	offset int64
+	size   int
+	time   time.Time
+}
+
+// recordingReader reads the snapshots of a file in a recording.
+type recordingReader struct {
+	file int
+	f    *os.File
+	r    io.Reader
+	pos  int64
+}
+
+// OpenRecording indexes the snapshots from a file written with
+// -record, which may be gzip compressed, starting from the files it
+// was rotated to with -record-max-size, if any. A recording which was
+// cut short, e.g. when nats-top was killed, is read up to where it ends.
+func OpenRecording(path string) (*Recording, error) {
+	// Rotated files are numbered from the oldest one
+	paths := make([]string, 0)
+	for n := 1; ; n++ {
+		rotated := fmt.Sprintf("%s.%d", path, n)
+		if _, err := os.Stat(rotated); err != nil {
+			break
+		}
+		paths = append(paths, rotated)
+	}
+	paths = append(paths, path)
+
+	rec := &Recording{}
+	for _, path := range paths {
+		if err := rec.index(path); err != nil {
+			return nil, err
+		}
+	}
+	if len(rec.snaps) == 0 {
+		return nil, fmt.Errorf("no snapshots in recording %s\n", path)
+	}
+	return rec, nil
+}
+
+// openRecordingFile returns the contents of a file of a recording,
+// decompressed in case it is gzip compressed.
+func openRecordingFile(path string) (*os.File, io.Reader, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("could not open recording: %v\n", err)
+	}
+
+	br := bufio.NewReader(f)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, nil, false, fmt.Errorf("could not decompress recording: %v\n", err)
+		}
+		return f, gz, true, nil
+	}
+	return f, br, false, nil
+}
+
+// index adds the snapshots of a file to the recording, keeping
+// only their time besides where they are.
+func (rec *Recording) index(path string) error {
+	f, r, compressed, err := openRecordingFile(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rec.files = append(rec.files, recordingFile{path: path, compressed: compressed})
+
+	lr := bufio.NewReader(r)
+	var offset int64
+	for {
+		line, err := lr.ReadBytes('\n')
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("could not read recording: %v\n", err)
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			var snap struct {
+				Time time.Time `json:"time"`
+			}
+			if jerr := json.Unmarshal(line, &snap); jerr != nil {
+				// Last line is ignored in case it was not fully written
+				if err != nil {
+					break
+				}
+				return fmt.Errorf("could not unmarshal snapshot %d: %v\n", len(rec.snaps)+1, jerr)
+			}
+			rec.snaps = append(rec.snaps, recordedSnapshot{
+				file:   len(rec.files) - 1,
+				offset: offset,
+				size:   len(line),
+				time:   snap.Time,
+			})
+		}
+		offset += int64(len(line))
+		if err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Len returns the number of snapshots in the recording.
+func (rec *Recording) Len() int {
+	return len(rec.snaps)
+}
+
+// Time returns the time of the snapshot at pos.
+func (rec *Recording) Time(pos int) time.Time {
+	return rec.snaps[pos].time
+}
+
+// Snapshot reads and decodes the snapshot at pos. Compressed files
+// are read from their start unless the previous snapshot read was
+// before pos in the same file, as it happens while playing them.
+func (rec *Recording) Snapshot(pos int) (*Snapshot, error) {
+	rec.Lock()
+	defer rec.Unlock()
+
+	recorded := rec.snaps[pos]
+	file := rec.files[recorded.file]
+	if rec.reader != nil && (rec.reader.file != recorded.file || rec.reader.pos > recorded.offset) {
+		rec.reader.f.Close()
+		rec.reader = nil
+	}
+	if rec.reader == nil {
+		f, r, _, err := openRecordingFile(file.path)
+		if err != nil {
+			return nil, err
+		}
+		rec.reader = &recordingReader{file: recorded.file, f: f, r: r}
+	}
+
+	reader := rec.reader
+	if !file.compressed {
+		if _, err := reader.f.Seek(recorded.offset, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("could not read recording: %v\n", err)
+		}
+		reader.r, reader.pos = reader.f, recorded.offset
+	}
+	if _, err := io.CopyN(ioutil.Discard, reader.r, recorded.offset-reader.pos); err != nil {
+		return nil, fmt.Errorf("could not read recording: %v\n", err)
+	}
+	line := make([]byte, recorded.size)
+	n, err := io.ReadFull(reader.r, line)
+	reader.pos = recorded.offset + int64(n)
+	if err != nil {
+		return nil, fmt.Errorf("could not read recording: %v\n", err)
+	}
+
+	snap := &Snapshot{}
+	if err := json.Unmarshal(line, snap); err != nil {
+		return nil, fmt.Errorf("could not unmarshal snapshot %d: %v\n", pos+1, err)
+	}
+	return snap, nil
+}
+
+// snapshot returns the snapshot at pos, or one with the error
+// in case it could not be read so that it is shown instead.
+func (rec *Recording) snapshot(pos int) *Snapshot {
+	snap, err := rec.Snapshot(pos)
+	if err != nil {
+		return &Snapshot{Time: rec.Time(pos), Error: err.Error()}
+	}
+	return snap
+}
+
+// Close closes the file being read from, if any.
+func (rec *Recording) Close() error {
+	rec.Lock()
+	defer rec.Unlock()
+	if rec.reader == nil {
+		return nil
+	}
+	err := rec.reader.f.Close()
+	rec.reader = nil
+	return err
+}
+
+// ReadRecording returns all the snapshots of a recording opened
+// with OpenRecording, which are all kept in memory, e.g. to be
+// polled from a RecordingSource.
+func ReadRecording(path string) ([]*Snapshot, error) {
+	rec, err := OpenRecording(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rec.Close()
+
+	snaps := make([]*Snapshot, 0, rec.Len())
+	for pos := 0; pos < rec.Len(); pos++ {
+		snap, err := rec.Snapshot(pos)
+		if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, snap)
+	}
+	return snaps, nil
+}
+
+// Playback is where a replay is at, shown in the header.
+type Playback struct {
+	Pos    int
+	Len    int
+	Time   time.Time
+	Start  time.Time
+	End    time.Time
+	Speed  float64
+	Paused bool
+}
+
+// Player keeps the position and controls of a replay, which
+// are changed from the UI while ReplayStats is playing it.
+type Player struct {
+	sync.Mutex
+	rec     *Recording
+	pos     int
+	speed   int
+	paused  bool
+	changed chan struct{}
+}
+
+// NewPlayer returns a player at the first snapshot, at real time speed.
+func NewPlayer(rec *Recording) *Player {
+	return &Player{
+		rec:     rec,
+		speed:   replaySpeedNormal,
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// notify wakes up the playback after a change in the controls.
+func (p *Player) notify() {
+	select {
+	case p.changed <- struct{}{}:
+	default:
+	}
+}
+
+// TogglePause pauses or resumes the playback.
+func (p *Player) TogglePause() {
+	p.Lock()
+	p.paused = !p.paused
+	p.Unlock()
+	p.notify()
+}
+
+// Faster increases the playback speed, up to the fastest one.
+func (p *Player) Faster() {
+	p.Lock()
+	if p.speed < len(ReplaySpeeds)-1 {
+		p.speed++
+	}
+	p.Unlock()
+	p.notify()
+}
+
+// Slower decreases the playback speed, down to the slowest one.
+func (p *Player) Slower() {
+	p.Lock()
+	if p.speed > 0 {
+		p.speed--
+	}
+	p.Unlock()
+	p.notify()
+}
+
+// Step pauses the playback and moves it by n snapshots,
+// backwards in case n is negative.
+func (p *Player) Step(n int) {
+	p.Lock()
+	p.paused = true
+	p.pos = p.clamp(p.pos + n)
+	p.Unlock()
+	p.notify()
+}
+
+// Seek moves the playback to the last snapshot at or before t,
+// or to the first one in case t is before the recording started.
+func (p *Player) Seek(t time.Time) {
+	p.Lock()
+	pos := 0
+	for i := 0; i < p.rec.Len(); i++ {
+		if p.rec.Time(i).After(t) {
+			break
+		}
+		pos = i
+	}
+	p.pos = pos
+	p.Unlock()
+	p.notify()
+}
+
+// Start returns the time of the first snapshot.
+func (p *Player) Start() time.Time {
+	return p.rec.Time(0)
+}
+
+func (p *Player) clamp(pos int) int {
+	if pos < 0 {
+		return 0
+	}
+	if pos >= p.rec.Len() {
+		return p.rec.Len() - 1
+	}
+	return pos
+}
+
+// Status returns where the playback is at.
+func (p *Player) Status() *Playback {
+	p.Lock()
+	defer p.Unlock()
+	return &Playback{
+		Pos:    p.pos,
+		Len:    p.rec.Len(),
+		Time:   p.rec.Time(p.pos),
+		Start:  p.rec.Time(0),
+		End:    p.rec.Time(p.rec.Len() - 1),
+		Speed:  ReplaySpeeds[p.speed],
+		Paused: p.paused,
+	}
+}
+
+// next returns the position to show and how long until the
+// following snapshot is due, which is never when paused or
+// at the end of the recording.
+func (p *Player) next() (int, <-chan time.Time) {
+	p.Lock()
+	defer p.Unlock()
+	if p.paused || p.pos >= p.rec.Len()-1 {
+		return p.pos, nil
+	}
+	gap := p.rec.Time(p.pos + 1).Sub(p.rec.Time(p.pos))
+	return p.pos, time.After(time.Duration(float64(gap) / ReplaySpeeds[p.speed]))
+}
+
+// advance moves to the following snapshot, unless
+// the controls were changed in the meantime.
+func (p *Player) advance(from int) {
+	p.Lock()
+	defer p.Unlock()
+	if !p.paused && p.pos == from {
+		p.pos = p.clamp(p.pos + 1)
+	}
+}
+
+// ReplayStats is ran as a goroutine instead of MonitorStats, sending
+// the stats from the recorded snapshots as the player moves through
+// them. The sorting, limit and account of the engine are applied locally,
+// and the stats are sent again every interval so that they apply while paused.
+func (engine *Engine) ReplayStats(player *Player) error {
+	if engine.Capabilities == nil {
+		engine.Capabilities = player.rec.snapshot(0).Capabilities
+	}
+
+	var tracker *statsTracker
+	var last *Stats
+	shown := -1
+
+	for {
+		pos, due := player.next()
+
+		if pos != shown {
+			// Rates need the previous snapshots, so jumping anywhere
+			// but the following one starts tracking them again.
+			if tracker == nil || pos != shown+1 {
+				tracker = engine.replayTracker(player.rec, pos)
+			}
+			last = tracker.update(player.rec.snapshot(pos))
+			shown = pos
+		}
+
+		stats := *last
+		stats.Playback = player.Status()
+		if stats.Error.Error() == "" {
+			connz := *last.Connz
+			connz.Conns = append([]gnatsd.ConnInfo(nil), last.Connz.Conns...)
+			stats.Connz = &connz
+			engine.localView(&stats)
+		}
+		if !engine.publish(&stats) {
+			return nil
+		}
+
+		// Refresh the stats while waiting for the controls
+		var refresh <-chan time.Time
+		if due == nil {
+			refresh = time.After(engine.Settings().PollInterval())
+		}
+		select {
+		case <-engine.ShutdownCh:
+			return nil
+		case <-player.changed:
+		case <-due:
+			player.advance(pos)
+		case <-refresh:
+		}
+	}
+}
+
+// replayTracker returns a tracker which has gone through the snapshots
+// before pos, as far back as the history of the connections is kept.
+func (engine *Engine) replayTracker(rec *Recording, pos int) *statsTracker {
+	from := pos - 1
+	if engine.History != nil {
+		engine.History.Reset()
+		for from > 0 && rec.Time(pos).Sub(rec.Time(from-1)) <= engine.History.MaxAge {
+			from--
+		}
+	}
+	if from < 0 {
+		from = 0
+	}
+
+	tracker := newStatsTracker(engine.History)
+	for i := from; i < pos; i++ {
+		tracker.update(rec.snapshot(i))
+	}
+	return tracker
+}
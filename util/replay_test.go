@@ -0,0 +1,192 @@
+package toputils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// recordFixture records n snapshots from the fixture server,
+// which are a second apart from each other.
+func recordFixture(t *testing.T, path string, compress bool, n int) {
+	ts := runFixtureServer()
+	defer ts.Close()
+
+	engine := newFixtureEngine(ts)
+	if err := engine.SetupCapabilities(); err != nil {
+		t.Fatalf("Failed detecting capabilities: %v", err)
+	}
+	recorder, err := NewRecorder(path, compress, 0)
+	if err != nil {
+		t.Fatalf("Could not start recording: %v", err)
+	}
+	defer recorder.Close()
+
+	start := time.Date(2023, 11, 2, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		snap := engine.Poll()
+		snap.Time = start.Add(time.Duration(i) * time.Second)
+		if err := recorder.Record(snap); err != nil {
+			t.Fatalf("Failed recording snapshot: %v", err)
+		}
+	}
+}
+
+func TestReadRecording(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nats-top")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "session.ndjson.gz")
+	recordFixture(t, path, true, 3)
+	snaps, err := ReadRecording(path)
+	if err != nil {
+		t.Fatalf("Failed reading compressed recording: %v", err)
+	}
+	if len(snaps) != 3 || snaps[2].Seq != 3 {
+		t.Fatalf("Wrong snapshots from compressed recording. got: %d", len(snaps))
+	}
+
+	// Recording which was cut short in the middle of a line
+	path = filepath.Join(dir, "session.ndjson")
+	recordFixture(t, path, false, 3)
+	data, _ := ioutil.ReadFile(path)
+	ioutil.WriteFile(path, data[:len(data)-20], 0644)
+	snaps, err = ReadRecording(path)
+	if err != nil {
+		t.Fatalf("Failed reading truncated recording: %v", err)
+	}
+	if len(snaps) != 2 {
+		t.Fatalf("Expected the complete snapshots from truncated recording. got: %d", len(snaps))
+	}
+
+	ioutil.WriteFile(path, nil, 0644)
+	if _, err := ReadRecording(path); err == nil {
+		t.Fatalf("Expected error reading empty recording")
+	}
+}
+
+func TestOpenRecording(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nats-top")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Snapshots of the rotated files come before the ones of the file itself
+	path := filepath.Join(dir, "session.ndjson.gz")
+	recorder, err := NewRecorder(path, true, 10)
+	if err != nil {
+		t.Fatalf("Could not start recording: %v", err)
+	}
+	start := time.Date(2023, 11, 2, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		snap := &Snapshot{Time: start.Add(time.Duration(i) * time.Second), Error: "timeout"}
+		if err := recorder.Record(snap); err != nil {
+			t.Fatalf("Failed recording snapshot: %v", err)
+		}
+	}
+	recorder.Close()
+
+	rec, err := OpenRecording(path)
+	if err != nil {
+		t.Fatalf("Failed opening rotated recording: %v", err)
+	}
+	defer rec.Close()
+	if rec.Len() != 4 || !rec.Time(3).Equal(start.Add(3*time.Second)) {
+		t.Fatalf("Wrong snapshots from rotated recording. got: %d", rec.Len())
+	}
+
+	// Snapshots are read in any order, going back within compressed files
+	for _, pos := range []int{2, 3, 0, 1, 1} {
+		snap, err := rec.Snapshot(pos)
+		if err != nil || snap.Seq != uint64(pos+1) {
+			t.Fatalf("Wrong snapshot %d. got: %+v, %v", pos, snap, err)
+		}
+	}
+
+	// Uncompressed files are read the same
+	path = filepath.Join(dir, "session.ndjson")
+	recordFixture(t, path, false, 3)
+	rec, err = OpenRecording(path)
+	if err != nil {
+		t.Fatalf("Failed opening recording: %v", err)
+	}
+	defer rec.Close()
+	for _, pos := range []int{2, 0, 1} {
+		snap, err := rec.Snapshot(pos)
+		if err != nil || snap.Seq != uint64(pos+1) || len(snap.Connz) == 0 {
+			t.Fatalf("Wrong snapshot %d. got: %+v, %v", pos, snap, err)
+		}
+	}
+}
+
+func TestReplayStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nats-top")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "session.ndjson")
+	recordFixture(t, path, false, 5)
+	rec, err := OpenRecording(path)
+	if err != nil {
+		t.Fatalf("Failed opening recording: %v", err)
+	}
+	defer rec.Close()
+
+	player := NewPlayer(rec)
+	player.TogglePause()
+	engine := NewEngine("", 0, 1, 1)
+	engine.SortOpt = "msgs_to"
+	go engine.ReplayStats(player)
+	defer close(engine.ShutdownCh)
+
+	// Stats are sent again on every change of the controls,
+	// so wait for the ones with the expected playback.
+	waitFor := func(ok func(p *Playback) bool) *Stats {
+		deadline := time.After(3 * time.Second)
+		for {
+			select {
+			case stats := <-engine.StatsCh:
+				if ok(stats.Playback) {
+					return stats
+				}
+			case <-deadline:
+				t.Fatalf("Timed out waiting for stats")
+			}
+		}
+	}
+
+	stats := waitFor(func(p *Playback) bool { return p != nil })
+	if stats.Playback.Pos != 0 || !stats.Playback.Paused || stats.Playback.Len != 5 {
+		t.Fatalf("Wrong playback status. got: %+v", stats.Playback)
+	}
+	if len(stats.Connz.Conns) != 1 || stats.Connz.Conns[0].Cid != 5 {
+		t.Fatalf("Expected the connections to be sorted and limited locally. got: %+v", stats.Connz.Conns)
+	}
+
+	player.Seek(rec.Time(3).Add(500 * time.Millisecond))
+	stats = waitFor(func(p *Playback) bool { return p.Pos == 3 })
+	if !stats.Playback.Time.Equal(rec.Time(3)) {
+		t.Fatalf("Wrong time after seeking. got: %+v", stats.Playback)
+	}
+
+	player.Step(-1)
+	waitFor(func(p *Playback) bool { return p.Pos == 2 })
+
+	// Playing at 16x goes through the rest of the recording
+	for i := 0; i < len(ReplaySpeeds); i++ {
+		player.Faster()
+	}
+	player.TogglePause()
+	stats = waitFor(func(p *Playback) bool { return p.Pos == 4 })
+	if stats.Playback.Speed != 16 || stats.Playback.Paused || stats.Error.Error() != "" {
+		t.Fatalf("Wrong playback at the end. got: %+v, %v", stats.Playback, stats.Error)
+	}
+}
@@ -0,0 +1,153 @@
+package toputils
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLookupWorkers is the number of lookups made at once.
+	DefaultLookupWorkers = 4
+
+	// DefaultLookupTimeout is how long to wait for each lookup.
+	DefaultLookupTimeout = 2 * time.Second
+
+	// DefaultLookupTTL is how long a hostname is kept before
+	// looking it up again, while still using it meanwhile.
+	DefaultLookupTTL = 10 * time.Minute
+
+	// DefaultLookupNegativeTTL is how long to wait before
+	// trying again the addresses which could not be resolved.
+	DefaultLookupNegativeTTL = time.Minute
+)
+
+// lookupQueueSize is the number of addresses waiting to be looked
+// up, after which they are looked up once the queue has room again.
+const lookupQueueSize = 1024
+
+// Resolver makes reverse DNS lookups of the addresses of the clients
+// in the background with a bounded number of workers, so that callers
+// never wait on a slow resolver and get the hostname once resolved.
+// Addresses which are no longer looked up are evicted a TTL after their
+// hostname expires, so the cache only grows with the recent clients.
+type Resolver struct {
+	Workers     int
+	Timeout     time.Duration
+	TTL         time.Duration
+	NegativeTTL time.Duration
+
+	mu       sync.Mutex
+	cache    map[string]*lookupEntry
+	inflight map[string]bool
+	queue    chan string
+	start    sync.Once
+
+	// evicted is when the cache was last gone through for eviction.
+	evicted time.Time
+
+	// Replaced in tests
+	lookupAddr func(ctx context.Context, addr string) ([]string, error)
+	now        func() time.Time
+}
+
+type lookupEntry struct {
+	hostname string
+	expires  time.Time
+}
+
+// NewResolver returns a resolver with the default settings, which
+// can be changed before the first lookup.
+func NewResolver() *Resolver {
+	return &Resolver{
+		Workers:     DefaultLookupWorkers,
+		Timeout:     DefaultLookupTimeout,
+		TTL:         DefaultLookupTTL,
+		NegativeTTL: DefaultLookupNegativeTTL,
+		cache:       make(map[string]*lookupEntry),
+		inflight:    make(map[string]bool),
+		queue:       make(chan string, lookupQueueSize),
+		lookupAddr:  net.DefaultResolver.LookupAddr,
+		now:         time.Now,
+	}
+}
+
+// Lookup returns the hostname of the address in case it was resolved,
+// otherwise it is looked up in the background and false is returned
+// meanwhile, as well as when it could not be resolved. Hostnames are
+// looked up again once their TTL expires, returning the previous
+// one until then.
+func (r *Resolver) Lookup(ip string) (string, bool) {
+	r.start.Do(func() {
+		for i := 0; i < r.Workers; i++ {
+			go r.work()
+		}
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	r.evictLocked(now)
+	entry, ok := r.cache[ip]
+	if !ok || !now.Before(entry.expires) {
+		r.enqueueLocked(ip)
+	}
+	if !ok || entry.hostname == "" {
+		return "", false
+	}
+	return entry.hostname, true
+}
+
+// evictLocked removes the entries which expired more than a TTL ago,
+// since they are refreshed when looked up after expiring. The cache
+// is gone through at most once every TTL so that lookups stay cheap.
+func (r *Resolver) evictLocked(now time.Time) {
+	if now.Sub(r.evicted) < r.TTL {
+		return
+	}
+	r.evicted = now
+	for ip, entry := range r.cache {
+		if !r.inflight[ip] && now.After(entry.expires.Add(r.TTL)) {
+			delete(r.cache, ip)
+		}
+	}
+}
+
+func (r *Resolver) enqueueLocked(ip string) {
+	if r.inflight[ip] {
+		return
+	}
+	select {
+	case r.queue <- ip:
+		r.inflight[ip] = true
+	default:
+	}
+}
+
+func (r *Resolver) work() {
+	for ip := range r.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), r.Timeout)
+		addrs, err := r.lookupAddr(ctx, ip)
+		cancel()
+
+		// The resolved hostname can be empty even without errors
+		entry := &lookupEntry{}
+		if err == nil && len(addrs) > 0 && len(addrs[0]) > 0 {
+			entry.hostname = addrs[0]
+			entry.expires = r.now().Add(r.TTL)
+		} else {
+			entry.expires = r.now().Add(r.NegativeTTL)
+		}
+
+		r.mu.Lock()
+		// Keep the previous hostname in case refreshing it failed
+		if last, ok := r.cache[ip]; ok && entry.hostname == "" && last.hostname != "" {
+			entry.hostname = last.hostname
+		}
+		r.cache[ip] = entry
+		delete(r.inflight, ip)
+		r.mu.Unlock()
+	}
+}
@@ -0,0 +1,187 @@
+package toputils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLookups resolves the addresses from a map, blocking
+// lookups until released and counting the concurrent ones.
+type fakeLookups struct {
+	sync.Mutex
+	hosts   map[string]string
+	calls   map[string]int
+	running int
+	max     int
+	release chan struct{}
+}
+
+func (f *fakeLookups) lookupAddr(ctx context.Context, ip string) ([]string, error) {
+	f.Lock()
+	f.calls[ip]++
+	f.running++
+	if f.running > f.max {
+		f.max = f.running
+	}
+	f.Unlock()
+	defer func() {
+		f.Lock()
+		f.running--
+		f.Unlock()
+	}()
+
+	select {
+	case <-f.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	if host, ok := f.hosts[ip]; ok {
+		return []string{host}, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (f *fakeLookups) numCalls(ip string) int {
+	f.Lock()
+	defer f.Unlock()
+	return f.calls[ip]
+}
+
+func waitLookup(t *testing.T, r *Resolver, ip string, expected string, ok bool) {
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		host, resolved := r.Lookup(ip)
+		if host == expected && resolved == ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Wrong lookup of %s. expected: %q %v, got: %q %v", ip, expected, ok, host, resolved)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestResolver(t *testing.T) {
+	fake := &fakeLookups{
+		hosts:   map[string]string{"10.0.0.1": "one.example.com."},
+		calls:   make(map[string]int),
+		release: make(chan struct{}),
+	}
+	close(fake.release)
+
+	var mu sync.Mutex
+	now := time.Now()
+	r := NewResolver()
+	r.lookupAddr = fake.lookupAddr
+	r.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		now = now.Add(d)
+		mu.Unlock()
+	}
+
+	// Addresses are not resolved until looked up in the background
+	if host, ok := r.Lookup("10.0.0.1"); ok || host != "" {
+		t.Fatalf("Expected lookup to be pending. got: %q", host)
+	}
+	waitLookup(t, r, "10.0.0.1", "one.example.com.", true)
+	waitLookup(t, r, "10.0.0.2", "", false)
+	if n := fake.numCalls("10.0.0.1"); n != 1 {
+		t.Fatalf("Expected hostname to be cached. got: %d lookups", n)
+	}
+
+	// Failed lookups are tried again after the negative TTL
+	r.Lookup("10.0.0.2")
+	time.Sleep(20 * time.Millisecond)
+	if n := fake.numCalls("10.0.0.2"); n != 1 {
+		t.Fatalf("Expected failed lookup to be cached. got: %d lookups", n)
+	}
+	fake.Lock()
+	fake.hosts["10.0.0.2"] = "two.example.com."
+	fake.Unlock()
+	advance(DefaultLookupNegativeTTL)
+	waitLookup(t, r, "10.0.0.2", "two.example.com.", true)
+
+	// Hostnames are refreshed after the TTL, using the previous ones meanwhile
+	fake.Lock()
+	fake.hosts["10.0.0.1"] = "renamed.example.com."
+	fake.Unlock()
+	advance(DefaultLookupTTL)
+	if host, ok := r.Lookup("10.0.0.1"); !ok || host != "one.example.com." && host != "renamed.example.com." {
+		t.Fatalf("Expected previous hostname while refreshing. got: %q", host)
+	}
+	waitLookup(t, r, "10.0.0.1", "renamed.example.com.", true)
+
+	// Addresses which are no longer looked up are evicted
+	advance(DefaultLookupTTL)
+	waitLookup(t, r, "10.0.0.1", "renamed.example.com.", true)
+	advance(DefaultLookupTTL)
+	r.Lookup("10.0.0.1")
+	r.mu.Lock()
+	_, cached := r.cache["10.0.0.2"]
+	size := len(r.cache)
+	r.mu.Unlock()
+	if cached || size != 1 {
+		t.Fatalf("Expected address no longer looked up to be evicted. got: %d entries", size)
+	}
+}
+
+func TestResolverSlowLookups(t *testing.T) {
+	fake := &fakeLookups{
+		hosts:   map[string]string{},
+		calls:   make(map[string]int),
+		release: make(chan struct{}),
+	}
+	r := NewResolver()
+	r.Workers = 2
+	r.Timeout = 50 * time.Millisecond
+	r.lookupAddr = fake.lookupAddr
+
+	// Lookups never block callers, and only as many as
+	// the workers are made at once until they time out.
+	ips := make([]string, 10)
+	for i := range ips {
+		ips[i] = fmt.Sprintf("10.0.1.%d", i)
+	}
+	start := time.Now()
+	for _, ip := range ips {
+		if _, ok := r.Lookup(ip); ok {
+			t.Fatalf("Expected lookup of %s to be pending", ip)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Fatalf("Lookups took too long: %v", elapsed)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		total := 0
+		for _, ip := range ips {
+			total += fake.numCalls(ip)
+		}
+		if total == 10 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected all addresses to be looked up. got: %d", total)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	fake.Lock()
+	defer fake.Unlock()
+	if fake.max != 2 {
+		t.Fatalf("Expected lookups to be bounded by the workers. got: %d at once", fake.max)
+	}
+}
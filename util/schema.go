@@ -0,0 +1,174 @@
+package toputils
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unmarshalTolerant decodes json into the vendored gnatsd structs,
+// ignoring the fields from newer versions of the server which have
+// a different type than the ones we know about. In that case the
+// rest of the fields are still decoded.
+func unmarshalTolerant(data []byte, v interface{}) error {
+	err := json.Unmarshal(data, v)
+	if _, ok := err.(*json.UnmarshalTypeError); ok {
+		return nil
+	}
+	return err
+}
+
+// VarzExt has the fields from /varz which were added in newer
+// versions of the server, and which are missing in gnatsd.Varz.
+type VarzExt struct {
+	ServerName         string              `json:"server_name,omitempty"`
+	Leafnodes          int                 `json:"leafnodes,omitempty"`
+	SlowConsumersStats *SlowConsumersStats `json:"slow_consumer_stats,omitempty"`
+	ConfigLoadTime     time.Time           `json:"config_load_time,omitempty"`
+	Tags               []string            `json:"tags,omitempty"`
+	SystemAccount      string              `json:"system_account,omitempty"`
+}
+
+// SlowConsumersStats breaks down the slow consumers by kind of connection.
+type SlowConsumersStats struct {
+	Clients  uint64 `json:"clients"`
+	Routes   uint64 `json:"routes"`
+	Gateways uint64 `json:"gateways"`
+	Leafs    uint64 `json:"leafs"`
+}
+
+// ConnInfoExt has the fields for a connection from /connz which were
+// added in newer versions of the server, and which are missing in
+// gnatsd.ConnInfo.
+type ConnInfoExt struct {
+	Cid          uint64         `json:"cid"`
+	Kind         string         `json:"kind,omitempty"`
+	Type         string         `json:"type,omitempty"`
+	RTT          string         `json:"rtt,omitempty"`
+	Account      string         `json:"account,omitempty"`
+	TLSPeerCerts []*TLSPeerCert `json:"tls_peer_certs,omitempty"`
+}
+
+// TLSPeerCert is the info about a certificate presented by a client.
+type TLSPeerCert struct {
+	Subject          string `json:"subject,omitempty"`
+	SubjectPKISha256 string `json:"spki_sha256,omitempty"`
+	CertSha256       string `json:"cert_sha256,omitempty"`
+}
+
+type connzExt struct {
+	Conns []*ConnInfoExt `json:"connections"`
+}
+
+// DecodeVarzExt returns the newer fields from a /varz response,
+// which are all empty in case of older versions of the server.
+func DecodeVarzExt(body []byte) *VarzExt {
+	varz := &VarzExt{}
+	unmarshalTolerant(body, varz)
+	return varz
+}
+
+// DecodeConnsExt returns the newer fields from each one of the
+// connections in a /connz response, indexed by their cid.
+func DecodeConnsExt(body []byte) map[uint64]*ConnInfoExt {
+	connz := &connzExt{}
+	unmarshalTolerant(body, connz)
+
+	conns := make(map[uint64]*ConnInfoExt)
+	for _, conn := range connz.Conns {
+		if conn != nil {
+			conns[conn.Cid] = conn
+		}
+	}
+	return conns
+}
+
+// Capabilities describes what can be polled from a server, which
+// is detected from its version and the endpoints that are listed
+// by its root endpoint.
+type Capabilities struct {
+	Version   string
+	Major     int
+	Minor     int
+	Patch     int
+	Endpoints map[string]bool
+}
+
+// Endpoints which are available in all versions of the server.
+var baseEndpoints = []string{"/varz", "/connz", "/routez", "/subsz", "/stacksz"}
+
+// Endpoints that were added in newer versions, along with
+// the first version which included them.
+var versionedEndpoints = map[string][3]int{
+	"/gatewayz": {2, 0, 0},
+	"/leafz":    {2, 0, 0},
+	"/accountz": {2, 2, 0},
+	"/jsz":      {2, 2, 0},
+}
+
+var endpointLinkRe = regexp.MustCompile(`href=["']?[^"'\s>]*?(/[a-z]+z)\b`)
+
+// DetectCapabilities returns the capabilities of a server from its
+// version and the html from the root endpoint, which may be missing.
+// The endpoints linked from the root endpoint are added to the ones
+// inferred from the version, since servers do not link all of them,
+// e.g. /stacksz.
+func DetectCapabilities(version string, root []byte) *Capabilities {
+	c := &Capabilities{
+		Version:   version,
+		Endpoints: make(map[string]bool),
+	}
+	c.Major, c.Minor, c.Patch = parseVersion(version)
+
+	for _, match := range endpointLinkRe.FindAllSubmatch(root, -1) {
+		c.Endpoints[string(match[1])] = true
+	}
+	for _, path := range baseEndpoints {
+		c.Endpoints[path] = true
+	}
+	for path, v := range versionedEndpoints {
+		if c.AtLeast(v[0], v[1], v[2]) {
+			c.Endpoints[path] = true
+		}
+	}
+	return c
+}
+
+// AtLeast returns whether the version of the server
+// is the same or newer than the one given.
+func (c *Capabilities) AtLeast(major, minor, patch int) bool {
+	if c.Major != major {
+		return c.Major > major
+	}
+	if c.Minor != minor {
+		return c.Minor > minor
+	}
+	return c.Patch >= patch
+}
+
+// HasEndpoint returns whether the server has a monitoring endpoint.
+func (c *Capabilities) HasEndpoint(path string) bool {
+	return c.Endpoints[path]
+}
+
+func (c *Capabilities) String() string {
+	return fmt.Sprintf("%d.%d.%d", c.Major, c.Minor, c.Patch)
+}
+
+// parseVersion takes versions like 'v2.10.4-beta' and returns
+// their major, minor and patch numbers.
+func parseVersion(version string) (int, int, int) {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(version, "-+ "); i >= 0 {
+		version = version[:i]
+	}
+
+	var nums [3]int
+	for i, part := range strings.SplitN(version, ".", 3) {
+		nums[i], _ = strconv.Atoi(part)
+	}
+	return nums[0], nums[1], nums[2]
+}
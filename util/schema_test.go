@@ -0,0 +1,162 @@
+package toputils
+
+import (
+	"io/ioutil"
+	"testing"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+func TestDecodingNewerServerSchemas(t *testing.T) {
+	body, err := ioutil.ReadFile("test/varz_v2.json")
+	if err != nil {
+		t.Fatalf("Failed reading fixture: %v", err)
+	}
+	result, err := Decode("/varz", body)
+	if err != nil {
+		t.Fatalf("Failed decoding /varz from newer server: %v", err)
+	}
+	varz, ok := result.(*gnatsd.Varz)
+	if !ok {
+		t.Fatalf("Wrong type for /varz. got: %T", result)
+	}
+	if varz.Connections != 3 || varz.InMsgs != 1200 || varz.Subscriptions != 42 {
+		t.Fatalf("Wrong varz after decoding. got: %+v", varz)
+	}
+	if varz.HTTPReqStats["/varz"] != 7 {
+		t.Fatalf("Wrong http request stats. expected: %v, got: %v", 7, varz.HTTPReqStats["/varz"])
+	}
+
+	ext := DecodeVarzExt(body)
+	if ext.ServerName != "nats-east-1" {
+		t.Fatalf("Wrong server name. expected: %v, got: %v", "nats-east-1", ext.ServerName)
+	}
+	if ext.Leafnodes != 1 || ext.SystemAccount != "$SYS" || len(ext.Tags) != 2 {
+		t.Fatalf("Wrong newer fields from varz. got: %+v", ext)
+	}
+	if ext.SlowConsumersStats == nil || ext.SlowConsumersStats.Clients != 2 || ext.SlowConsumersStats.Routes != 1 {
+		t.Fatalf("Wrong slow consumers stats. got: %+v", ext.SlowConsumersStats)
+	}
+	if ext.ConfigLoadTime.IsZero() {
+		t.Fatalf("Expected config load time to be decoded")
+	}
+
+	body, err = ioutil.ReadFile("test/connz_v2.json")
+	if err != nil {
+		t.Fatalf("Failed reading fixture: %v", err)
+	}
+	result, err = Decode("/connz", body)
+	if err != nil {
+		t.Fatalf("Failed decoding /connz from newer server: %v", err)
+	}
+	connz, ok := result.(*gnatsd.Connz)
+	if !ok {
+		t.Fatalf("Wrong type for /connz. got: %T", result)
+	}
+	if connz.NumConns != 2 || len(connz.Conns) != 2 {
+		t.Fatalf("Wrong number of connections. expected: %v, got: %v", 2, len(connz.Conns))
+	}
+	if connz.Conns[0].Name != "orders" || connz.Conns[0].OutBytes != 20000 {
+		t.Fatalf("Wrong connection after decoding. got: %+v", connz.Conns[0])
+	}
+
+	conns := DecodeConnsExt(body)
+	conn, ok := conns[5]
+	if !ok {
+		t.Fatalf("Expected newer fields for connection with cid 5")
+	}
+	if conn.Kind != "Client" || conn.Type != "nats" || conn.RTT != "1.2ms" || conn.Account != "ORDERS" {
+		t.Fatalf("Wrong newer fields for connection. got: %+v", conn)
+	}
+	if len(conn.TLSPeerCerts) != 1 || conn.TLSPeerCerts[0].Subject != "CN=orders" {
+		t.Fatalf("Wrong peer certs for connection. got: %+v", conn.TLSPeerCerts)
+	}
+	if conns[7].Type != "websocket" || len(conns[7].TLSPeerCerts) != 0 {
+		t.Fatalf("Wrong newer fields for connection. got: %+v", conns[7])
+	}
+}
+
+func TestDecodingOlderServerSchemas(t *testing.T) {
+	body := []byte(`{"server_id":"abc","version":"0.9.4","connections":1,"in_msgs":10}`)
+
+	ext := DecodeVarzExt(body)
+	if ext.ServerName != "" || ext.SlowConsumersStats != nil || len(ext.Tags) != 0 {
+		t.Fatalf("Expected newer fields to be empty. got: %+v", ext)
+	}
+	if len(DecodeConnsExt([]byte(`{"connections":[{"cid":1,"ip":"127.0.0.1"}]}`))[1].Kind) != 0 {
+		t.Fatalf("Expected newer connection fields to be empty")
+	}
+}
+
+func TestDetectCapabilities(t *testing.T) {
+	root := []byte(`<html><body>
+	<a href=./varz>varz</a><br/>
+	<a href="./connz">connz</a><br/>
+	<a href='/jsz'>jsz</a><br/>
+	<a href=https://docs.nats.io/>help</a>
+	</body></html>`)
+
+	c := DetectCapabilities("2.10.4", root)
+	for _, path := range []string{"/varz", "/connz", "/jsz"} {
+		if !c.HasEndpoint(path) {
+			t.Fatalf("Expected endpoint %v to be detected. got: %v", path, c.Endpoints)
+		}
+	}
+
+	// Endpoints which are not linked are inferred from the version
+	for _, path := range []string{"/stacksz", "/leafz", "/accountz"} {
+		if !c.HasEndpoint(path) {
+			t.Fatalf("Expected endpoint %v from the version. got: %v", path, c.Endpoints)
+		}
+	}
+	c = DetectCapabilities("0.9.2", []byte(`<a href=/varz>varz</a><a href=/connz>connz</a>`))
+	if !c.HasEndpoint("/stacksz") || c.HasEndpoint("/jsz") {
+		t.Fatalf("Wrong endpoints for 0.9.2 with links. got: %v", c.Endpoints)
+	}
+
+	// Linked endpoints are detected regardless of the version
+	c = DetectCapabilities("1.4.0", []byte(`<a href=/leafz>leafz</a>`))
+	if !c.HasEndpoint("/leafz") || c.HasEndpoint("/gatewayz") {
+		t.Fatalf("Expected linked endpoint for 1.4.0. got: %v", c.Endpoints)
+	}
+
+	// Without links endpoints are inferred from the version
+	c = DetectCapabilities("v2.1.0", nil)
+	if !c.HasEndpoint("/varz") || !c.HasEndpoint("/leafz") || !c.HasEndpoint("/gatewayz") {
+		t.Fatalf("Expected endpoints for v2.1.0. got: %v", c.Endpoints)
+	}
+	if c.HasEndpoint("/jsz") || c.HasEndpoint("/accountz") {
+		t.Fatalf("Unexpected endpoints for v2.1.0. got: %v", c.Endpoints)
+	}
+	c = DetectCapabilities("0.9.6", nil)
+	if c.HasEndpoint("/leafz") || !c.HasEndpoint("/stacksz") {
+		t.Fatalf("Wrong endpoints for 0.9.6. got: %v", c.Endpoints)
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		major   int
+		minor   int
+		patch   int
+	}{
+		{"0.9.6", 0, 9, 6},
+		{"v2.10.4", 2, 10, 4},
+		{"2.11.0-beta.2", 2, 11, 0},
+		{"2.2", 2, 2, 0},
+		{"", 0, 0, 0},
+	}
+	for _, test := range tests {
+		major, minor, patch := parseVersion(test.version)
+		if major != test.major || minor != test.minor || patch != test.patch {
+			t.Errorf("Wrong version for %q. expected: %d.%d.%d, got: %d.%d.%d", test.version,
+				test.major, test.minor, test.patch, major, minor, patch)
+		}
+	}
+
+	c := &Capabilities{Major: 2, Minor: 2, Patch: 1}
+	if !c.AtLeast(2, 2, 0) || !c.AtLeast(1, 9, 9) || c.AtLeast(2, 10, 0) || c.AtLeast(3, 0, 0) {
+		t.Fatalf("Wrong version comparison for %v", c)
+	}
+}
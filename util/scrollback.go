@@ -0,0 +1,94 @@
+package toputils
+
+import (
+	"sync"
+)
+
+// DefaultScrollbackSize is the number of stats kept by default.
+const DefaultScrollbackSize = 60
+
+// DefaultScrollbackConns is the number of connections kept by default
+// across all the stats, since each one of them takes about half a KB
+// along with its rates, or more with its subscriptions. Fewer stats
+// are kept from servers with many connections, e.g. 6 with 10k.
+const DefaultScrollbackConns = 64 * 1024
+
+// Scrollback keeps the latest stats published by the engine, so that
+// what was shown a while ago can be looked at again while polling goes
+// on. Each one of the stats gets a sequence number, starting from 1,
+// which is used to move through them as older ones are dropped.
+type Scrollback struct {
+	sync.Mutex
+	size  int
+	stats []*Stats
+	first uint64
+
+	// MaxConns is the number of connections kept across all the
+	// stats, though the latest ones are kept regardless.
+	MaxConns int
+	conns    int
+}
+
+// NewScrollback returns a scrollback which keeps up to size stats.
+func NewScrollback(size int) *Scrollback {
+	return &Scrollback{size: size, first: 1, MaxConns: DefaultScrollbackConns}
+}
+
+// numConns returns the number of connections in the stats.
+func numConns(stats *Stats) int {
+	n := 0
+	if stats.Connz != nil {
+		n += len(stats.Connz.Conns)
+	}
+	if stats.AccountConnz != nil {
+		n += len(stats.AccountConnz.Conns)
+	}
+	return n
+}
+
+// Add keeps the stats, dropping the oldest ones once full or once
+// they have too many connections altogether.
+func (s *Scrollback) Add(stats *Stats) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.stats = append(s.stats, stats)
+	s.conns += numConns(stats)
+	for len(s.stats) > s.size || (len(s.stats) > 1 && s.conns > s.MaxConns) {
+		s.conns -= numConns(s.stats[0])
+		s.stats[0] = nil
+		s.stats = s.stats[1:]
+		s.first++
+	}
+}
+
+// Latest returns the most recent stats along with their sequence,
+// which is zero in case there are none yet.
+func (s *Scrollback) Latest() (uint64, *Stats) {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.stats) == 0 {
+		return 0, nil
+	}
+	return s.first + uint64(len(s.stats)) - 1, s.stats[len(s.stats)-1]
+}
+
+// At returns the stats with the sequence, or the oldest ones kept
+// in case they were dropped already, along with their sequence.
+func (s *Scrollback) At(seq uint64) (uint64, *Stats) {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.stats) == 0 {
+		return 0, nil
+	}
+	if seq < s.first {
+		seq = s.first
+	}
+	last := s.first + uint64(len(s.stats)) - 1
+	if seq > last {
+		seq = last
+	}
+	return seq, s.stats[seq-s.first]
+}
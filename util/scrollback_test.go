@@ -0,0 +1,83 @@
+package toputils
+
+import (
+	"testing"
+	"time"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+func TestScrollback(t *testing.T) {
+	scrollback := NewScrollback(3)
+	if seq, stats := scrollback.Latest(); seq != 0 || stats != nil {
+		t.Fatalf("Expected scrollback to be empty. got: %d, %+v", seq, stats)
+	}
+
+	all := make([]*Stats, 5)
+	for i := range all {
+		all[i] = &Stats{Time: time.Unix(int64(i), 0)}
+		scrollback.Add(all[i])
+	}
+
+	// Only the latest ones are kept
+	if seq, stats := scrollback.Latest(); seq != 5 || stats != all[4] {
+		t.Fatalf("Wrong latest stats. got: %d, %+v", seq, stats)
+	}
+	if seq, stats := scrollback.At(4); seq != 4 || stats != all[3] {
+		t.Fatalf("Wrong stats at 4. got: %d, %+v", seq, stats)
+	}
+	if seq, stats := scrollback.At(1); seq != 3 || stats != all[2] {
+		t.Fatalf("Expected the oldest stats kept. got: %d, %+v", seq, stats)
+	}
+	if seq, stats := scrollback.At(10); seq != 5 || stats != all[4] {
+		t.Fatalf("Expected the latest stats. got: %d, %+v", seq, stats)
+	}
+}
+
+func TestScrollbackMaxConns(t *testing.T) {
+	scrollback := NewScrollback(10)
+	scrollback.MaxConns = 5
+
+	withConns := func(n int) *Stats {
+		return &Stats{Connz: &gnatsd.Connz{Conns: make([]gnatsd.ConnInfo, n)}}
+	}
+	scrollback.Add(withConns(2))
+	scrollback.Add(withConns(2))
+	scrollback.Add(&Stats{})
+	if seq, _ := scrollback.At(1); seq != 1 {
+		t.Fatalf("Expected all the stats to be kept. got: %d", seq)
+	}
+
+	// Oldest stats are dropped to keep the connections bounded
+	scrollback.Add(withConns(3))
+	if seq, _ := scrollback.At(1); seq != 2 {
+		t.Fatalf("Expected the stats with too many connections to be dropped. got: %d", seq)
+	}
+
+	// Latest stats are kept regardless
+	latest := withConns(8)
+	scrollback.Add(latest)
+	if seq, stats := scrollback.At(1); seq != 5 || stats != latest {
+		t.Fatalf("Expected only the latest stats to be kept. got: %d, %+v", seq, stats)
+	}
+}
+
+func TestMonitorStatsScrollback(t *testing.T) {
+	engine := newFakeEngine(t)
+	engine.Scrollback = NewScrollback(10)
+	go engine.MonitorStats()
+	defer close(engine.ShutdownCh)
+
+	var last *Stats
+	for i := 0; i < 3; i++ {
+		select {
+		case last = <-engine.StatsCh:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("Timed out waiting for stats")
+		}
+	}
+	seq, stats := engine.Scrollback.At(3)
+	if seq != 3 || stats != last || stats.Time.IsZero() {
+		t.Fatalf("Expected stats to be kept in the scrollback. got: %d, %+v", seq, stats)
+	}
+}
@@ -0,0 +1,121 @@
+package toputils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+// FindingKind is the kind of issue found by the security audit.
+type FindingKind string
+
+const (
+	FindingPlaintext  FindingKind = "plaintext"
+	FindingWeakTLS    FindingKind = "weak_tls"
+	FindingUserConns  FindingKind = "user_conns"
+	FindingUnknownTLS FindingKind = "unknown_tls"
+)
+
+// SecurityFinding is an issue found with either a connection,
+// or with the connections from a user.
+type SecurityFinding struct {
+	Kind   FindingKind
+	Cid    uint64
+	IP     string
+	Port   int
+	User   string
+	Detail string
+}
+
+// Thresholds for flagging users with unusually many connections,
+// which are the ones having more than UserConnsFactor times the
+// median number of connections per user, and at least MinUserConns.
+const (
+	UserConnsFactor = 3
+	MinUserConns    = 5
+)
+
+// Parts of the names of ciphers which are considered weak.
+var weakCiphers = []string{"RC4", "3DES", "_DES_", "NULL", "EXPORT", "anon", "MD5"}
+
+// Versions of TLS which are considered weak.
+var weakTLSVersions = map[string]bool{"1.0": true, "1.1": true}
+
+// IsWeakTLS returns whether a TLS version or cipher suite
+// as reported by the server is considered weak.
+func IsWeakTLS(version, cipher string) bool {
+	if weakTLSVersions[version] {
+		return true
+	}
+	for _, weak := range weakCiphers {
+		if strings.Contains(cipher, weak) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditSecurity returns the plaintext connections, the ones using weak
+// TLS versions or ciphers, and the users with unusually many connections.
+// Users are only reported by the server when auth details are requested.
+func AuditSecurity(conns []gnatsd.ConnInfo) []*SecurityFinding {
+	findings := make([]*SecurityFinding, 0)
+	userConns := make(map[string]int)
+
+	for _, conn := range conns {
+		finding := &SecurityFinding{
+			Cid:  conn.Cid,
+			IP:   conn.IP,
+			Port: conn.Port,
+			User: conn.AuthorizedUser,
+		}
+		switch {
+		case conn.TLSVersion == "":
+			finding.Kind = FindingPlaintext
+			finding.Detail = "connection is not using TLS"
+			findings = append(findings, finding)
+		case strings.HasPrefix(conn.TLSVersion, "Unknown") || strings.HasPrefix(conn.TLSCipher, "Unknown"):
+			finding.Kind = FindingUnknownTLS
+			finding.Detail = fmt.Sprintf("unknown TLS version or cipher: %s %s", conn.TLSVersion, conn.TLSCipher)
+			findings = append(findings, finding)
+		case IsWeakTLS(conn.TLSVersion, conn.TLSCipher):
+			finding.Kind = FindingWeakTLS
+			finding.Detail = fmt.Sprintf("weak TLS: %s %s", conn.TLSVersion, conn.TLSCipher)
+			findings = append(findings, finding)
+		}
+
+		if conn.AuthorizedUser != "" {
+			userConns[conn.AuthorizedUser]++
+		}
+	}
+
+	// Users are sorted so that findings are stable between polls
+	users := make([]string, 0, len(userConns))
+	counts := make([]int, 0, len(userConns))
+	for user, n := range userConns {
+		users = append(users, user)
+		counts = append(counts, n)
+	}
+	sort.Strings(users)
+	sort.Ints(counts)
+
+	var median int
+	if len(counts) > 0 {
+		median = counts[len(counts)/2]
+	}
+	for _, user := range users {
+		n := userConns[user]
+		if n < MinUserConns || n <= UserConnsFactor*median {
+			continue
+		}
+		findings = append(findings, &SecurityFinding{
+			Kind:   FindingUserConns,
+			User:   user,
+			Detail: fmt.Sprintf("%d connections, median per user is %d", n, median),
+		})
+	}
+
+	return findings
+}
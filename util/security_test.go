@@ -0,0 +1,95 @@
+package toputils
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+func TestAuditSecurity(t *testing.T) {
+	conns := []gnatsd.ConnInfo{
+		{Cid: 1, IP: "10.0.0.1", Port: 1000, AuthorizedUser: "alice"},
+		{Cid: 2, TLSVersion: "1.2", TLSCipher: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", AuthorizedUser: "bob"},
+		{Cid: 3, TLSVersion: "1.2", TLSCipher: "TLS_RSA_WITH_RC4_128_SHA", AuthorizedUser: "carol"},
+		{Cid: 4, TLSVersion: "1.0", TLSCipher: "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA", AuthorizedUser: "dave"},
+		{Cid: 5, TLSVersion: "Unknown [304]", TLSCipher: "Unknown [1301]", AuthorizedUser: "erin"},
+	}
+
+	// Many connections from the same user
+	for i := 0; i < 6; i++ {
+		conns = append(conns, gnatsd.ConnInfo{
+			Cid:            uint64(10 + i),
+			TLSVersion:     "1.2",
+			TLSCipher:      "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+			AuthorizedUser: "batch",
+		})
+	}
+
+	findings := AuditSecurity(conns)
+	expected := []struct {
+		kind FindingKind
+		cid  uint64
+		user string
+	}{
+		{FindingPlaintext, 1, "alice"},
+		{FindingWeakTLS, 3, "carol"},
+		{FindingWeakTLS, 4, "dave"},
+		{FindingUnknownTLS, 5, "erin"},
+		{FindingUserConns, 0, "batch"},
+	}
+	if len(findings) != len(expected) {
+		t.Fatalf("Wrong number of findings. expected: %v, got: %v", len(expected), len(findings))
+	}
+	for i, e := range expected {
+		f := findings[i]
+		if f.Kind != e.kind || f.Cid != e.cid || f.User != e.user {
+			t.Fatalf("Wrong finding at %d. expected: %v, got: %+v", i, e, f)
+		}
+	}
+
+	// A single user with many connections is not unusual
+	conns = conns[5:]
+	for _, f := range AuditSecurity(conns) {
+		if f.Kind == FindingUserConns {
+			t.Fatalf("Unexpected finding for single user. got: %+v", f)
+		}
+	}
+
+	if IsWeakTLS("1.2", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256") || !IsWeakTLS("1.2", "TLS_RSA_WITH_3DES_EDE_CBC_SHA") {
+		t.Fatalf("Wrong detection of weak ciphers")
+	}
+}
+
+func TestRequestingAuthDetails(t *testing.T) {
+	queries := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.RawQuery
+		fmt.Fprint(w, `{"connections":[{"cid":1,"authorized_user":"alice","tls_version":"1.2","tls_cipher_suite":"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}]}`)
+	}))
+	defer ts.Close()
+
+	engine := newFixtureEngine(ts)
+	engine.DisplayAuth = true
+
+	result, err := engine.Request("/connz")
+	if err != nil {
+		t.Fatalf("Failed getting /connz: %v", err)
+	}
+	expected := "limit=10&sort=&auth=1"
+	if got := <-queries; got != expected {
+		t.Fatalf("Wrong query for /connz. expected: %v, got: %v", expected, got)
+	}
+	connz := result.(*gnatsd.Connz)
+	if conn := connz.Conns[0]; conn.AuthorizedUser != "alice" || conn.TLSVersion != "1.2" {
+		t.Fatalf("Wrong auth details for connection. got: %+v", conn)
+	}
+
+	engine.DisplayAuth = false
+	engine.Request("/connz")
+	if got := <-queries; got != "limit=10&sort=" {
+		t.Fatalf("Unexpected auth details requested. got: %v", got)
+	}
+}
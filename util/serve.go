@@ -0,0 +1,200 @@
+package toputils
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// snapshotClientBuffer is the number of snapshots buffered for each
+// attached client, after which the oldest ones are dropped.
+const snapshotClientBuffer = 4
+
+// attachDialTimeout is how long to wait for the daemon when attaching.
+const attachDialTimeout = 5 * time.Second
+
+// SnapshotServer streams the snapshots polled by a single nats-top
+// daemon to all the instances of nats-top attached to it, as
+// newline delimited json over plain TCP.
+type SnapshotServer struct {
+	sync.Mutex
+	clients map[chan []byte]struct{}
+	last    []byte
+	seq     uint64
+}
+
+// NewSnapshotServer returns a server without any snapshots yet.
+func NewSnapshotServer() *SnapshotServer {
+	return &SnapshotServer{clients: make(map[chan []byte]struct{})}
+}
+
+// Publish numbers the snapshot and sends it to all the attached clients.
+func (s *SnapshotServer) Publish(snap *Snapshot) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.seq++
+	snap.Seq = s.seq
+	data, err := json.Marshal(snap)
+	if err != nil {
+		// Responses which are not json are only
+		// reported to the clients as an error.
+		data, err = json.Marshal(&Snapshot{
+			Seq:   snap.Seq,
+			Time:  snap.Time,
+			Error: fmt.Sprintf("could not marshal snapshot: %v\n", err),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	data = append(data, '\n')
+
+	s.last = data
+	for ch := range s.clients {
+		sendDropOldest(ch, data)
+	}
+	return nil
+}
+
+// NumClients returns the number of attached clients.
+func (s *SnapshotServer) NumClients() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.clients)
+}
+
+// Serve accepts clients until the listener is closed.
+func (s *SnapshotServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveClient(conn)
+	}
+}
+
+func (s *SnapshotServer) serveClient(conn net.Conn) {
+	defer conn.Close()
+
+	ch := make(chan []byte, snapshotClientBuffer)
+	s.Lock()
+	s.clients[ch] = struct{}{}
+	if s.last != nil {
+		ch <- s.last
+	}
+	s.Unlock()
+
+	defer func() {
+		s.Lock()
+		delete(s.clients, ch)
+		s.Unlock()
+	}()
+
+	// Clients do not send anything, so reading
+	// only detects when they are gone.
+	done := make(chan struct{})
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				close(done)
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case data := <-ch:
+			if _, err := conn.Write(data); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// AttachStats is ran as a goroutine instead of MonitorStats, getting
+// the snapshots from a nats-top daemon at addr rather than polling
+// the server. The sorting, limit and account of the engine are applied
+// locally, and it keeps trying to reconnect in case of errors.
+func (engine *Engine) AttachStats(addr string) error {
+	tracker := newStatsTracker(engine.History)
+
+	for {
+		err := engine.readSnapshots(addr, tracker)
+		if err == nil {
+			return nil
+		}
+
+		stats := tracker.update(&Snapshot{Time: time.Now(), Error: err.Error()})
+		if !engine.publish(stats) {
+			return nil
+		}
+
+		select {
+		case <-engine.ShutdownCh:
+			return nil
+		case <-time.After(engine.Settings().PollInterval()):
+		}
+	}
+}
+
+// readSnapshots sends the stats from the snapshots streamed by
+// the daemon until either there is an error or on shutdown.
+func (engine *Engine) readSnapshots(addr string, tracker *statsTracker) error {
+	conn, err := net.DialTimeout("tcp", addr, attachDialTimeout)
+	if err != nil {
+		return fmt.Errorf("could not attach to %s: %v\n", addr, err)
+	}
+
+	// Unblock reading from the daemon on shutdown
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-engine.ShutdownCh:
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			select {
+			case <-engine.ShutdownCh:
+				return nil
+			default:
+			}
+			return fmt.Errorf("lost connection to %s: %v\n", addr, err)
+		}
+
+		snap := &Snapshot{}
+		if err := json.Unmarshal(line, snap); err != nil {
+			return fmt.Errorf("could not unmarshal snapshot: %v\n", err)
+		}
+		if engine.Capabilities == nil {
+			engine.Capabilities = snap.Capabilities
+		}
+
+		stats := tracker.update(snap)
+		if stats.Error.Error() == "" {
+			engine.localView(stats)
+		}
+		if err := engine.Record(snap); err != nil {
+			stats.Error = err
+		}
+
+		if !engine.publish(stats) {
+			return nil
+		}
+	}
+}
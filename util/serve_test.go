@@ -0,0 +1,186 @@
+package toputils
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+func TestSortConns(t *testing.T) {
+	now := time.Now()
+	conns := []gnatsd.ConnInfo{
+		{Cid: 3, OutMsgs: 10, LastActivity: now.Add(-time.Minute)},
+		{Cid: 1, OutMsgs: 30, LastActivity: now},
+		{Cid: 2, OutMsgs: 20, LastActivity: now.Add(-time.Hour)},
+	}
+
+	tests := []struct {
+		by   gnatsd.SortOpt
+		cids []uint64
+	}{
+		{"cid", []uint64{1, 2, 3}},
+		{"msgs_to", []uint64{1, 2, 3}},
+		{"last", []uint64{1, 3, 2}},
+		{"idle", []uint64{2, 3, 1}},
+	}
+	for _, test := range tests {
+		SortConns(conns, test.by)
+		for i, cid := range test.cids {
+			if conns[i].Cid != cid {
+				t.Fatalf("Wrong order sorting by %s. expected: %v, got: %+v", test.by, test.cids, conns)
+			}
+		}
+	}
+}
+
+func TestAttachStats(t *testing.T) {
+	ts := runFixtureServer()
+	defer ts.Close()
+
+	engine := newFixtureEngine(ts)
+	if err := engine.SetupCapabilities(); err != nil {
+		t.Fatalf("Failed detecting capabilities: %v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	defer l.Close()
+	server := NewSnapshotServer()
+	go server.Serve(l)
+
+	// Attached client with its own settings
+	client := NewEngine("", 0, 1, 1)
+	client.SortOpt = "msgs_to"
+	client.Account = "$G"
+	go client.AttachStats(l.Addr().String())
+	defer close(client.ShutdownCh)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for server.NumClients() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the client to attach")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := server.Publish(engine.Poll()); err != nil {
+		t.Fatalf("Failed publishing snapshot: %v", err)
+	}
+
+	var stats *Stats
+	select {
+	case stats = <-client.StatsCh:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out waiting for stats")
+	}
+	if stats.Error.Error() != "" {
+		t.Fatalf("Failed getting snapshot from the daemon: %v", stats.Error)
+	}
+
+	if client.Capabilities == nil || !client.Capabilities.HasEndpoint("/jsz") {
+		t.Fatalf("Expected capabilities from the snapshot. got: %v", client.Capabilities)
+	}
+	if stats.Jsz == nil || stats.Accountz == nil {
+		t.Fatalf("Expected responses from newer endpoints. got: %+v", stats)
+	}
+	if len(stats.Connz.Conns) != 1 || stats.Connz.Conns[0].Cid != 5 || stats.Connz.NumConns != 1 {
+		t.Fatalf("Expected the connections to be sorted and limited locally. got: %+v", stats.Connz)
+	}
+	if stats.AccountConnz == nil || len(stats.AccountConnz.Conns) != 1 || stats.AccountConnz.Conns[0].Cid != 7 {
+		t.Fatalf("Expected the connections from the account. got: %+v", stats.AccountConnz)
+	}
+}
+
+func TestAttachStatsReportsErrors(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	client := NewEngine("", 0, 10, 1)
+	go client.AttachStats(addr)
+	defer close(client.ShutdownCh)
+
+	select {
+	case stats := <-client.StatsCh:
+		if stats.Error.Error() == "" {
+			t.Fatalf("Expected error attaching to %s", addr)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out waiting for stats")
+	}
+}
+
+func TestFetchAllConnz(t *testing.T) {
+	const total = 2500
+	var sorts, subs []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/connz" {
+			w.Write([]byte("{}"))
+			return
+		}
+		q := r.URL.Query()
+		sorts = append(sorts, q.Get("sort"))
+		subs = append(subs, q.Get("subs"))
+		offset, _ := strconv.Atoi(q.Get("offset"))
+		limit, _ := strconv.Atoi(q.Get("limit"))
+
+		connz := &gnatsd.Connz{Total: total, Offset: offset, Limit: limit}
+		for cid := offset + 1; cid <= total && cid <= offset+limit; cid++ {
+			connz.Conns = append(connz.Conns, gnatsd.ConnInfo{Cid: uint64(cid), OutMsgs: int64(cid % 100)})
+		}
+		connz.NumConns = len(connz.Conns)
+		json.NewEncoder(w).Encode(connz)
+	}))
+	defer ts.Close()
+
+	engine := NewEngine("", 0, 10, 1)
+	engine.HttpClient = &http.Client{}
+	engine.Uri = ts.URL
+	engine.SortOpt = "msgs_to"
+	engine.AllConns = true
+
+	snap := engine.Poll()
+	result, err := Decode("/connz", snap.Connz)
+	if err != nil {
+		t.Fatalf("Failed decoding all the connections: %v", err)
+	}
+	connz := result.(*gnatsd.Connz)
+	if len(connz.Conns) != total || connz.NumConns != total || connz.Total != total || connz.Offset != 0 {
+		t.Fatalf("Expected all the connections. got: %d of %d, offset %d", connz.NumConns, connz.Total, connz.Offset)
+	}
+	for i, conn := range connz.Conns {
+		if conn.Cid != uint64(i+1) {
+			t.Fatalf("Wrong connection at %d. got: %d", i, conn.Cid)
+		}
+	}
+	if len(sorts) != 3 {
+		t.Fatalf("Expected connections to be requested in 3 pages. got: %d", len(sorts))
+	}
+	for i := range sorts {
+		if sorts[i] != "cid" || subs[i] != "" {
+			t.Fatalf("Expected pages sorted by cid without subscriptions. got: sort=%s subs=%s", sorts[i], subs[i])
+		}
+	}
+
+	// Attached instances get the top connections of the whole server
+	client := NewEngine("", 0, 5, 1)
+	client.SortOpt = "msgs_to"
+	stats := &Stats{}
+	if err := snap.decode(stats); err != nil {
+		t.Fatalf("Failed decoding snapshot: %v", err)
+	}
+	client.localView(stats)
+	if len(stats.Connz.Conns) != 5 || stats.Connz.Conns[0].OutMsgs != 99 || stats.Connz.Conns[0].Cid != 99 {
+		t.Fatalf("Wrong top connections. got: %+v", stats.Connz.Conns)
+	}
+}
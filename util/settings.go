@@ -0,0 +1,155 @@
+package toputils
+
+import (
+	"time"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+// subscriberBuffer is the number of stats buffered for each
+// subscriber, after which the oldest ones are dropped.
+const subscriberBuffer = 4
+
+// Settings are the options of the engine which can be changed while
+// it is polling, e.g. from the UI. Once polling has started they
+// should only be read and changed through Settings and UpdateSettings.
+type Settings struct {
+	Conns       int
+	SortOpt     gnatsd.SortOpt
+	Delay       int
+	DisplaySubs bool
+	DisplayAuth bool
+
+	// Interval between polls, which takes precedence over Delay in
+	// seconds when set, e.g. for sub-second intervals.
+	Interval time.Duration
+
+	// AdaptiveDelay backs off the interval in case the
+	// server gets slow to respond to /connz.
+	AdaptiveDelay bool
+
+	// Account whose connections are also polled, when
+	// drilling into it from the accounts view.
+	Account string
+
+	// PollJetStream polls the streams and consumers from /jsz in
+	// case the server supports JetStream. Since it can be a large
+	// response, it is only set while they are displayed. Recording
+	// polls them regardless.
+	PollJetStream bool
+
+	// AllConns polls all the connections of the server rather
+	// than up to Conns of them, which are then sorted by cid, e.g.
+	// for views which add them up, or for attached instances.
+	AllConns bool
+}
+
+// engineSettings are the settings as embedded in the engine, whose
+// fields are promoted to it, under another name than the Settings
+// method.
+type engineSettings Settings
+
+// PollInterval returns the time between polls, which is Interval
+// when set, otherwise Delay in seconds.
+func (s Settings) PollInterval() time.Duration {
+	if s.Interval > 0 {
+		return s.Interval
+	}
+	return time.Duration(s.Delay) * time.Second
+}
+
+// Settings returns the current settings of the engine.
+func (engine *Engine) Settings() Settings {
+	engine.mu.RLock()
+	defer engine.mu.RUnlock()
+	return Settings(engine.engineSettings)
+}
+
+// UpdateSettings changes the settings of the engine with fn,
+// which is called with the current ones while holding the lock.
+// Changes to the interval apply right away rather than after
+// the one being waited on.
+func (engine *Engine) UpdateSettings(fn func(settings *Settings)) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+
+	settings := Settings(engine.engineSettings)
+	fn(&settings)
+	changed := settings.Delay != engine.Delay || settings.Interval != engine.Interval ||
+		settings.AdaptiveDelay != engine.AdaptiveDelay
+	engine.engineSettings = engineSettings(settings)
+
+	if changed {
+		select {
+		case engine.delayChanged <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel which gets the stats from every poll,
+// along with any other subscribers and StatsCh. Stats are dropped,
+// oldest first, for subscribers which fall behind, and should not be
+// modified since they are shared with the rest of the subscribers.
+func (engine *Engine) Subscribe() <-chan *Stats {
+	ch := make(chan *Stats, subscriberBuffer)
+
+	engine.subsMu.Lock()
+	defer engine.subsMu.Unlock()
+	if engine.subscribers == nil {
+		engine.subscribers = make(map[<-chan *Stats]chan *Stats)
+	}
+	engine.subscribers[ch] = ch
+	return ch
+}
+
+// Unsubscribe stops sending stats to the channel, then closes it.
+func (engine *Engine) Unsubscribe(sub <-chan *Stats) {
+	engine.subsMu.Lock()
+	defer engine.subsMu.Unlock()
+	if ch, ok := engine.subscribers[sub]; ok {
+		delete(engine.subscribers, sub)
+		close(ch)
+	}
+}
+
+// publish sends the stats to the subscribers, then to StatsCh unless
+// it was set to nil by users which only subscribe. It returns false
+// in case the engine was shut down while waiting on StatsCh.
+func (engine *Engine) publish(stats *Stats) bool {
+	if engine.Scrollback != nil {
+		engine.Scrollback.Add(stats)
+	}
+
+	engine.subsMu.Lock()
+	for _, ch := range engine.subscribers {
+		sendStatsDropOldest(ch, stats)
+	}
+	engine.subsMu.Unlock()
+
+	if engine.StatsCh == nil {
+		return true
+	}
+	select {
+	case engine.StatsCh <- stats:
+		return true
+	case <-engine.ShutdownCh:
+		return false
+	}
+}
+
+// sendStatsDropOldest sends without blocking, discarding the oldest
+// buffered stats in case the channel is full.
+func sendStatsDropOldest(ch chan *Stats, stats *Stats) {
+	for {
+		select {
+		case ch <- stats:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package toputils
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// newFakeEngine returns an engine polling a fake source
+// which responds with the fixtures from test/.
+func newFakeEngine(t *testing.T) *Engine {
+	source := NewFakeSource()
+	for _, path := range []string{"/varz", "/connz", "/routez"} {
+		body, err := ioutil.ReadFile("test" + path + "_v2.json")
+		if err != nil {
+			t.Fatalf("Could not read fixture: %v", err)
+		}
+		source.Set(path, body)
+	}
+
+	engine := NewEngine("", 0, 10, 0)
+	engine.Source = source
+	return engine
+}
+
+func TestSettingsWhilePolling(t *testing.T) {
+	engine := newFakeEngine(t)
+	go engine.MonitorStats()
+	defer close(engine.ShutdownCh)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			engine.UpdateSettings(func(s *Settings) {
+				s.Conns = i
+				s.SortOpt = "subs"
+				s.DisplaySubs = !s.DisplaySubs
+				s.Account = "ORDERS"
+			})
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		select {
+		case <-engine.StatsCh:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("Timed out waiting for stats")
+		}
+	}
+	<-done
+
+	settings := engine.Settings()
+	if settings.Conns != 99 || settings.SortOpt != "subs" || settings.Account != "ORDERS" || settings.DisplaySubs {
+		t.Fatalf("Wrong settings. got: %+v", settings)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	engine := newFakeEngine(t)
+
+	// Only subscribers get the stats
+	engine.StatsCh = nil
+	fast := engine.Subscribe()
+	slow := engine.Subscribe()
+
+	go engine.MonitorStats()
+	defer close(engine.ShutdownCh)
+
+	for i := 0; i < subscriberBuffer*3; i++ {
+		select {
+		case stats := <-fast:
+			if stats.Error.Error() != "" || len(stats.Connz.Conns) != 2 {
+				t.Fatalf("Wrong stats from subscription. got: %+v", stats)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("Timed out waiting for stats")
+		}
+	}
+
+	// Slow subscribers only have the latest stats buffered
+	if n := len(slow); n != subscriberBuffer {
+		t.Fatalf("Expected slow subscriber to have its buffer full. got: %d", n)
+	}
+
+	engine.Unsubscribe(slow)
+	for range slow {
+	}
+	if _, ok := <-slow; ok {
+		t.Fatalf("Expected channel to be closed once unsubscribed")
+	}
+}
+
+func TestPollJetStreamSetting(t *testing.T) {
+	engine := newFakeEngine(t)
+	source := engine.Source.(*FakeSource)
+	engine.Capabilities = DetectCapabilities("2.10.4", nil)
+	source.Set("/jsz", []byte(`{"streams":1}`))
+
+	// Streams and consumers are only polled once asked for
+	if snap := engine.Poll(); len(snap.Jsz) != 0 || source.Requests("/jsz") != 0 {
+		t.Fatalf("Expected /jsz not to be polled. got: %q", snap.Jsz)
+	}
+	engine.UpdateSettings(func(s *Settings) { s.PollJetStream = true })
+	if snap := engine.Poll(); len(snap.Jsz) == 0 || source.Requests("/jsz") != 1 {
+		t.Fatalf("Expected /jsz to be polled. got: %q", snap.Jsz)
+	}
+}
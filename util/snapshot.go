@@ -0,0 +1,301 @@
+package toputils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+// Snapshot has the raw responses from a single poll of the
+// monitoring endpoints, so that they can be shared with other
+// instances of nats-top which then calculate their own stats.
+type Snapshot struct {
+	Seq          uint64          `json:"seq"`
+	Time         time.Time       `json:"time"`
+	Varz         json.RawMessage `json:"varz,omitempty"`
+	Connz        json.RawMessage `json:"connz,omitempty"`
+	Routez       json.RawMessage `json:"routez,omitempty"`
+	Leafz        json.RawMessage `json:"leafz,omitempty"`
+	Gatewayz     json.RawMessage `json:"gatewayz,omitempty"`
+	Jsz          json.RawMessage `json:"jsz,omitempty"`
+	Accountz     json.RawMessage `json:"accountz,omitempty"`
+	AccountConnz json.RawMessage `json:"account_connz,omitempty"`
+	Capabilities *Capabilities   `json:"capabilities,omitempty"`
+	Error        string          `json:"error,omitempty"`
+
+	// Errors from the optional endpoints by path, which
+	// leave the rest of the snapshot usable otherwise.
+	Errors map[string]string `json:"errors,omitempty"`
+
+	// ConnzTime is how long the server took to respond to /connz.
+	ConnzTime time.Duration `json:"connz_time,omitempty"`
+
+	// Connections decoded while polling, which are
+	// set instead of the raw responses from /connz.
+	connz           *gnatsd.Connz
+	connsExt        map[uint64]*ConnInfoExt
+	accountConnz    *gnatsd.Connz
+	accountConnsExt map[uint64]*ConnInfoExt
+}
+
+// Poll fetches the endpoints supported by the server, stopping
+// at the first one that fails in which case Error is set, unless
+// it is one of the optional endpoints whose error is set in Errors.
+func (engine *Engine) Poll() *Snapshot {
+	return engine.poll(false)
+}
+
+// poll fetches the endpoints like Poll does, and in case of decode
+// the connections from /connz are decoded as they arrive rather
+// than keeping the raw responses, which is done when the snapshot
+// is only used for the stats of the engine itself.
+func (engine *Engine) poll(decode bool) *Snapshot {
+	snap := &Snapshot{Capabilities: engine.Capabilities}
+
+	endpoints := []struct {
+		path     string
+		body     *json.RawMessage
+		optional bool
+	}{
+		{"/varz", &snap.Varz, false},
+		{"/connz", &snap.Connz, false},
+		{"/routez", &snap.Routez, false},
+		{"/leafz", &snap.Leafz, true},
+		{"/gatewayz", &snap.Gatewayz, true},
+		{"/jsz", &snap.Jsz, true},
+		{"/accountz", &snap.Accountz, true},
+	}
+	settings := engine.Settings()
+	for _, endpoint := range endpoints {
+		if endpoint.optional && !engine.hasEndpoint(endpoint.path) {
+			continue
+		}
+		// Streams and consumers are polled while displayed, or recorded for replays
+		if endpoint.path == "/jsz" && !settings.PollJetStream && engine.Recorder == nil {
+			continue
+		}
+		start := time.Now()
+		var err error
+		if endpoint.path == "/connz" && decode && settings.AllConns {
+			snap.connz, snap.connsExt, err = engine.fetchAllConnz(context.Background())
+		} else if endpoint.path == "/connz" && decode {
+			snap.connz, snap.connsExt, err = engine.fetchConnz(context.Background(), engine.connzOptions())
+		} else if endpoint.path == "/connz" && settings.AllConns {
+			*endpoint.body, err = engine.FetchAllConnz()
+		} else {
+			*endpoint.body, err = engine.Fetch(endpoint.path)
+		}
+		if err != nil && endpoint.optional {
+			snap.setError(endpoint.path, err)
+			continue
+		}
+		if err != nil {
+			snap.Error = err.Error()
+			snap.Time = time.Now()
+			return snap
+		}
+
+		if endpoint.path == "/connz" {
+			snap.ConnzTime = time.Since(start)
+			engine.observeConnzTime(snap.ConnzTime)
+		}
+	}
+
+	// Connections of the account being drilled into
+	if account := settings.Account; account != "" && engine.hasEndpoint("/accountz") {
+		var err error
+		if decode {
+			opts := engine.accountConnzOptions(account)
+			snap.accountConnz, snap.accountConnsExt, err = engine.fetchConnz(context.Background(), opts)
+		} else {
+			snap.AccountConnz, err = engine.FetchAccountConnz(account)
+		}
+		if err != nil {
+			snap.setError(AccountConnzPath, err)
+		}
+	}
+	snap.Time = time.Now()
+
+	return snap
+}
+
+// AccountConnzPath is the key in Errors of the connections
+// of the account being drilled into.
+const AccountConnzPath = "/connz?acc"
+
+func (snap *Snapshot) setError(path string, err error) {
+	if snap.Errors == nil {
+		snap.Errors = make(map[string]string)
+	}
+	snap.Errors[path] = err.Error()
+}
+
+// decode fills the stats with the responses from the snapshot,
+// returning the first error from either polling or decoding them.
+func (snap *Snapshot) decode(stats *Stats) error {
+	for path, err := range snap.Errors {
+		stats.setError(path, errors.New(err))
+	}
+	if snap.Error != "" {
+		return errors.New(snap.Error)
+	}
+
+	result, err := Decode("/varz", snap.Varz)
+	if err != nil {
+		return err
+	}
+	if varz, ok := result.(*gnatsd.Varz); ok {
+		stats.Varz = varz
+	}
+	stats.VarzExt = DecodeVarzExt(snap.Varz)
+
+	if snap.connz != nil {
+		stats.Connz, stats.ConnsExt = snap.connz, snap.connsExt
+	} else {
+		result, err = Decode("/connz", snap.Connz)
+		if err != nil {
+			return err
+		}
+		if connz, ok := result.(*gnatsd.Connz); ok {
+			stats.Connz = connz
+		}
+		stats.ConnsExt = DecodeConnsExt(snap.Connz)
+	}
+
+	result, err = Decode("/routez", snap.Routez)
+	if err != nil {
+		return err
+	}
+	if routez, ok := result.(*gnatsd.Routez); ok {
+		stats.Routez = routez
+	}
+
+	// Responses from the endpoints of newer servers,
+	// which are only included when supported.
+	optional := []struct {
+		path string
+		body json.RawMessage
+	}{
+		{"/leafz", snap.Leafz},
+		{"/gatewayz", snap.Gatewayz},
+		{"/jsz", snap.Jsz},
+		{"/accountz", snap.Accountz},
+	}
+	for _, endpoint := range optional {
+		if len(endpoint.body) == 0 {
+			continue
+		}
+		result, err := Decode(endpoint.path, endpoint.body)
+		if err != nil {
+			stats.setError(endpoint.path, err)
+			continue
+		}
+		switch statz := result.(type) {
+		case *Leafz:
+			stats.Leafz = statz
+		case *Gatewayz:
+			stats.Gatewayz = statz
+		case *JSInfo:
+			stats.Jsz = statz
+		case *Accountz:
+			stats.Accountz = statz
+		}
+	}
+
+	if snap.accountConnz != nil {
+		stats.AccountConnz, stats.AccountConnsExt = snap.accountConnz, snap.accountConnsExt
+	} else if len(snap.AccountConnz) > 0 {
+		result, err := Decode("/connz", snap.AccountConnz)
+		if err != nil {
+			stats.setError(AccountConnzPath, err)
+		} else if connz, ok := result.(*gnatsd.Connz); ok {
+			stats.AccountConnz = connz
+			stats.AccountConnsExt = DecodeConnsExt(snap.AccountConnz)
+		}
+	}
+
+	return nil
+}
+
+// SortConns sorts the connections the same way as the server does
+// for /connz, by ascending cid and otherwise descending values.
+func SortConns(conns []gnatsd.ConnInfo, by gnatsd.SortOpt) {
+	sort.Stable(connsByOpt{conns, by})
+}
+
+type connsByOpt struct {
+	conns []gnatsd.ConnInfo
+	by    gnatsd.SortOpt
+}
+
+func (c connsByOpt) Len() int {
+	return len(c.conns)
+}
+
+func (c connsByOpt) Swap(i, j int) {
+	c.conns[i], c.conns[j] = c.conns[j], c.conns[i]
+}
+
+func (c connsByOpt) Less(i, j int) bool {
+	a, b := c.conns[i], c.conns[j]
+	switch c.by {
+	case "subs":
+		return a.NumSubs > b.NumSubs
+	case "pending":
+		return a.Pending > b.Pending
+	case "msgs_to":
+		return a.OutMsgs > b.OutMsgs
+	case "msgs_from":
+		return a.InMsgs > b.InMsgs
+	case "bytes_to":
+		return a.OutBytes > b.OutBytes
+	case "bytes_from":
+		return a.InBytes > b.InBytes
+	case "last":
+		return a.LastActivity.After(b.LastActivity)
+	case "idle":
+		return a.LastActivity.Before(b.LastActivity)
+	case "uptime":
+		return a.Start.Before(b.Start)
+	default:
+		return a.Cid < b.Cid
+	}
+}
+
+// localView applies the connection settings of the engine to
+// stats from a snapshot which was polled by someone else.
+func (engine *Engine) localView(stats *Stats) {
+	settings := engine.Settings()
+	SortConns(stats.Connz.Conns, settings.SortOpt)
+
+	if account := settings.Account; account != "" {
+		conns := make([]gnatsd.ConnInfo, 0)
+		for _, conn := range stats.Connz.Conns {
+			if ext, ok := stats.ConnsExt[conn.Cid]; ok && ext.Account == account {
+				conns = append(conns, conn)
+			}
+		}
+		stats.AccountConnz = &gnatsd.Connz{Now: stats.Connz.Now, Total: len(conns), Conns: conns}
+		stats.AccountConnsExt = stats.ConnsExt
+		limitConns(stats.AccountConnz, settings.Conns)
+	}
+
+	// Snapshots from daemons have all the connections, which
+	// are kept as they are for the views which add them up.
+	if !settings.AllConns {
+		limitConns(stats.Connz, settings.Conns)
+	}
+}
+
+// limitConns keeps up to the maximum number of connections to poll.
+func limitConns(connz *gnatsd.Connz, limit int) {
+	if limit >= 0 && len(connz.Conns) > limit {
+		connz.Conns = connz.Conns[:limit]
+	}
+	connz.NumConns = len(connz.Conns)
+	connz.Limit = limit
+}
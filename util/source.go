@@ -0,0 +1,269 @@
+package toputils
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+// Source is where the engine gets the responses of the monitoring
+// endpoints from, which is the server itself unless polling from
+// a recording or from a fake one in tests.
+type Source interface {
+	// Fetch returns the raw response from one of the endpoints,
+	// e.g. /varz, /connz, /routez or /subsz, taking the options
+	// into account in case of /connz. The request is abandoned
+	// once the context is done.
+	Fetch(ctx context.Context, path string, opts *ConnzOptions) ([]byte, error)
+}
+
+// StreamSource is a source which can also return the responses as they
+// arrive, so that large ones can be decoded without keeping them whole.
+type StreamSource interface {
+	Source
+
+	// Open returns the response from one of the endpoints like
+	// Fetch does, which has to be closed once read.
+	Open(ctx context.Context, path string, opts *ConnzOptions) (io.ReadCloser, error)
+}
+
+// ConnzOptions are the options of a request to /connz.
+type ConnzOptions struct {
+	Limit   int
+	Offset  int
+	Sort    gnatsd.SortOpt
+	Subs    bool
+	Auth    bool
+	Account string
+}
+
+// HTTPSource gets the responses from the monitoring port of the server.
+type HTTPSource struct {
+	Client *http.Client
+	Uri    string
+}
+
+// Fetch takes a path and options, and returns the
+// response from the server without decoding it.
+func (s *HTTPSource) Fetch(ctx context.Context, path string, opts *ConnzOptions) ([]byte, error) {
+	resp, err := s.Open(ctx, path, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+
+	body, err := ioutil.ReadAll(resp)
+	if err != nil {
+		return nil, fmt.Errorf("could not read response body: %v\n", err)
+	}
+
+	return body, nil
+}
+
+// Open takes a path and options, and returns the response from the
+// server as it arrives. Responses are requested gzip compressed,
+// which makes the ones from large servers much smaller.
+func (s *HTTPSource) Open(ctx context.Context, path string, opts *ConnzOptions) (io.ReadCloser, error) {
+	if opts == nil {
+		opts = &ConnzOptions{}
+	}
+
+	uri := s.Uri + path
+	switch path {
+	case "/", "/varz", "/routez", "/subsz", "/stacksz", "/leafz", "/gatewayz", "/accountz":
+	case "/connz":
+		uri += fmt.Sprintf("?limit=%d&sort=%s", opts.Limit, opts.Sort)
+		if opts.Offset > 0 {
+			uri += fmt.Sprintf("&offset=%d", opts.Offset)
+		}
+		if opts.Subs {
+			uri += fmt.Sprintf("&subs=%d", DisplaySubscriptions)
+		}
+		if opts.Auth {
+			uri += fmt.Sprintf("&auth=%d", DisplayAuthDetails)
+		}
+		if opts.Account != "" {
+			uri += "&acc=" + url.QueryEscape(opts.Account)
+		}
+	case "/jsz":
+		uri += "?accounts=true&streams=true&consumers=true"
+	default:
+		return nil, fmt.Errorf("invalid path '%s' for stats server", path)
+	}
+
+	// Attached engines only get what the daemon polls
+	if s.Client == nil {
+		return nil, fmt.Errorf("not polling the server directly\n")
+	}
+
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not get stats from server: %v\n", err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := s.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, fmt.Errorf("could not get stats from server: %v\n", err)
+	}
+
+	// Servers are free to respond uncompressed anyway
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		return resp.Body, nil
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("could not read response body: %v\n", err)
+	}
+	return &gzipBody{Reader: gz, body: resp.Body}, nil
+}
+
+// gzipBody decompresses a response, closing it along with the reader.
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
+
+// RecordingSource gets the responses from the snapshots of a
+// recording made with -record, moving on to the next snapshot
+// every time /varz is requested since it is the first endpoint
+// being polled. The options are ignored since the responses are
+// as they were recorded, and io.EOF is returned at the end.
+type RecordingSource struct {
+	sync.Mutex
+	snaps []*Snapshot
+	pos   int
+}
+
+// NewRecordingSource returns a source for the snapshots.
+func NewRecordingSource(snaps []*Snapshot) *RecordingSource {
+	return &RecordingSource{snaps: snaps, pos: -1}
+}
+
+// Fetch returns the response for the path from the current snapshot.
+func (s *RecordingSource) Fetch(ctx context.Context, path string, opts *ConnzOptions) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if path == "/varz" {
+		s.pos++
+	}
+	if s.pos < 0 {
+		s.pos = 0
+	}
+	if s.pos >= len(s.snaps) {
+		return nil, io.EOF
+	}
+
+	snap := s.snaps[s.pos]
+	var body []byte
+	switch path {
+	case "/varz":
+		body = snap.Varz
+	case "/connz":
+		body = snap.Connz
+		if opts != nil && opts.Account != "" {
+			body = snap.AccountConnz
+		}
+	case "/routez":
+		body = snap.Routez
+	case "/leafz":
+		body = snap.Leafz
+	case "/gatewayz":
+		body = snap.Gatewayz
+	case "/jsz":
+		body = snap.Jsz
+	case "/accountz":
+		body = snap.Accountz
+	}
+	if snap.Error != "" && len(body) == 0 {
+		return nil, fmt.Errorf("%s", snap.Error)
+	}
+	if err, ok := snap.Errors[path]; ok && len(body) == 0 {
+		return nil, fmt.Errorf("%s", err)
+	}
+	if len(body) == 0 {
+		return nil, fmt.Errorf("no response for '%s' in recording\n", path)
+	}
+
+	return body, nil
+}
+
+// FakeSource responds with whatever was set for each
+// one of the paths, e.g. to test without a server.
+type FakeSource struct {
+	sync.Mutex
+	responses map[string][]byte
+	errors    map[string]error
+	requests  map[string]int
+}
+
+// NewFakeSource returns a source without any responses.
+func NewFakeSource() *FakeSource {
+	return &FakeSource{
+		responses: make(map[string][]byte),
+		errors:    make(map[string]error),
+		requests:  make(map[string]int),
+	}
+}
+
+// Set sets the response for the path.
+func (s *FakeSource) Set(path string, body []byte) {
+	s.Lock()
+	defer s.Unlock()
+	s.responses[path] = body
+	delete(s.errors, path)
+}
+
+// SetError makes requests for the path fail with the error.
+func (s *FakeSource) SetError(path string, err error) {
+	s.Lock()
+	defer s.Unlock()
+	s.errors[path] = err
+}
+
+// Requests returns the number of requests made for the path.
+func (s *FakeSource) Requests(path string) int {
+	s.Lock()
+	defer s.Unlock()
+	return s.requests[path]
+}
+
+// Fetch returns the response which was set for the path.
+func (s *FakeSource) Fetch(ctx context.Context, path string, opts *ConnzOptions) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.requests[path]++
+	if err, ok := s.errors[path]; ok {
+		return nil, err
+	}
+	body, ok := s.responses[path]
+	if !ok {
+		return nil, fmt.Errorf("could not get stats from server: no response for '%s'\n", path)
+	}
+	return body, nil
+}
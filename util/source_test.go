@@ -0,0 +1,164 @@
+package toputils
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+func TestHTTPSourceOptions(t *testing.T) {
+	var query string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+
+	source := &HTTPSource{Client: &http.Client{}, Uri: ts.URL}
+	opts := &ConnzOptions{Limit: 10, Offset: 20, Sort: "subs", Subs: true, Auth: true, Account: "$G"}
+	if _, err := source.Fetch(context.Background(), "/connz", opts); err != nil {
+		t.Fatalf("Failed fetching connz: %v", err)
+	}
+	expected := "limit=10&sort=subs&offset=20&subs=1&auth=1&acc=%24G"
+	if query != expected {
+		t.Fatalf("Wrong query for connz. expected: %v, got: %v", expected, query)
+	}
+
+	if _, err := source.Fetch(context.Background(), "/foo", nil); err == nil {
+		t.Fatalf("Expected error fetching invalid path")
+	}
+}
+
+func TestMonitorStatsFromFakeSource(t *testing.T) {
+	source := NewFakeSource()
+	for _, path := range []string{"/varz", "/connz", "/routez"} {
+		body, err := ioutil.ReadFile("test" + path + "_v2.json")
+		if err != nil {
+			t.Fatalf("Could not read fixture: %v", err)
+		}
+		source.Set(path, body)
+	}
+	source.Set("/subsz", []byte(`{"num_subscriptions":42,"max_fanout":3}`))
+
+	engine := NewEngine("", 0, 10, 1)
+	engine.Source = source
+
+	result, err := engine.Request("/subsz")
+	if err != nil {
+		t.Fatalf("Failed requesting subsz: %v", err)
+	}
+	if subsz, ok := result.(*gnatsd.Subsz); !ok || subsz.NumSubs != 42 || subsz.MaxFanout != 3 {
+		t.Fatalf("Wrong subsz from source. got: %+v", result)
+	}
+
+	go engine.MonitorStats()
+	defer close(engine.ShutdownCh)
+
+	select {
+	case stats := <-engine.StatsCh:
+		if stats.Error.Error() != "" {
+			t.Fatalf("Failed polling the fake source: %v", stats.Error)
+		}
+		if stats.Varz.Info == nil || stats.Varz.Info.Version != "2.10.4" || len(stats.Connz.Conns) != 2 {
+			t.Fatalf("Wrong stats from fake source. got: %+v", stats)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out waiting for stats")
+	}
+
+	// Errors are reported as from any other source
+	source.SetError("/connz", io.ErrUnexpectedEOF)
+	select {
+	case stats := <-engine.StatsCh:
+		if stats.Error.Error() != io.ErrUnexpectedEOF.Error() {
+			t.Fatalf("Expected error from fake source. got: %v", stats.Error)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out waiting for stats")
+	}
+	if source.Requests("/connz") != 2 {
+		t.Fatalf("Wrong number of requests. got: %d", source.Requests("/connz"))
+	}
+}
+
+func TestRecordingSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nats-top")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "session.ndjson")
+	recordFixture(t, path, false, 2)
+	snaps, err := ReadRecording(path)
+	if err != nil {
+		t.Fatalf("Failed reading recording: %v", err)
+	}
+
+	engine := NewEngine("", 0, 10, 1)
+	engine.Source = NewRecordingSource(snaps)
+	engine.PollJetStream = true
+	if err := engine.SetupCapabilities(); err != nil {
+		t.Fatalf("Failed detecting capabilities: %v", err)
+	}
+	if !engine.hasEndpoint("/jsz") {
+		t.Fatalf("Expected capabilities from the recorded version. got: %v", engine.Capabilities)
+	}
+
+	// Detecting the capabilities went through the first snapshot
+	snap := engine.Poll()
+	if snap.Error != "" || len(snap.Jsz) == 0 {
+		t.Fatalf("Wrong snapshot from recording. got: %+v", snap)
+	}
+	if snap = engine.Poll(); snap.Error != io.EOF.Error() {
+		t.Fatalf("Expected end of recording. got: %+v", snap)
+	}
+}
+
+func TestPollOptionalEndpointErrors(t *testing.T) {
+	engine := newFakeEngine(t)
+	source := engine.Source.(*FakeSource)
+	engine.Capabilities = DetectCapabilities("2.10.4", nil)
+	engine.PollJetStream = true
+	source.SetError("/leafz", io.ErrUnexpectedEOF)
+	source.Set("/gatewayz", []byte(`{"name":"A"}`))
+	source.Set("/jsz", []byte(`{"streams":`))
+	source.SetError("/accountz", io.ErrUnexpectedEOF)
+
+	// Failing side endpoints leave the rest of the stats usable
+	snap := engine.Poll()
+	if snap.Error != "" || len(snap.Varz) == 0 || len(snap.Connz) == 0 || len(snap.Routez) == 0 {
+		t.Fatalf("Expected snapshot despite the optional endpoints failing. got: %+v", snap)
+	}
+	if len(snap.Errors) != 2 || snap.Errors["/leafz"] == "" || snap.Errors["/accountz"] == "" {
+		t.Fatalf("Wrong errors of the optional endpoints. got: %v", snap.Errors)
+	}
+
+	stats := newStatsTracker(nil).update(snap)
+	if stats.Error.Error() != "" || len(stats.Connz.Conns) != 2 || stats.Gatewayz == nil {
+		t.Fatalf("Expected stats despite the optional endpoints failing. got: %v", stats.Error)
+	}
+	for _, path := range []string{"/leafz", "/jsz", "/accountz"} {
+		if err := stats.EndpointError(path); err == nil || err.Error() == "" {
+			t.Fatalf("Expected error for %s. got: %v", path, err)
+		}
+	}
+	if err := stats.EndpointError("/gatewayz"); err.Error() != "" {
+		t.Fatalf("Expected no error for /gatewayz. got: %v", err)
+	}
+
+	// Errors of the stats as a whole are shown in every view
+	source.SetError("/connz", io.ErrUnexpectedEOF)
+	stats = newStatsTracker(nil).update(engine.Poll())
+	if err := stats.EndpointError("/gatewayz"); err.Error() == "" {
+		t.Fatalf("Expected error polling /connz")
+	}
+}
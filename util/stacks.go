@@ -0,0 +1,172 @@
+package toputils
+
+import (
+	"bufio"
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// StackFrame is a function call from a goroutine stack.
+type StackFrame struct {
+	Func string
+	File string
+}
+
+// StackGroup represents a set of goroutines with identical stacks.
+type StackGroup struct {
+	Key    string
+	State  string
+	Count  int
+	IDs    []int
+	Frames []StackFrame
+}
+
+// TopFunc returns the function at the top of the stack.
+func (g *StackGroup) TopFunc() string {
+	if len(g.Frames) == 0 {
+		return ""
+	}
+	return g.Frames[0].Func
+}
+
+// HasFunc returns whether any of the functions in the stack
+// contains the query, ignoring case.
+func (g *StackGroup) HasFunc(query string) bool {
+	query = strings.ToLower(query)
+	for _, frame := range g.Frames {
+		if strings.Contains(strings.ToLower(frame.Func), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseStacks takes the goroutines dump from /stacksz and groups
+// the goroutines which have the same state and stack, ignoring the
+// arguments and program counter offsets. Groups are sorted by the
+// number of goroutines in descending order.
+func ParseStacks(data []byte) []*StackGroup {
+	groups := make([]*StackGroup, 0)
+	index := make(map[string]*StackGroup)
+
+	var current *StackGroup
+	var id int
+	flush := func() {
+		if current == nil {
+			return
+		}
+		var key bytes.Buffer
+		key.WriteString(current.State)
+		for _, frame := range current.Frames {
+			key.WriteString("\n")
+			key.WriteString(frame.Func)
+			key.WriteString(" ")
+			key.WriteString(frame.File)
+		}
+		current.Key = key.String()
+
+		if group, ok := index[current.Key]; ok {
+			group.Count++
+			group.IDs = append(group.IDs, id)
+		} else {
+			current.Count = 1
+			current.IDs = []int{id}
+			index[current.Key] = current
+			groups = append(groups, current)
+		}
+		current = nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "goroutine "):
+			flush()
+			id, current = parseStackHeader(line)
+		case current == nil || strings.TrimSpace(line) == "":
+			continue
+		case strings.HasPrefix(line, "\t"):
+			// File and line of the previous function call
+			if n := len(current.Frames); n > 0 {
+				file := strings.TrimSpace(line)
+				if i := strings.LastIndex(file, " +0x"); i > 0 {
+					file = file[:i]
+				}
+				current.Frames[n-1].File = file
+			}
+		default:
+			current.Frames = append(current.Frames, StackFrame{Func: parseStackFunc(line)})
+		}
+	}
+	flush()
+
+	sort.Stable(stackGroupsByCount(groups))
+	return groups
+}
+
+// FilterStacks returns the groups which have a function in
+// their stack that contains the query.
+func FilterStacks(groups []*StackGroup, query string) []*StackGroup {
+	if query == "" {
+		return groups
+	}
+	filtered := make([]*StackGroup, 0)
+	for _, group := range groups {
+		if group.HasFunc(query) {
+			filtered = append(filtered, group)
+		}
+	}
+	return filtered
+}
+
+// parseStackHeader parses lines like 'goroutine 7 [IO wait, 5 minutes]:'
+// leaving out how long the goroutine has been waiting from the state.
+func parseStackHeader(line string) (int, *StackGroup) {
+	group := &StackGroup{}
+	fields := strings.SplitN(strings.TrimPrefix(line, "goroutine "), " ", 2)
+	id, _ := strconv.Atoi(fields[0])
+	if len(fields) > 1 {
+		state := strings.TrimSuffix(strings.TrimSpace(fields[1]), ":")
+		state = strings.TrimSuffix(strings.TrimPrefix(state, "["), "]")
+		if i := strings.Index(state, ","); i > 0 {
+			state = state[:i]
+		}
+		group.State = state
+	}
+	return id, group
+}
+
+// parseStackFunc removes the arguments from a function call, as well
+// as the goroutine that created it in case of 'created by' lines.
+func parseStackFunc(line string) string {
+	if strings.HasPrefix(line, "created by ") {
+		if i := strings.Index(line, " in goroutine "); i > 0 {
+			line = line[:i]
+		}
+		return line
+	}
+	if strings.HasSuffix(line, ")") {
+		if i := strings.LastIndex(line, "("); i > 0 {
+			line = line[:i]
+		}
+	}
+	return line
+}
+
+type stackGroupsByCount []*StackGroup
+
+func (s stackGroupsByCount) Len() int {
+	return len(s)
+}
+
+func (s stackGroupsByCount) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s stackGroupsByCount) Less(i, j int) bool {
+	return s[i].Count > s[j].Count
+}
@@ -0,0 +1,104 @@
+package toputils
+
+import (
+	"testing"
+
+	"github.com/nats-io/gnatsd/server"
+)
+
+const testStacks = `goroutine 74 [running]:
+github.com/nats-io/gnatsd/server.(*Server).HandleStacksz(0x409655, 0xb49520, 0xc420138690, 0xc4201e9b80)
+	/go/src/github.com/nats-io/gnatsd/server/monitor.go:366 +0x68
+net/http.HandlerFunc.ServeHTTP(0xc4201a4200, 0xb49520, 0xc420138690, 0x6d377a)
+	/usr/local/go/src/net/http/server.go:1726 +0x44
+created by net/http.(*Server).Serve in goroutine 8
+	/usr/local/go/src/net/http/server.go:2293 +0x44d
+
+goroutine 10 [IO wait, 5 minutes]:
+net.runtime_pollWait(0x7f417fa7e800, 0x72, 0x7)
+	/usr/local/go/src/runtime/netpoll.go:160 +0x59
+github.com/nats-io/gnatsd/server.(*client).readLoop(0xc4200a0000)
+	/go/src/github.com/nats-io/gnatsd/server/client.go:183 +0x1c3
+created by github.com/nats-io/gnatsd/server.(*Server).createClient
+	/go/src/github.com/nats-io/gnatsd/server/server.go:588 +0x5b1
+
+goroutine 11 [IO wait]:
+net.runtime_pollWait(0x7f417fa7e6c0, 0x72, 0x8)
+	/usr/local/go/src/runtime/netpoll.go:160 +0x59
+github.com/nats-io/gnatsd/server.(*client).readLoop(0xc4200a0200)
+	/go/src/github.com/nats-io/gnatsd/server/client.go:183 +0x1c3
+created by github.com/nats-io/gnatsd/server.(*Server).createClient
+	/go/src/github.com/nats-io/gnatsd/server/server.go:588 +0x5b1
+
+goroutine 1 [chan receive]:
+main.main()
+	/go/src/github.com/nats-io/gnatsd/main.go:144 +0x9a6
+`
+
+func TestParseStacks(t *testing.T) {
+	groups := ParseStacks([]byte(testStacks))
+	if len(groups) != 3 {
+		t.Fatalf("Wrong number of groups. expected: %v, got: %v", 3, len(groups))
+	}
+
+	// Identical stacks are grouped regardless of args and wait time
+	readLoop := groups[0]
+	if readLoop.Count != 2 {
+		t.Fatalf("Wrong number of goroutines in group. expected: %v, got: %v", 2, readLoop.Count)
+	}
+	if readLoop.IDs[0] != 10 || readLoop.IDs[1] != 11 {
+		t.Fatalf("Wrong goroutines in group. expected: %v, got: %v", []int{10, 11}, readLoop.IDs)
+	}
+	if readLoop.State != "IO wait" {
+		t.Fatalf("Wrong state. expected: %v, got: %v", "IO wait", readLoop.State)
+	}
+	if len(readLoop.Frames) != 3 {
+		t.Fatalf("Wrong number of frames. expected: %v, got: %v", 3, len(readLoop.Frames))
+	}
+	expected := "net.runtime_pollWait"
+	if readLoop.TopFunc() != expected {
+		t.Fatalf("Wrong top function. expected: %v, got: %v", expected, readLoop.TopFunc())
+	}
+	expected = "/go/src/github.com/nats-io/gnatsd/server/client.go:183"
+	if readLoop.Frames[1].File != expected {
+		t.Fatalf("Wrong file. expected: %v, got: %v", expected, readLoop.Frames[1].File)
+	}
+
+	http := groups[1]
+	expected = "created by net/http.(*Server).Serve"
+	if got := http.Frames[len(http.Frames)-1].Func; got != expected {
+		t.Fatalf("Wrong creator function. expected: %v, got: %v", expected, got)
+	}
+
+	filtered := FilterStacks(groups, "readloop")
+	if len(filtered) != 1 || filtered[0] != readLoop {
+		t.Fatalf("Wrong stacks after filtering by function. got: %v", filtered)
+	}
+	filtered = FilterStacks(groups, "main.main")
+	if len(filtered) != 1 || filtered[0].State != "chan receive" {
+		t.Fatalf("Wrong stacks after filtering by function. got: %v", filtered)
+	}
+	if len(FilterStacks(groups, "")) != 3 {
+		t.Fatalf("Expected all stacks when there is no filter")
+	}
+}
+
+func TestFetchingStacksz(t *testing.T) {
+	s := runMonitorServer(server.DEFAULT_HTTP_PORT)
+	defer s.Shutdown()
+
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+	engine.SetupHTTP()
+
+	result, err := engine.Request("/stacksz")
+	if err != nil {
+		t.Fatalf("Failed getting /stacksz: %v", err)
+	}
+	data, ok := result.([]byte)
+	if !ok {
+		t.Fatalf("Wrong type for /stacksz. got: %T", result)
+	}
+	if len(ParseStacks(data)) == 0 {
+		t.Fatalf("Expected goroutine stacks from the server")
+	}
+}
@@ -0,0 +1,101 @@
+package toputils
+
+// ColumnWidths keeps the widest value of each column of a table whose
+// rows mostly stay the same between polls, e.g. the connections, so
+// that only the rows which are new, changed or gone are accounted for
+// rather than measuring all of them again to find the widest ones.
+type ColumnWidths struct {
+	rows   map[uint64]*columnWidthsRow
+	counts []map[int]int
+	widest []int
+	gen    uint64
+}
+
+type columnWidthsRow struct {
+	widths []int
+	gen    uint64
+}
+
+// NewColumnWidths returns the widths of a table with the number of columns.
+func NewColumnWidths(columns int) *ColumnWidths {
+	w := &ColumnWidths{
+		rows:   make(map[uint64]*columnWidthsRow),
+		counts: make([]map[int]int, columns),
+		widest: make([]int, columns),
+		gen:    1,
+	}
+	for i := range w.counts {
+		w.counts[i] = make(map[int]int)
+	}
+	return w
+}
+
+// Set sets the widths of the values of the row with the key,
+// which are copied so that the slice can be reused by callers.
+func (w *ColumnWidths) Set(key uint64, widths []int) {
+	row, ok := w.rows[key]
+	if !ok {
+		row = &columnWidthsRow{widths: make([]int, len(w.widest))}
+		w.rows[key] = row
+		w.add(row.widths)
+	}
+	row.gen = w.gen
+
+	changed := false
+	for i, width := range widths {
+		if row.widths[i] != width {
+			changed = true
+			break
+		}
+	}
+	if changed {
+		w.remove(row.widths)
+		copy(row.widths, widths)
+		w.add(row.widths)
+	}
+}
+
+// Sweep forgets the rows which were not set since the previous sweep.
+func (w *ColumnWidths) Sweep() {
+	for key, row := range w.rows {
+		if row.gen != w.gen {
+			w.remove(row.widths)
+			delete(w.rows, key)
+		}
+	}
+	w.gen++
+}
+
+// Width returns the width of the widest value of the column.
+func (w *ColumnWidths) Width(column int) int {
+	return w.widest[column]
+}
+
+func (w *ColumnWidths) add(widths []int) {
+	for i, width := range widths {
+		w.counts[i][width]++
+		if width > w.widest[i] {
+			w.widest[i] = width
+		}
+	}
+}
+
+func (w *ColumnWidths) remove(widths []int) {
+	for i, width := range widths {
+		w.counts[i][width]--
+		if w.counts[i][width] > 0 {
+			continue
+		}
+		delete(w.counts[i], width)
+
+		// Only the distinct widths are looked at
+		if width == w.widest[i] {
+			w.widest[i] = 0
+			for other := range w.counts[i] {
+				if other > w.widest[i] {
+					w.widest[i] = other
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package toputils
+
+import (
+	"testing"
+)
+
+func TestColumnWidths(t *testing.T) {
+	w := NewColumnWidths(2)
+	w.Set(1, []int{5, 1})
+	w.Set(2, []int{10, 2})
+	w.Set(3, []int{10, 3})
+	w.Sweep()
+	if w.Width(0) != 10 || w.Width(1) != 3 {
+		t.Fatalf("Wrong widths. got: %d, %d", w.Width(0), w.Width(1))
+	}
+
+	// Widest values are kept while another row is as wide
+	w.Set(1, []int{5, 1})
+	w.Set(2, []int{4, 2})
+	w.Set(3, []int{10, 1})
+	w.Sweep()
+	if w.Width(0) != 10 || w.Width(1) != 2 {
+		t.Fatalf("Wrong widths after changes. got: %d, %d", w.Width(0), w.Width(1))
+	}
+
+	// Rows not set since the previous sweep are gone
+	w.Set(1, []int{5, 1})
+	w.Sweep()
+	if w.Width(0) != 5 || w.Width(1) != 1 {
+		t.Fatalf("Wrong widths after removing rows. got: %d, %d", w.Width(0), w.Width(1))
+	}
+
+	// Widths are copied so that the slice can be reused
+	widths := []int{7, 7}
+	w.Set(2, widths)
+	widths[0], widths[1] = 0, 0
+	w.Set(3, widths)
+	w.Sweep()
+	if w.Width(0) != 7 || w.Width(1) != 7 {
+		t.Fatalf("Wrong widths reusing the slice. got: %d, %d", w.Width(0), w.Width(1))
+	}
+
+	w.Sweep()
+	if w.Width(0) != 0 || w.Width(1) != 0 {
+		t.Fatalf("Expected no widths without rows. got: %d, %d", w.Width(0), w.Width(1))
+	}
+}
+
+func BenchmarkColumnWidths(b *testing.B) {
+	const rows = 100000
+	w := NewColumnWidths(4)
+	widths := make([]int, 4)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Most of the rows stay the same between polls
+		for key := uint64(0); key < rows; key++ {
+			widths[0] = 10 + int(key%7)
+			widths[1] = int(key % 13)
+			widths[2] = 8
+			if key%100 == uint64(i%100) {
+				widths[3] = i % 20
+			} else {
+				widths[3] = 4
+			}
+			w.Set(key, widths)
+		}
+		w.Sweep()
+	}
+}
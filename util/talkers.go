@@ -0,0 +1,242 @@
+package toputils
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+// TalkersWindows are the sliding windows which can be used
+// to rank the connections by their recent traffic.
+var TalkersWindows = []time.Duration{10 * time.Second, time.Minute, 5 * time.Minute}
+
+// TalkersSortOpt is the value by which top talkers are ranked.
+type TalkersSortOpt string
+
+const (
+	TalkersSortByOutMsgs  TalkersSortOpt = "msgs_to"
+	TalkersSortByInMsgs   TalkersSortOpt = "msgs_from"
+	TalkersSortByOutBytes TalkersSortOpt = "bytes_to"
+	TalkersSortByInBytes  TalkersSortOpt = "bytes_from"
+)
+
+// IsValid determines if a top talkers sort option is valid.
+func (s TalkersSortOpt) IsValid() bool {
+	switch s {
+	case TalkersSortByOutMsgs, TalkersSortByInMsgs, TalkersSortByOutBytes, TalkersSortByInBytes:
+		return true
+	default:
+		return false
+	}
+}
+
+// Talker represents the traffic of a connection over a window.
+type Talker struct {
+	Conn gnatsd.ConnInfo
+
+	// Span is the period actually covered by the samples,
+	// which is shorter than the window after startup.
+	Span     time.Duration
+	InMsgs   int64
+	OutMsgs  int64
+	InBytes  int64
+	OutBytes int64
+	Rates    *ConnRates
+}
+
+// DefaultMaxConnSamples is the number of samples kept by default
+// for each connection over the max age of the history.
+const DefaultMaxConnSamples = 100
+
+type connSample struct {
+	Time     time.Time
+	InMsgs   int64
+	OutMsgs  int64
+	InBytes  int64
+	OutBytes int64
+}
+
+// ConnHistory keeps the samples of the counters from each polled
+// connection for up to MaxAge, so that the traffic over a sliding
+// window can be calculated. Up to MaxSamples are kept for each
+// connection, which are then at least MaxAge/MaxSamples apart.
+type ConnHistory struct {
+	sync.Mutex
+	MaxAge     time.Duration
+	MaxSamples int
+	samples    map[uint64][]connSample
+	conns      map[uint64]gnatsd.ConnInfo
+	disabled   bool
+}
+
+// NewConnHistory returns a history which keeps samples for maxAge.
+func NewConnHistory(maxAge time.Duration) *ConnHistory {
+	return &ConnHistory{
+		MaxAge:     maxAge,
+		MaxSamples: DefaultMaxConnSamples,
+		samples:    make(map[uint64][]connSample),
+		conns:      make(map[uint64]gnatsd.ConnInfo),
+	}
+}
+
+// SetEnabled starts or stops adding samples, e.g. only while the top
+// talkers are displayed, forgetting the ones kept so far when stopped.
+func (h *ConnHistory) SetEnabled(enabled bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.disabled = !enabled
+	if h.disabled {
+		h.samples = make(map[uint64][]connSample)
+		h.conns = make(map[uint64]gnatsd.ConnInfo)
+	}
+}
+
+// Reset forgets all the samples, e.g. when jumping
+// to another point of a replay.
+func (h *ConnHistory) Reset() {
+	h.Lock()
+	defer h.Unlock()
+
+	h.samples = make(map[uint64][]connSample)
+	h.conns = make(map[uint64]gnatsd.ConnInfo)
+}
+
+// Add records a sample for each one of the polled connections and
+// discards the ones which are older than the max age of the history.
+func (h *ConnHistory) Add(now time.Time, conns []gnatsd.ConnInfo) {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.disabled {
+		return
+	}
+
+	var gap time.Duration
+	if h.MaxSamples > 0 {
+		gap = h.MaxAge / time.Duration(h.MaxSamples)
+	}
+
+	h.conns = make(map[uint64]gnatsd.ConnInfo, len(conns))
+	for _, conn := range conns {
+		h.conns[conn.Cid] = conn
+		sample := connSample{
+			Time:     now,
+			InMsgs:   conn.InMsgs,
+			OutMsgs:  conn.OutMsgs,
+			InBytes:  conn.InBytes,
+			OutBytes: conn.OutBytes,
+		}
+
+		// The latest sample replaces the previous one while
+		// too close to the one before, to bound the samples
+		samples := h.samples[conn.Cid]
+		if n := len(samples); n >= 2 && sample.Time.Sub(samples[n-2].Time) < gap {
+			samples[n-1] = sample
+			continue
+		}
+		h.samples[conn.Cid] = append(samples, sample)
+	}
+
+	// Keep the latest sample before the cutoff, so that
+	// a window as large as max age is fully covered.
+	cutoff := now.Add(-h.MaxAge)
+	for cid, samples := range h.samples {
+		i := 0
+		for i < len(samples)-1 && !samples[i+1].Time.After(cutoff) {
+			i++
+		}
+		samples = samples[i:]
+
+		if len(samples) == 1 && samples[0].Time.Before(cutoff) {
+			delete(h.samples, cid)
+			continue
+		}
+		h.samples[cid] = samples
+	}
+}
+
+// TopTalkers returns the connections from the latest sample ranked
+// by their traffic over the window, limited to n unless it is zero.
+func (h *ConnHistory) TopTalkers(window time.Duration, by TalkersSortOpt, n int) []*Talker {
+	h.Lock()
+	defer h.Unlock()
+
+	talkers := make([]*Talker, 0, len(h.conns))
+	for cid, conn := range h.conns {
+		samples := h.samples[cid]
+		if len(samples) == 0 {
+			continue
+		}
+		last := samples[len(samples)-1]
+
+		// Use as base the latest sample within the window boundary,
+		// or the oldest one in case there is not enough history.
+		base := samples[0]
+		start := last.Time.Add(-window)
+		for _, sample := range samples {
+			if sample.Time.After(start) {
+				break
+			}
+			base = sample
+		}
+
+		talker := &Talker{
+			Conn:     conn,
+			Span:     last.Time.Sub(base.Time),
+			InMsgs:   last.InMsgs - base.InMsgs,
+			OutMsgs:  last.OutMsgs - base.OutMsgs,
+			InBytes:  last.InBytes - base.InBytes,
+			OutBytes: last.OutBytes - base.OutBytes,
+			Rates:    &ConnRates{},
+		}
+		if secs := talker.Span.Seconds(); secs > 0 {
+			talker.Rates.InMsgsRate = float64(talker.InMsgs) / secs
+			talker.Rates.OutMsgsRate = float64(talker.OutMsgs) / secs
+			talker.Rates.InBytesRate = float64(talker.InBytes) / secs
+			talker.Rates.OutBytesRate = float64(talker.OutBytes) / secs
+		}
+		talkers = append(talkers, talker)
+	}
+
+	sort.Sort(talkersByOpt{talkers, by})
+	if n > 0 && len(talkers) > n {
+		talkers = talkers[:n]
+	}
+
+	return talkers
+}
+
+type talkersByOpt struct {
+	talkers []*Talker
+	by      TalkersSortOpt
+}
+
+func (t talkersByOpt) Len() int {
+	return len(t.talkers)
+}
+
+func (t talkersByOpt) Swap(i, j int) {
+	t.talkers[i], t.talkers[j] = t.talkers[j], t.talkers[i]
+}
+
+func (t talkersByOpt) Less(i, j int) bool {
+	a, b := t.talkers[i], t.talkers[j]
+	var x, y int64
+	switch t.by {
+	case TalkersSortByOutMsgs:
+		x, y = a.OutMsgs, b.OutMsgs
+	case TalkersSortByInMsgs:
+		x, y = a.InMsgs, b.InMsgs
+	case TalkersSortByInBytes:
+		x, y = a.InBytes, b.InBytes
+	default:
+		x, y = a.OutBytes, b.OutBytes
+	}
+	if x == y {
+		return a.Conn.Cid < b.Conn.Cid
+	}
+	return x > y
+}
@@ -0,0 +1,167 @@
+package toputils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/gnatsd/server"
+)
+
+func TestTopTalkers(t *testing.T) {
+	history := NewConnHistory(time.Minute)
+	start := time.Now()
+
+	// Connection 1 has a lot of traffic since long ago but it is
+	// idle now, whereas connection 2 only started to publish lately.
+	for i := 0; i <= 60; i++ {
+		var out1, out2 int64
+		if i > 50 {
+			out2 = int64(i-50) * 1000
+		}
+		if i < 30 {
+			out1 = int64(i) * 10000
+		} else {
+			out1 = 300000
+		}
+		history.Add(start.Add(time.Duration(i)*time.Second), []server.ConnInfo{
+			{Cid: 1, OutBytes: 1000000 + out1},
+			{Cid: 2, OutBytes: out2},
+		})
+	}
+
+	talkers := history.TopTalkers(10*time.Second, TalkersSortByOutBytes, 0)
+	if len(talkers) != 2 {
+		t.Fatalf("Wrong number of talkers. expected: %v, got: %v", 2, len(talkers))
+	}
+	if talkers[0].Conn.Cid != 2 {
+		t.Fatalf("Wrong top talker over 10s. expected: %v, got: %v", 2, talkers[0].Conn.Cid)
+	}
+	if talkers[0].OutBytes != 10000 {
+		t.Fatalf("Wrong bytes over 10s. expected: %v, got: %v", 10000, talkers[0].OutBytes)
+	}
+	if talkers[0].Span != 10*time.Second {
+		t.Fatalf("Wrong span. expected: %v, got: %v", 10*time.Second, talkers[0].Span)
+	}
+	if talkers[0].Rates.OutBytesRate != 1000 {
+		t.Fatalf("Wrong rate. expected: %v, got: %v", 1000, talkers[0].Rates.OutBytesRate)
+	}
+
+	talkers = history.TopTalkers(time.Minute, TalkersSortByOutBytes, 1)
+	if len(talkers) != 1 {
+		t.Fatalf("Wrong number of talkers. expected: %v, got: %v", 1, len(talkers))
+	}
+	if talkers[0].Conn.Cid != 1 || talkers[0].OutBytes != 300000 {
+		t.Fatalf("Wrong top talker over 1m. expected: %v with %v, got: %v with %v",
+			1, 300000, talkers[0].Conn.Cid, talkers[0].OutBytes)
+	}
+
+	// Only connections from the latest poll are ranked, and
+	// their samples are gone once older than max age.
+	history.Add(start.Add(61*time.Second), []server.ConnInfo{{Cid: 2, OutBytes: 11000}})
+	talkers = history.TopTalkers(time.Minute, TalkersSortByOutBytes, 0)
+	if len(talkers) != 1 || talkers[0].Conn.Cid != 2 {
+		t.Fatalf("Expected only connection 2 to be ranked, got: %+v", talkers)
+	}
+	history.Add(start.Add(200*time.Second), []server.ConnInfo{{Cid: 2, OutBytes: 11000}})
+	history.Lock()
+	_, ok := history.samples[1]
+	history.Unlock()
+	if ok {
+		t.Fatalf("Expected samples from connection 1 to be discarded")
+	}
+}
+
+func TestConnHistoryBounded(t *testing.T) {
+	history := NewConnHistory(time.Minute)
+	history.MaxSamples = 10
+	start := time.Now()
+
+	// Samples every 100ms are thinned out to one every 6s
+	for i := 0; i <= 1200; i++ {
+		history.Add(start.Add(time.Duration(i)*100*time.Millisecond), []server.ConnInfo{
+			{Cid: 1, OutBytes: int64(i) * 100},
+		})
+	}
+	if n := len(history.samples[1]); n > history.MaxSamples+2 {
+		t.Fatalf("Expected up to %d samples. got: %d", history.MaxSamples+2, n)
+	}
+	talkers := history.TopTalkers(time.Minute, TalkersSortByOutBytes, 0)
+	if len(talkers) != 1 || talkers[0].Span < time.Minute || talkers[0].Rates.OutBytesRate != 1000 {
+		t.Fatalf("Wrong talker from thinned out samples. got: %+v, %+v", talkers[0], talkers[0].Rates)
+	}
+
+	// Nothing is kept while disabled
+	history.SetEnabled(false)
+	history.Add(start.Add(2*time.Minute), []server.ConnInfo{{Cid: 1}, {Cid: 2}})
+	if len(history.samples) != 0 || len(history.TopTalkers(time.Minute, TalkersSortByOutBytes, 0)) != 0 {
+		t.Fatalf("Expected no samples while disabled. got: %v", history.samples)
+	}
+	history.SetEnabled(true)
+	history.Add(start.Add(2*time.Minute), []server.ConnInfo{{Cid: 2}})
+	if len(history.samples) != 1 {
+		t.Fatalf("Expected samples once enabled again. got: %v", history.samples)
+	}
+
+	// Engines only keep samples once the top talkers are displayed
+	engine := NewEngine("", 0, 10, 1)
+	engine.History.Add(start, []server.ConnInfo{{Cid: 1}})
+	if len(engine.History.samples) != 0 {
+		t.Fatalf("Expected no samples until enabled. got: %v", engine.History.samples)
+	}
+}
+
+func TestTopTalkersAllConns(t *testing.T) {
+	const total = 2000
+	var polls int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/connz" {
+			w.Write([]byte("{}"))
+			return
+		}
+		q := r.URL.Query()
+		offset, _ := strconv.Atoi(q.Get("offset"))
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		if offset == 0 {
+			atomic.AddInt64(&polls, 1)
+		}
+
+		// Connection with the last cid floods the server lately
+		connz := &server.Connz{Total: total, Offset: offset, Limit: limit}
+		for cid := offset + 1; cid <= total && cid <= offset+limit; cid++ {
+			conn := server.ConnInfo{Cid: uint64(cid), OutMsgs: 1000}
+			if cid == total {
+				conn.OutMsgs = atomic.LoadInt64(&polls) * 100
+			}
+			connz.Conns = append(connz.Conns, conn)
+		}
+		connz.NumConns = len(connz.Conns)
+		json.NewEncoder(w).Encode(connz)
+	}))
+	defer ts.Close()
+
+	engine := NewEngine("", 0, 10, 0)
+	engine.HttpClient = &http.Client{}
+	engine.Uri = ts.URL
+	engine.History.SetEnabled(true)
+	engine.UpdateSettings(func(s *Settings) { s.AllConns = true })
+
+	go engine.MonitorStats()
+	defer close(engine.ShutdownCh)
+	for i := 0; i < 3; i++ {
+		select {
+		case <-engine.StatsCh:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("Timed out waiting for stats")
+		}
+	}
+
+	talkers := engine.History.TopTalkers(time.Minute, TalkersSortByOutMsgs, 1)
+	if len(talkers) != 1 || talkers[0].Conn.Cid != total || talkers[0].OutMsgs == 0 {
+		t.Fatalf("Expected the connection beyond the polled ones to be found. got: %+v", talkers)
+	}
+}
@@ -0,0 +1,207 @@
+package toputils
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Leafz represents the leafnode connections from /leafz,
+// which is only available in newer versions of the server.
+type Leafz struct {
+	ID       string      `json:"server_id"`
+	Now      time.Time   `json:"now"`
+	NumLeafs int         `json:"leafnodes"`
+	Leafs    []*LeafInfo `json:"leafs"`
+}
+
+// LeafInfo has the details of a single leafnode connection.
+type LeafInfo struct {
+	Name     string `json:"name,omitempty"`
+	IsSpoke  bool   `json:"is_spoke,omitempty"`
+	Account  string `json:"account"`
+	IP       string `json:"ip"`
+	Port     int    `json:"port"`
+	RTT      string `json:"rtt,omitempty"`
+	InMsgs   int64  `json:"in_msgs"`
+	OutMsgs  int64  `json:"out_msgs"`
+	InBytes  int64  `json:"in_bytes"`
+	OutBytes int64  `json:"out_bytes"`
+	NumSubs  uint32 `json:"subscriptions"`
+}
+
+// Key identifies a leafnode connection across polls.
+func (l *LeafInfo) Key() string {
+	return fmt.Sprintf("%s:%d", l.IP, l.Port)
+}
+
+// Gatewayz represents the gateways from /gatewayz, which
+// is only available in newer versions of the server.
+type Gatewayz struct {
+	ID               string                       `json:"server_id"`
+	Now              time.Time                    `json:"now"`
+	Name             string                       `json:"name,omitempty"`
+	Host             string                       `json:"host,omitempty"`
+	Port             int                          `json:"port,omitempty"`
+	OutboundGateways map[string]*RemoteGatewayz   `json:"outbound_gateways"`
+	InboundGateways  map[string][]*RemoteGatewayz `json:"inbound_gateways"`
+}
+
+// RemoteGatewayz is a connection to or from a remote gateway.
+type RemoteGatewayz struct {
+	IsConfigured bool             `json:"configured"`
+	Connection   *GatewayConnInfo `json:"connection,omitempty"`
+}
+
+// GatewayConnInfo has the details of a gateway connection.
+type GatewayConnInfo struct {
+	Cid      uint64 `json:"cid"`
+	IP       string `json:"ip"`
+	Port     int    `json:"port"`
+	RTT      string `json:"rtt,omitempty"`
+	Uptime   string `json:"uptime"`
+	Idle     string `json:"idle"`
+	Pending  int    `json:"pending_bytes"`
+	InMsgs   int64  `json:"in_msgs"`
+	OutMsgs  int64  `json:"out_msgs"`
+	InBytes  int64  `json:"in_bytes"`
+	OutBytes int64  `json:"out_bytes"`
+	NumSubs  uint32 `json:"subscriptions"`
+	Name     string `json:"name,omitempty"`
+}
+
+// GatewayDirection is whether a gateway connection
+// was created by this server or by the remote one.
+type GatewayDirection string
+
+const (
+	GatewayOutbound GatewayDirection = "outbound"
+	GatewayInbound  GatewayDirection = "inbound"
+)
+
+// GatewayPeer is a connection to a remote gateway
+// along with the name of the remote cluster.
+type GatewayPeer struct {
+	Name         string
+	Direction    GatewayDirection
+	IsConfigured bool
+	Conn         *GatewayConnInfo
+}
+
+// Key identifies a gateway connection across polls.
+func (p *GatewayPeer) Key() string {
+	return fmt.Sprintf("%s/%s/%d", p.Direction, p.Name, p.Conn.Cid)
+}
+
+// GatewayPeers returns all the outbound and inbound gateway
+// connections, sorted by the name of the remote gateway.
+func GatewayPeers(gatewayz *Gatewayz) []*GatewayPeer {
+	peers := make([]*GatewayPeer, 0)
+	if gatewayz == nil {
+		return peers
+	}
+	for name, remote := range gatewayz.OutboundGateways {
+		if remote == nil || remote.Connection == nil {
+			continue
+		}
+		peers = append(peers, &GatewayPeer{
+			Name:         name,
+			Direction:    GatewayOutbound,
+			IsConfigured: remote.IsConfigured,
+			Conn:         remote.Connection,
+		})
+	}
+	for name, remotes := range gatewayz.InboundGateways {
+		for _, remote := range remotes {
+			if remote == nil || remote.Connection == nil {
+				continue
+			}
+			peers = append(peers, &GatewayPeer{
+				Name:         name,
+				Direction:    GatewayInbound,
+				IsConfigured: remote.IsConfigured,
+				Conn:         remote.Connection,
+			})
+		}
+	}
+	sort.Sort(gatewayPeersByName(peers))
+	return peers
+}
+
+type gatewayPeersByName []*GatewayPeer
+
+func (s gatewayPeersByName) Len() int {
+	return len(s)
+}
+
+func (s gatewayPeersByName) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s gatewayPeersByName) Less(i, j int) bool {
+	if s[i].Name != s[j].Name {
+		return s[i].Name < s[j].Name
+	}
+	if s[i].Direction != s[j].Direction {
+		return s[i].Direction == GatewayOutbound
+	}
+	return s[i].Conn.Cid < s[j].Conn.Cid
+}
+
+// trafficCounters are the in/out msgs and bytes of a connection,
+// used to calculate its rates between polls.
+type trafficCounters struct {
+	InMsgs   int64
+	OutMsgs  int64
+	InBytes  int64
+	OutBytes int64
+}
+
+// trafficRates returns the rates from the counters of the previous
+// poll, or empty rates in case the connection was not seen before.
+func trafficRates(cur trafficCounters, last trafficCounters, seen bool, tdelta time.Duration) *ConnRates {
+	if !seen {
+		return &ConnRates{}
+	}
+	return &ConnRates{
+		InMsgsRate:   float64(cur.InMsgs-last.InMsgs) / tdelta.Seconds(),
+		OutMsgsRate:  float64(cur.OutMsgs-last.OutMsgs) / tdelta.Seconds(),
+		InBytesRate:  float64(cur.InBytes-last.InBytes) / tdelta.Seconds(),
+		OutBytesRate: float64(cur.OutBytes-last.OutBytes) / tdelta.Seconds(),
+	}
+}
+
+// leafRates calculates the rates of the leafnode connections from
+// the counters of the previous poll, returning the counters to use
+// for the next one.
+func leafRates(leafz *Leafz, last map[string]trafficCounters, tdelta time.Duration, rates map[string]*ConnRates) map[string]trafficCounters {
+	counters := make(map[string]trafficCounters)
+	if leafz == nil {
+		return counters
+	}
+	for _, leaf := range leafz.Leafs {
+		if leaf == nil {
+			continue
+		}
+		cur := trafficCounters{leaf.InMsgs, leaf.OutMsgs, leaf.InBytes, leaf.OutBytes}
+		prev, seen := last[leaf.Key()]
+		rates[leaf.Key()] = trafficRates(cur, prev, seen, tdelta)
+		counters[leaf.Key()] = cur
+	}
+	return counters
+}
+
+// gatewayRates calculates the rates of the gateway connections from
+// the counters of the previous poll, returning the counters to use
+// for the next one.
+func gatewayRates(gatewayz *Gatewayz, last map[string]trafficCounters, tdelta time.Duration, rates map[string]*ConnRates) map[string]trafficCounters {
+	counters := make(map[string]trafficCounters)
+	for _, peer := range GatewayPeers(gatewayz) {
+		conn := peer.Conn
+		cur := trafficCounters{conn.InMsgs, conn.OutMsgs, conn.InBytes, conn.OutBytes}
+		prev, seen := last[peer.Key()]
+		rates[peer.Key()] = trafficRates(cur, prev, seen, tdelta)
+		counters[peer.Key()] = cur
+	}
+	return counters
+}
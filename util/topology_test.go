@@ -0,0 +1,113 @@
+package toputils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFetchingLeafzAndGatewayz(t *testing.T) {
+	ts := runFixtureServer()
+	defer ts.Close()
+
+	engine := newFixtureEngine(ts)
+	if err := engine.SetupCapabilities(); err != nil {
+		t.Fatalf("Failed detecting capabilities: %v", err)
+	}
+	if !engine.hasEndpoint("/leafz") || !engine.hasEndpoint("/gatewayz") {
+		t.Fatalf("Expected leafz and gatewayz endpoints. got: %v", engine.Capabilities.Endpoints)
+	}
+
+	go engine.MonitorStats()
+	defer close(engine.ShutdownCh)
+
+	var stats *Stats
+	select {
+	case stats = <-engine.StatsCh:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out waiting for stats")
+	}
+	if stats.Error.Error() != "" {
+		t.Fatalf("Failed polling the fixture server: %v", stats.Error)
+	}
+
+	if stats.Leafz == nil || stats.Leafz.NumLeafs != 2 || len(stats.Leafz.Leafs) != 2 {
+		t.Fatalf("Wrong leafz from server. got: %+v", stats.Leafz)
+	}
+	leaf := stats.Leafz.Leafs[0]
+	if leaf.Name != "edge-1" || leaf.Account != "ORDERS" || leaf.RTT != "12.5ms" || leaf.NumSubs != 8 {
+		t.Fatalf("Wrong leafnode connection. got: %+v", leaf)
+	}
+	if _, ok := stats.Rates.Leafs[leaf.Key()]; !ok {
+		t.Fatalf("Expected rates for leafnode connection %v", leaf.Key())
+	}
+
+	if stats.Gatewayz == nil || stats.Gatewayz.Name != "east" {
+		t.Fatalf("Wrong gatewayz from server. got: %+v", stats.Gatewayz)
+	}
+	peers := GatewayPeers(stats.Gatewayz)
+	if len(peers) != 3 {
+		t.Fatalf("Wrong number of gateway connections. expected: %v, got: %v", 3, len(peers))
+	}
+	if len(stats.Rates.Gateways) != 3 {
+		t.Fatalf("Expected rates for each gateway connection. got: %v", stats.Rates.Gateways)
+	}
+}
+
+func TestGatewayPeers(t *testing.T) {
+	gatewayz := &Gatewayz{
+		OutboundGateways: map[string]*RemoteGatewayz{
+			"west": {IsConfigured: true, Connection: &GatewayConnInfo{Cid: 21}},
+			"down": {IsConfigured: true},
+		},
+		InboundGateways: map[string][]*RemoteGatewayz{
+			"west":    {{Connection: &GatewayConnInfo{Cid: 24}}, {Connection: &GatewayConnInfo{Cid: 22}}},
+			"central": {{Connection: &GatewayConnInfo{Cid: 30}}},
+		},
+	}
+
+	expected := []string{"inbound/central/30", "outbound/west/21", "inbound/west/22", "inbound/west/24"}
+	peers := GatewayPeers(gatewayz)
+	if len(peers) != len(expected) {
+		t.Fatalf("Wrong number of gateway connections. expected: %v, got: %v", len(expected), len(peers))
+	}
+	for i, peer := range peers {
+		if peer.Key() != expected[i] {
+			t.Fatalf("Wrong gateway connection at %d. expected: %v, got: %v", i, expected[i], peer.Key())
+		}
+	}
+	if len(GatewayPeers(nil)) != 0 {
+		t.Fatalf("Expected no gateway connections without gatewayz")
+	}
+}
+
+func TestLeafAndGatewayRates(t *testing.T) {
+	leafz := &Leafz{Leafs: []*LeafInfo{
+		{IP: "10.1.0.5", Port: 51432, InMsgs: 100, OutMsgs: 10, InBytes: 1000, OutBytes: 100},
+	}}
+
+	rates := make(map[string]*ConnRates)
+	last := leafRates(leafz, nil, time.Second, rates)
+	if r := rates["10.1.0.5:51432"]; r == nil || r.InMsgsRate != 0 {
+		t.Fatalf("Expected empty rates for new leafnode connection. got: %+v", r)
+	}
+
+	leafz.Leafs[0].InMsgs = 300
+	leafz.Leafs[0].OutBytes = 500
+	rates = make(map[string]*ConnRates)
+	leafRates(leafz, last, 2*time.Second, rates)
+	r := rates["10.1.0.5:51432"]
+	if r.InMsgsRate != 100 || r.OutBytesRate != 200 || r.OutMsgsRate != 0 {
+		t.Fatalf("Wrong leafnode rates. got: %+v", r)
+	}
+
+	gatewayz := &Gatewayz{OutboundGateways: map[string]*RemoteGatewayz{
+		"west": {Connection: &GatewayConnInfo{Cid: 21, OutMsgs: 10}},
+	}}
+	rates = make(map[string]*ConnRates)
+	last = gatewayRates(gatewayz, nil, time.Second, rates)
+	gatewayz.OutboundGateways["west"].Connection.OutMsgs = 40
+	gatewayRates(gatewayz, last, time.Second, rates)
+	if r := rates["outbound/west/21"]; r.OutMsgsRate != 30 {
+		t.Fatalf("Wrong gateway rates. got: %+v", r)
+	}
+}
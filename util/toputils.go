@@ -1,186 +1,433 @@
 package toputils
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sync"
 	"time"
 
 	gnatsd "github.com/nats-io/gnatsd/server"
 )
 
-const DisplaySubscriptions = 1
+const (
+	DisplaySubscriptions = 1
+	DisplayAuthDetails   = 1
+)
+
+// DefaultRequestTimeout is how long to wait for a response
+// from the server, so that polling does not hang forever.
+const DefaultRequestTimeout = 10 * time.Second
 
+// Engine polls the server and sends the stats to StatsCh and the
+// subscribers. The fields of its Settings can be set before polling,
+// and through UpdateSettings afterwards.
 type Engine struct {
-	Host        string
-	Port        int
-	HttpClient  *http.Client
-	Uri         string
-	Conns       int
-	SortOpt     gnatsd.SortOpt
-	Delay       int
-	DisplaySubs bool
-	StatsCh     chan *Stats
-	ShutdownCh  chan struct{}
+	engineSettings
+
+	Host       string
+	Port       int
+	HttpClient *http.Client
+	Uri        string
+	History    *ConnHistory
+	StatsCh    chan *Stats
+	ShutdownCh chan struct{}
+
+	// Capabilities of the server, nil until detected.
+	Capabilities *Capabilities
+
+	// Recorder writes each snapshot to a file, when set.
+	Recorder *Recorder
+
+	// Scrollback keeps the latest stats, when set.
+	Scrollback *Scrollback
+
+	// Source of the responses, which is the server
+	// at Uri using HttpClient in case it is not set.
+	Source Source
+
+	// RequestTimeout is how long to wait for each one
+	// of the responses, or forever when zero.
+	RequestTimeout time.Duration
+
+	// Guards the settings while polling
+	mu           sync.RWMutex
+	connzTime    time.Duration
+	delayChanged chan struct{}
+
+	subsMu      sync.Mutex
+	subscribers map[<-chan *Stats]chan *Stats
 }
 
 func NewEngine(host string, port int, conns int, delay int) *Engine {
 	return &Engine{
+		engineSettings: engineSettings{
+			Conns: conns,
+			Delay: delay,
+		},
 		Host:       host,
 		Port:       port,
-		Conns:      conns,
-		Delay:      delay,
+		History:    newTalkersHistory(),
 		StatsCh:    make(chan *Stats),
 		ShutdownCh: make(chan struct{}),
+
+		RequestTimeout: DefaultRequestTimeout,
+
+		delayChanged: make(chan struct{}, 1),
 	}
 }
 
+// newTalkersHistory returns the history for the top talkers, which
+// only keeps samples once enabled since it grows with the connections.
+func newTalkersHistory() *ConnHistory {
+	history := NewConnHistory(TalkersWindows[len(TalkersWindows)-1])
+	history.SetEnabled(false)
+	return history
+}
+
 // Request takes a path and options, and returns a Stats struct
-// with with either connz or varz
+// with with either connz, varz, routez, subsz, leafz, gatewayz, jsz
+// or accountz, or the raw goroutines dump in case of stacksz
 func (engine *Engine) Request(path string) (interface{}, error) {
+	// Connections are decoded as they arrive
+	if path == "/connz" {
+		connz, _, err := engine.fetchConnz(context.Background(), engine.connzOptions())
+		if err != nil {
+			return nil, err
+		}
+		return connz, nil
+	}
+
+	body, err := engine.Fetch(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Decode(path, body)
+}
+
+// Fetch takes a path and returns the raw response
+// from the source without decoding it.
+func (engine *Engine) Fetch(path string) ([]byte, error) {
+	return engine.fetch(context.Background(), path, engine.connzOptions())
+}
+
+// FetchAccountConnz returns the raw connections from /connz
+// which belong to a single account.
+func (engine *Engine) FetchAccountConnz(account string) ([]byte, error) {
+	return engine.fetch(context.Background(), "/connz", engine.accountConnzOptions(account))
+}
+
+// fetch gets the response from the source, giving up
+// after the request timeout unless the context is done before.
+func (engine *Engine) fetch(ctx context.Context, path string, opts *ConnzOptions) ([]byte, error) {
+	ctx, cancel := engine.requestContext(ctx)
+	defer cancel()
+	return engine.source().Fetch(ctx, path, opts)
+}
+
+// requestContext returns a context which is done after the request
+// timeout, unless the one given is done before.
+func (engine *Engine) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if engine.RequestTimeout > 0 {
+		return context.WithTimeout(ctx, engine.RequestTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// source returns the source of the engine, which is
+// the server at Uri using HttpClient unless set.
+func (engine *Engine) source() Source {
+	if engine.Source != nil {
+		return engine.Source
+	}
+	return &HTTPSource{Client: engine.HttpClient, Uri: engine.Uri}
+}
+
+func (engine *Engine) connzOptions() *ConnzOptions {
+	settings := engine.Settings()
+	return &ConnzOptions{
+		Limit: settings.Conns,
+		Sort:  settings.SortOpt,
+		Subs:  settings.DisplaySubs,
+		Auth:  settings.DisplayAuth,
+	}
+}
+
+func (engine *Engine) accountConnzOptions(account string) *ConnzOptions {
+	opts := engine.connzOptions()
+	opts.Subs = false
+	opts.Account = account
+	return opts
+}
+
+// Decode takes the raw response from a path and returns either connz,
+// varz, routez, subsz, leafz, gatewayz, jsz or accountz, or the response
+// as is in case of the root path and stacksz which are not json.
+func Decode(path string, body []byte) (interface{}, error) {
 	var statz interface{}
 
-	uri := engine.Uri + path
 	switch path {
 	case "/varz":
 		statz = &gnatsd.Varz{}
 	case "/connz":
 		statz = &gnatsd.Connz{}
-		uri += fmt.Sprintf("?limit=%d&sort=%s", engine.Conns, engine.SortOpt)
-		if engine.DisplaySubs {
-			uri += fmt.Sprintf("&subs=%d", DisplaySubscriptions)
-		}
+	case "/routez":
+		statz = &gnatsd.Routez{}
+	case "/subsz":
+		statz = &gnatsd.Subsz{}
+	case "/leafz":
+		statz = &Leafz{}
+	case "/gatewayz":
+		statz = &Gatewayz{}
+	case "/jsz":
+		statz = &JSInfo{}
+	case "/accountz":
+		statz = &Accountz{}
+	case "/", "/stacksz":
+		return body, nil
 	default:
 		return nil, fmt.Errorf("invalid path '%s' for stats server", path)
 	}
 
-	resp, err := engine.HttpClient.Get(uri)
-	if resp != nil {
-		defer resp.Body.Close()
-	}
+	err := unmarshalTolerant(body, statz)
 	if err != nil {
-		return nil, fmt.Errorf("could not get stats from server: %v\n", err)
+		return nil, fmt.Errorf("could not unmarshal json: %v\n", err)
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("could not read response body: %v\n", err)
-	}
+	return statz, nil
+}
 
-	err = json.Unmarshal(body, &statz)
-	if err != nil {
-		return nil, fmt.Errorf("could not unmarshal json: %v\n", err)
+// EndpointError returns the error of the stats as a whole, or
+// otherwise the one from the optional endpoint in case it failed.
+func (stats *Stats) EndpointError(path string) error {
+	if err, ok := stats.Errors[path]; ok && (stats.Error == nil || stats.Error.Error() == "") {
+		return err
 	}
+	return stats.Error
+}
 
-	return statz, nil
+func (stats *Stats) setError(path string, err error) {
+	if stats.Errors == nil {
+		stats.Errors = make(map[string]error)
+	}
+	stats.Errors[path] = err
 }
 
 // MonitorStats is ran as a goroutine and takes options
 // which can modify how poll values then sends to channel.
 func (engine *Engine) MonitorStats() error {
-	var pollTime time.Time
+	tracker := newStatsTracker(engine.History)
+
+	last := time.Now()
+	for engine.WaitNextPoll(last) {
+		last = time.Now()
+		snap := engine.poll(engine.Recorder == nil)
+		stats := tracker.update(snap)
+		if err := engine.Record(snap); err != nil {
+			stats.Error = err
+		}
+		if !engine.publish(stats) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Record writes the snapshot to the recording, if any.
+func (engine *Engine) Record(snap *Snapshot) error {
+	if engine.Recorder == nil {
+		return nil
+	}
+	return engine.Recorder.Record(snap)
+}
 
-	var inMsgsDelta int64
-	var outMsgsDelta int64
-	var inBytesDelta int64
-	var outBytesDelta int64
+// statsTracker keeps the values from the previous snapshot
+// which are needed to calculate the rates of the next one.
+type statsTracker struct {
+	pollTime time.Time
+
+	inMsgsLastVal   int64
+	outMsgsLastVal  int64
+	inBytesLastVal  int64
+	outBytesLastVal int64
+
+	// Other server counters which are tracked per interval
+	totalConnsLastVal    uint64
+	slowConsumersLastVal int64
+	subsLastVal          uint32
+	httpReqLastVals      map[string]uint64
+	countersTracked      bool
+
+	// Last seen counters of each polled connection,
+	// used to calculate per connection rates.
+	lastConns     map[uint64]gnatsd.ConnInfo
+	lastLeafs     map[string]trafficCounters
+	lastGateways  map[string]trafficCounters
+	lastStreams   map[string]JSStreamState
+	lastConsumers map[string]*JSConsumer
+
+	// Smoothed rates, which start to be tracked
+	// once the first rates have been calculated.
+	averages *RatesAverages
+
+	first   bool
+	history *ConnHistory
+}
 
-	var inMsgsLastVal int64
-	var outMsgsLastVal int64
-	var inBytesLastVal int64
-	var outBytesLastVal int64
+func newStatsTracker(history *ConnHistory) *statsTracker {
+	return &statsTracker{
+		httpReqLastVals: make(map[string]uint64),
+		lastConns:       make(map[uint64]gnatsd.ConnInfo),
+		lastLeafs:       make(map[string]trafficCounters),
+		lastGateways:    make(map[string]trafficCounters),
+		lastStreams:     make(map[string]JSStreamState),
+		lastConsumers:   make(map[string]*JSConsumer),
+		averages:        NewRatesAverages(),
+		first:           true,
+		history:         history,
+	}
+}
+
+// update decodes the snapshot and calculates the rates since the
+// previous one, which are left as they are in case of errors.
+func (tracker *statsTracker) update(snap *Snapshot) *Stats {
+	stats := &Stats{
+		Varz:     &gnatsd.Varz{},
+		Connz:    &gnatsd.Connz{},
+		Routez:   &gnatsd.Routez{},
+		VarzExt:  &VarzExt{},
+		ConnsExt: make(map[uint64]*ConnInfoExt),
+		Rates:    &Rates{},
+		Error:    fmt.Errorf(""),
+		Time:     snap.Time,
+	}
+	if err := snap.decode(stats); err != nil {
+		stats.Error = err
+		return stats
+	}
 
 	var inMsgsRate float64
 	var outMsgsRate float64
 	var inBytesRate float64
 	var outBytesRate float64
+	var averaged map[RateMode]*Rates
+
+	// Periodic snapshot to get per sec metrics
+	inMsgsVal := stats.Varz.InMsgs
+	outMsgsVal := stats.Varz.OutMsgs
+	inBytesVal := stats.Varz.InBytes
+	outBytesVal := stats.Varz.OutBytes
+
+	inMsgsDelta := inMsgsVal - tracker.inMsgsLastVal
+	outMsgsDelta := outMsgsVal - tracker.outMsgsLastVal
+	inBytesDelta := inBytesVal - tracker.inBytesLastVal
+	outBytesDelta := outBytesVal - tracker.outBytesLastVal
+
+	tracker.inMsgsLastVal = inMsgsVal
+	tracker.outMsgsLastVal = outMsgsVal
+	tracker.inBytesLastVal = inBytesVal
+	tracker.outBytesLastVal = outBytesVal
+
+	now := snap.Time
+	tdelta := now.Sub(tracker.pollTime)
+	tracker.pollTime = now
+	stats.ConnzTime = snap.ConnzTime
+
+	// Calculate rates but the first time
+	if tracker.first {
+		tracker.first = false
+	} else {
+		stats.Interval = tdelta
+		inMsgsRate = float64(inMsgsDelta) / tdelta.Seconds()
+		outMsgsRate = float64(outMsgsDelta) / tdelta.Seconds()
+		inBytesRate = float64(inBytesDelta) / tdelta.Seconds()
+		outBytesRate = float64(outBytesDelta) / tdelta.Seconds()
+
+		averaged = tracker.averages.Update(&Rates{
+			InMsgsRate:   inMsgsRate,
+			OutMsgsRate:  outMsgsRate,
+			InBytesRate:  inBytesRate,
+			OutBytesRate: outBytesRate,
+		}, tdelta)
+	}
 
-	first := true
-	pollTime = time.Now()
-
-	delay := time.Duration(engine.Delay) * time.Second
+	stats.Rates = &Rates{
+		InMsgsRate:   inMsgsRate,
+		OutMsgsRate:  outMsgsRate,
+		InBytesRate:  inBytesRate,
+		OutBytesRate: outBytesRate,
+		Connections:  make(map[uint64]*ConnRates),
+		Leafs:        make(map[string]*ConnRates),
+		Gateways:     make(map[string]*ConnRates),
+		Streams:      make(map[string]*StreamRates),
+		Consumers:    make(map[string]*ConsumerRates),
+		Averages:     averaged,
+		HTTPReqRates: make(map[string]float64),
+	}
 
-	for {
-		stats := &Stats{
-			Varz:  &gnatsd.Varz{},
-			Connz: &gnatsd.Connz{},
-			Rates: &Rates{},
-			Error: fmt.Errorf(""),
+	// Deltas for the rest of the server counters, also
+	// skipped the first time since there is no previous value.
+	// Unsigned counters are also skipped in case they went
+	// backwards, which happens when the server is restarted.
+	if tracker.countersTracked {
+		if stats.Varz.TotalConnections >= tracker.totalConnsLastVal {
+			stats.Rates.NewConnsRate = float64(stats.Varz.TotalConnections-tracker.totalConnsLastVal) / tdelta.Seconds()
 		}
-
-		select {
-		case <-engine.ShutdownCh:
-			return nil
-		case <-time.After(delay):
-			// Get /varz
-			{
-				result, err := engine.Request("/varz")
-				if err != nil {
-					stats.Error = err
-					engine.StatsCh <- stats
-					continue
-				}
-				if varz, ok := result.(*gnatsd.Varz); ok {
-					stats.Varz = varz
-				}
+		stats.Rates.NewSlowConsumers = stats.Varz.SlowConsumers - tracker.slowConsumersLastVal
+		stats.Rates.SubsDelta = int64(stats.Varz.Subscriptions) - int64(tracker.subsLastVal)
+		for path, val := range stats.Varz.HTTPReqStats {
+			if last := tracker.httpReqLastVals[path]; val >= last {
+				stats.Rates.HTTPReqRates[path] = float64(val-last) / tdelta.Seconds()
 			}
+		}
+	}
+	tracker.countersTracked = true
+	tracker.totalConnsLastVal = stats.Varz.TotalConnections
+	tracker.slowConsumersLastVal = stats.Varz.SlowConsumers
+	tracker.subsLastVal = stats.Varz.Subscriptions
+	tracker.httpReqLastVals = make(map[string]uint64)
+	for path, val := range stats.Varz.HTTPReqStats {
+		tracker.httpReqLastVals[path] = val
+	}
 
-			// Get /connz
-			{
-				result, err := engine.Request("/connz")
-				if err != nil {
-					stats.Error = err
-					engine.StatsCh <- stats
-					continue
-				}
-				if connz, ok := result.(*gnatsd.Connz); ok {
-					stats.Connz = connz
-				}
-			}
+	// Per connection rates, only for the ones which were
+	// also included in the previous poll.
+	conns := make(map[uint64]gnatsd.ConnInfo)
+	for _, conn := range stats.Connz.Conns {
+		conns[conn.Cid] = conn
 
-			// Periodic snapshot to get per sec metrics
-			inMsgsVal := stats.Varz.InMsgs
-			outMsgsVal := stats.Varz.OutMsgs
-			inBytesVal := stats.Varz.InBytes
-			outBytesVal := stats.Varz.OutBytes
-
-			inMsgsDelta = inMsgsVal - inMsgsLastVal
-			outMsgsDelta = outMsgsVal - outMsgsLastVal
-			inBytesDelta = inBytesVal - inBytesLastVal
-			outBytesDelta = outBytesVal - outBytesLastVal
-
-			inMsgsLastVal = inMsgsVal
-			outMsgsLastVal = outMsgsVal
-			inBytesLastVal = inBytesVal
-			outBytesLastVal = outBytesVal
-
-			now := time.Now()
-			tdelta := now.Sub(pollTime)
-			pollTime = now
-
-			// Calculate rates but the first time
-			if first {
-				first = false
-			} else {
-				inMsgsRate = float64(inMsgsDelta) / tdelta.Seconds()
-				outMsgsRate = float64(outMsgsDelta) / tdelta.Seconds()
-				inBytesRate = float64(inBytesDelta) / tdelta.Seconds()
-				outBytesRate = float64(outBytesDelta) / tdelta.Seconds()
-			}
+		last, ok := tracker.lastConns[conn.Cid]
+		if !ok {
+			stats.Rates.Connections[conn.Cid] = &ConnRates{}
+			continue
+		}
+		stats.Rates.Connections[conn.Cid] = &ConnRates{
+			InMsgsRate:   float64(conn.InMsgs-last.InMsgs) / tdelta.Seconds(),
+			OutMsgsRate:  float64(conn.OutMsgs-last.OutMsgs) / tdelta.Seconds(),
+			InBytesRate:  float64(conn.InBytes-last.InBytes) / tdelta.Seconds(),
+			OutBytesRate: float64(conn.OutBytes-last.OutBytes) / tdelta.Seconds(),
+		}
+	}
+	tracker.lastConns = conns
 
-			stats.Rates = &Rates{
-				InMsgsRate:   inMsgsRate,
-				OutMsgsRate:  outMsgsRate,
-				InBytesRate:  inBytesRate,
-				OutBytesRate: outBytesRate,
-			}
+	// Leafnode and gateway connections rates
+	tracker.lastLeafs = leafRates(stats.Leafz, tracker.lastLeafs, tdelta, stats.Rates.Leafs)
+	tracker.lastGateways = gatewayRates(stats.Gatewayz, tracker.lastGateways, tdelta, stats.Rates.Gateways)
 
-			engine.StatsCh <- stats
-		}
+	// JetStream streams and consumers rates
+	tracker.lastStreams = streamRates(stats.Jsz, tracker.lastStreams, tdelta, stats.Rates.Streams)
+	tracker.lastConsumers = consumerRates(stats.Jsz, tracker.lastConsumers, tdelta, stats.Rates.Consumers)
+
+	// Keep track of recent traffic from connections
+	if tracker.history != nil {
+		tracker.history.Add(now, stats.Connz.Conns)
 	}
+
+	return stats
 }
 
 // SetupHTTPS sets up the http client and uri to use for polling.
@@ -215,6 +462,35 @@ func (engine *Engine) SetupHTTPS(caCertOpt, certOpt, keyOpt string, skipVerifyOp
 	return nil
 }
 
+// SetupCapabilities detects what can be polled from the server
+// based on its version and the endpoints from the root endpoint.
+func (engine *Engine) SetupCapabilities() error {
+	result, err := engine.Request("/varz")
+	if err != nil {
+		return err
+	}
+	var version string
+	if varz, ok := result.(*gnatsd.Varz); ok && varz.Info != nil {
+		version = varz.Info.Version
+	}
+
+	// Root endpoint may not be exposed, in which case
+	// the endpoints are inferred from the version.
+	root, err := engine.Fetch("/")
+	if err != nil {
+		root = nil
+	}
+	engine.Capabilities = DetectCapabilities(version, root)
+
+	return nil
+}
+
+// hasEndpoint returns whether the server was detected
+// to have one of the monitoring endpoints.
+func (engine *Engine) hasEndpoint(path string) bool {
+	return engine.Capabilities != nil && engine.Capabilities.HasEndpoint(path)
+}
+
 // SetupHTTP sets up the http client and uri to use for polling.
 func (engine *Engine) SetupHTTP() {
 	engine.HttpClient = &http.Client{}
@@ -225,10 +501,48 @@ func (engine *Engine) SetupHTTP() {
 
 // Stats represents the monitored data from a NATS server.
 type Stats struct {
-	Varz  *gnatsd.Varz
-	Connz *gnatsd.Connz
+	Varz   *gnatsd.Varz
+	Connz  *gnatsd.Connz
+	Routez *gnatsd.Routez
+
+	// Fields from newer versions of the server
+	// which are not present in gnatsd structs.
+	VarzExt  *VarzExt
+	ConnsExt map[uint64]*ConnInfoExt
+
+	// Leafnode and gateway connections, nil
+	// unless the server supports their endpoints.
+	Leafz    *Leafz
+	Gatewayz *Gatewayz
+
+	// JetStream streams and consumers, nil
+	// unless the server supports JetStream.
+	Jsz *JSInfo
+
+	// Accounts from the server, along with the connections
+	// of the account being drilled into, nil unless the
+	// server supports accounts.
+	Accountz        *Accountz
+	AccountConnz    *gnatsd.Connz
+	AccountConnsExt map[uint64]*ConnInfoExt
+
 	Rates *Rates
 	Error error
+
+	// Errors from the optional endpoints by path, e.g. /jsz,
+	// which are only shown in their views.
+	Errors map[string]error
+
+	// Playback is where the replay is at, nil unless replaying.
+	Playback *Playback
+
+	// Interval is the time since the previous poll, zero for the
+	// first one, and ConnzTime how long /connz took to respond.
+	Interval  time.Duration
+	ConnzTime time.Duration
+
+	// Time is when the stats were polled.
+	Time time.Time
 }
 
 // Rates represents the tracked in/out msgs and bytes flow
@@ -238,6 +552,42 @@ type Rates struct {
 	OutMsgsRate  float64
 	InBytesRate  float64
 	OutBytesRate float64
+	Connections  map[uint64]*ConnRates
+
+	// Averages has the server rates smoothed by each one of
+	// the rate modes, empty until the first rates are calculated.
+	Averages map[RateMode]*Rates
+
+	// NewConnsRate is the rate of new connections per second,
+	// whereas NewSlowConsumers and SubsDelta are the changes
+	// in the number of slow consumers and subscriptions since
+	// the previous poll.
+	NewConnsRate     float64
+	NewSlowConsumers int64
+	SubsDelta        int64
+
+	// HTTPReqRates is the rate of requests per second
+	// to each one of the monitoring endpoints.
+	HTTPReqRates map[string]float64
+
+	// Leafs and Gateways are the rates of each one of the
+	// leafnode and gateway connections, by their keys.
+	Leafs    map[string]*ConnRates
+	Gateways map[string]*ConnRates
+
+	// Streams and Consumers are the rates of each one of
+	// the JetStream streams and consumers, by their keys.
+	Streams   map[string]*StreamRates
+	Consumers map[string]*ConsumerRates
+}
+
+// ConnRates represents the tracked in/out msgs and bytes flow
+// of a single client connection.
+type ConnRates struct {
+	InMsgsRate   float64
+	OutMsgsRate  float64
+	InBytesRate  float64
+	OutBytesRate float64
 }
 
 // Psize takes a float and returns a human readable string.
@@ -1,9 +1,12 @@
 package toputils
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -28,6 +31,63 @@ func resetPreviousHTTPConnections() {
 	http.DefaultTransport = &http.Transport{}
 }
 
+// fixtureRoot is the monitoring root page of a newer server.
+const fixtureRoot = `<html><body>
+<a href=./varz>General</a><a href=./connz>Connections</a><a href=./routez>Routes</a>
+<a href=./gatewayz>Gateways</a><a href=./leafz>Leaf Nodes</a><a href=./subsz>Subscriptions</a>
+<a href=./accountz>Accounts</a><a href=./jsz>JetStream</a>
+</body></html>`
+
+// runFixtureServer starts a stand-in for a newer server, which responds
+// to the monitoring endpoints with the recorded responses in test/,
+// e.g. test/leafz_v2.json for /leafz. Connections are filtered by
+// account in case of /connz?acc=.
+func runFixtureServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, fixtureRoot)
+			return
+		}
+		body, err := ioutil.ReadFile(fmt.Sprintf("test%s_v2.json", r.URL.Path))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if acc := r.URL.Query().Get("acc"); r.URL.Path == "/connz" && acc != "" {
+			body = filterFixtureConns(body, acc)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+}
+
+func filterFixtureConns(body []byte, account string) []byte {
+	var connz map[string]interface{}
+	json.Unmarshal(body, &connz)
+	conns, _ := connz["connections"].([]interface{})
+	filtered := make([]interface{}, 0)
+	for _, conn := range conns {
+		if c, ok := conn.(map[string]interface{}); ok && c["account"] == account {
+			filtered = append(filtered, conn)
+		}
+	}
+	connz["connections"] = filtered
+	connz["num_connections"] = len(filtered)
+	body, _ = json.Marshal(connz)
+	return body
+}
+
+// newFixtureEngine returns an engine polling from a fixture server.
+func newFixtureEngine(ts *httptest.Server) *Engine {
+	engine := NewEngine("127.0.0.1", 0, 10, 1)
+	engine.HttpClient = &http.Client{}
+	engine.Uri = ts.URL
+
+	// Fixtures have all the endpoints, including the largest one
+	engine.PollJetStream = true
+	return engine
+}
+
 func TestFetchingStatz(t *testing.T) {
 	engine := &Engine{}
 	engine.Uri = fmt.Sprintf("http://%s:%d", "127.0.0.1", server.DEFAULT_HTTP_PORT)
@@ -99,6 +159,32 @@ func TestFetchingStatz(t *testing.T) {
 	s.Shutdown()
 }
 
+func TestFetchingRoutez(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+	engine.SetupHTTP()
+
+	s := runMonitorServer(server.DEFAULT_HTTP_PORT)
+	defer s.Shutdown()
+
+	result, err := engine.Request("/routez")
+	if err != nil {
+		t.Fatalf("Failed getting /routez: %v", err)
+	}
+
+	routez, ok := result.(*server.Routez)
+	if !ok {
+		t.Fatalf("Wrong type for /routez. got: %T", result)
+	}
+	if routez.NumRoutes != 0 {
+		t.Fatalf("Wrong number of routes. expected: %v, got: %v", 0, routez.NumRoutes)
+	}
+
+	_, err = engine.Request("/unknownz")
+	if err == nil {
+		t.Fatalf("Expected error requesting unknown path")
+	}
+}
+
 func TestPsize(t *testing.T) {
 
 	expected := "1023"
@@ -152,6 +238,51 @@ func TestMonitorStats(t *testing.T) {
 	}
 }
 
+func TestMonitorStatsServerCountersRates(t *testing.T) {
+	engine := NewEngine("127.0.0.1", server.DEFAULT_HTTP_PORT, 10, 1)
+	engine.SetupHTTP()
+	s := runMonitorServer(server.DEFAULT_HTTP_PORT)
+	defer s.Shutdown()
+
+	go func() {
+		err := engine.MonitorStats()
+		if err != nil {
+			t.Errorf("Could not start info monitoring loop. expected no error, got: %v", err)
+		}
+	}()
+	defer close(engine.ShutdownCh)
+
+	select {
+	case <-engine.StatsCh:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out polling /varz via http")
+	}
+
+	// Create a new connection to be included in the next interval
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", GNATSD_PORT))
+	if err != nil {
+		t.Fatalf("Could not create connection to NATS: %s", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "CONNECT {}\r\nSUB hello.world 90\r\nPING\r\n")
+	time.Sleep(100 * time.Millisecond)
+
+	select {
+	case stats := <-engine.StatsCh:
+		if stats.Rates.NewConnsRate <= 0 {
+			t.Fatalf("Expected rate of new connections. got: %v", stats.Rates.NewConnsRate)
+		}
+		if stats.Rates.SubsDelta != 1 {
+			t.Fatalf("Wrong delta of subscriptions. expected: %v, got: %v", 1, stats.Rates.SubsDelta)
+		}
+		if stats.Rates.HTTPReqRates[server.VarzPath] <= 0 {
+			t.Fatalf("Expected rate of requests to %s. got: %v", server.VarzPath, stats.Rates.HTTPReqRates)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out polling /varz via http")
+	}
+}
+
 func TestMonitoringTLSConnectionUsingRootCA(t *testing.T) {
 	srv, _ := gnatsd.RunServerWithConfig("./test/tls.conf")
 	defer srv.Shutdown()
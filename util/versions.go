@@ -0,0 +1,132 @@
+package toputils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+// ClientVersion represents the connections from the same
+// client library, that is same language and version.
+type ClientVersion struct {
+	Lang       string
+	Version    string
+	NumConns   int
+	Names      []string
+	MinVersion string
+	Outdated   bool
+}
+
+// ParseMinVersions takes the minimum version of the client
+// libraries by language, e.g. 'go=1.31.0,java=2.17.0'.
+func ParseMinVersions(s string) (map[string]string, error) {
+	versions := make(map[string]string)
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid minimum version '%s', expected lang=version", field)
+		}
+		versions[strings.ToLower(strings.TrimSpace(parts[0]))] = strings.TrimSpace(parts[1])
+	}
+	return versions, nil
+}
+
+// CompareVersions returns -1, 0 or 1 depending on whether
+// the version a is older, the same or newer than b.
+func CompareVersions(a, b string) int {
+	amajor, aminor, apatch := parseVersion(a)
+	bmajor, bminor, bpatch := parseVersion(b)
+	av := []int{amajor, aminor, apatch}
+	bv := []int{bmajor, bminor, bpatch}
+	for i := range av {
+		if av[i] < bv[i] {
+			return -1
+		}
+		if av[i] > bv[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// AuditVersions groups the connections by language and version of
+// their client library, flagging the ones older than the minimum
+// version for their language. Connections without a version are
+// also flagged when there is a minimum version for their language.
+func AuditVersions(conns []gnatsd.ConnInfo, minVersions map[string]string) []*ClientVersion {
+	versions := make([]*ClientVersion, 0)
+	index := make(map[string]*ClientVersion)
+	names := make(map[string]map[string]bool)
+
+	for _, conn := range conns {
+		lang, version := conn.Lang, conn.Version
+		if lang == "" {
+			lang = UnknownGroupKey
+		}
+		if version == "" {
+			version = UnknownGroupKey
+		}
+
+		key := lang + " " + version
+		v, ok := index[key]
+		if !ok {
+			v = &ClientVersion{Lang: lang, Version: version}
+			if min, ok := minVersions[strings.ToLower(lang)]; ok {
+				v.MinVersion = min
+				v.Outdated = conn.Version == "" || CompareVersions(conn.Version, min) < 0
+			}
+			index[key] = v
+			names[key] = make(map[string]bool)
+			versions = append(versions, v)
+		}
+		v.NumConns++
+		if conn.Name != "" && !names[key][conn.Name] {
+			names[key][conn.Name] = true
+			v.Names = append(v.Names, conn.Name)
+		}
+	}
+
+	for _, v := range versions {
+		sort.Strings(v.Names)
+	}
+	sort.Sort(clientVersionsByLang(versions))
+
+	return versions
+}
+
+// HasOutdated returns whether any of the client versions is outdated.
+func HasOutdated(versions []*ClientVersion) bool {
+	for _, v := range versions {
+		if v.Outdated {
+			return true
+		}
+	}
+	return false
+}
+
+// Versions are sorted by language, then newest first.
+type clientVersionsByLang []*ClientVersion
+
+func (s clientVersionsByLang) Len() int {
+	return len(s)
+}
+
+func (s clientVersionsByLang) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s clientVersionsByLang) Less(i, j int) bool {
+	if s[i].Lang != s[j].Lang {
+		return s[i].Lang < s[j].Lang
+	}
+	if c := CompareVersions(s[i].Version, s[j].Version); c != 0 {
+		return c > 0
+	}
+	return s[i].Version < s[j].Version
+}
@@ -0,0 +1,86 @@
+package toputils
+
+import (
+	"testing"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+)
+
+func TestParseMinVersions(t *testing.T) {
+	versions, err := ParseMinVersions("go=1.31.0, Java=2.17.0,")
+	if err != nil {
+		t.Fatalf("Failed parsing minimum versions: %v", err)
+	}
+	if len(versions) != 2 || versions["go"] != "1.31.0" || versions["java"] != "2.17.0" {
+		t.Fatalf("Wrong minimum versions. got: %v", versions)
+	}
+	if versions, err := ParseMinVersions(""); err != nil || len(versions) != 0 {
+		t.Fatalf("Expected no minimum versions. got: %v, %v", versions, err)
+	}
+	for _, invalid := range []string{"go", "go=", "=1.0.0"} {
+		if _, err := ParseMinVersions(invalid); err == nil {
+			t.Fatalf("Expected error for minimum version %q", invalid)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.2.0", "1.2.0", 0},
+		{"1.2.0", "1.10.0", -1},
+		{"v2.0.0", "1.99.99", 1},
+		{"1.31.0-beta", "1.31.0", 0},
+		{"1.2", "1.2.1", -1},
+	}
+	for _, test := range tests {
+		if got := CompareVersions(test.a, test.b); got != test.expected {
+			t.Errorf("Wrong comparison of %v and %v. expected: %v, got: %v", test.a, test.b, test.expected, got)
+		}
+	}
+}
+
+func TestAuditVersions(t *testing.T) {
+	conns := []gnatsd.ConnInfo{
+		{Cid: 1, Name: "orders", Lang: "go", Version: "1.31.0"},
+		{Cid: 2, Name: "billing", Lang: "go", Version: "1.9.1"},
+		{Cid: 3, Name: "orders", Lang: "go", Version: "1.31.0"},
+		{Cid: 4, Name: "shipping", Lang: "go", Version: "1.31.0"},
+		{Cid: 5, Name: "web", Lang: "nats.js", Version: "2.17.0"},
+		{Cid: 6, Lang: "go"},
+		{Cid: 7},
+	}
+
+	versions := AuditVersions(conns, map[string]string{"go": "1.30.0"})
+	expected := []struct {
+		lang, version string
+		conns         int
+		outdated      bool
+	}{
+		{"-", "-", 1, false},
+		{"go", "1.31.0", 3, false},
+		{"go", "1.9.1", 1, true},
+		{"go", "-", 1, true},
+		{"nats.js", "2.17.0", 1, false},
+	}
+	if len(versions) != len(expected) {
+		t.Fatalf("Wrong number of versions. expected: %v, got: %v", len(expected), len(versions))
+	}
+	for i, e := range expected {
+		v := versions[i]
+		if v.Lang != e.lang || v.Version != e.version || v.NumConns != e.conns || v.Outdated != e.outdated {
+			t.Fatalf("Wrong version at %d. expected: %+v, got: %+v", i, e, v)
+		}
+	}
+	if names := versions[1].Names; len(names) != 2 || names[0] != "orders" || names[1] != "shipping" {
+		t.Fatalf("Wrong client names. got: %v", names)
+	}
+	if !HasOutdated(versions) {
+		t.Fatalf("Expected outdated clients")
+	}
+	if HasOutdated(AuditVersions(conns, nil)) {
+		t.Fatalf("Unexpected outdated clients without minimum versions")
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2016 NATS Messaging System
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+	top "github.com/nats-io/nats-top/util"
+)
+
+const (
+	// Chopped: LANG VERSION...
+	versionsHeaderFormat = "%-7s  %-10s  %-8s  %s\n"
+	versionsRowFormat    = "%-7d  %-10s  %-8s  %s\n"
+)
+
+// Minimum version of the client libraries by language
+var minVersions map[string]string
+
+// generateVersionsTable returns the connections grouped by the
+// language and version of their client library, flagging the
+// ones older than the minimum version for their language.
+func generateVersionsTable(conns []gnatsd.ConnInfo) string {
+	versions := top.AuditVersions(conns, minVersions)
+
+	langSize := len("LANG") + DEFAULT_PADDING_SIZE
+	versionSize := len("VERSION") + DEFAULT_PADDING_SIZE
+	var outdated int
+	for _, v := range versions {
+		if size := len(v.Lang) + DEFAULT_PADDING_SIZE; size > langSize {
+			langSize = size
+		}
+		if size := len(v.Version) + DEFAULT_PADDING_SIZE; size > versionSize {
+			versionSize = size
+		}
+		if v.Outdated {
+			outdated += v.NumConns
+		}
+	}
+
+	text := fmt.Sprintf("Client versions: %d  Connections Polled: %d  Outdated: %d\n\n",
+		len(versions), len(conns), outdated)
+
+	prefix := DEFAULT_PADDING
+	prefix += "%-" + fmt.Sprintf("%d", langSize) + "s "
+	prefix += "%-" + fmt.Sprintf("%d", versionSize) + "s "
+	text += fmt.Sprintf(prefix+versionsHeaderFormat, "LANG", "VERSION", "CONNS", "MINIMUM", "STATUS", "NAMES")
+	for _, v := range versions {
+		status := "ok"
+		if v.Outdated {
+			status = "OUTDATED"
+		} else if v.MinVersion == "" {
+			status = "-"
+		}
+		text += fmt.Sprintf(prefix+versionsRowFormat, v.Lang, v.Version, v.NumConns,
+			v.MinVersion, status, strings.Join(v.Names, ", "))
+	}
+
+	return text
+}
+
+// runAudit fetches the connections once and prints their client
+// versions, returning whether any of them is outdated.
+func runAudit(engine *top.Engine) (bool, error) {
+	// All the connections are audited rather than up to -n
+	body, err := engine.FetchAllConnz()
+	if err != nil {
+		return false, err
+	}
+	result, err := top.Decode("/connz", body)
+	if err != nil {
+		return false, err
+	}
+	connz, ok := result.(*gnatsd.Connz)
+	if !ok {
+		return false, fmt.Errorf("could not get /connz from server")
+	}
+
+	fmt.Print(generateVersionsTable(connz.Conns))
+	return top.HasOutdated(top.AuditVersions(connz.Conns, minVersions)), nil
+}
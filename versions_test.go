@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+	top "github.com/nats-io/nats-top/util"
+)
+
+func TestRunAuditAllConns(t *testing.T) {
+	const total = 1500
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		offset, _ := strconv.Atoi(q.Get("offset"))
+		limit, _ := strconv.Atoi(q.Get("limit"))
+
+		connz := &gnatsd.Connz{Total: total, Offset: offset, Limit: limit}
+		for cid := offset + 1; cid <= total && cid <= offset+limit; cid++ {
+			conn := gnatsd.ConnInfo{Cid: uint64(cid), Lang: "go", Version: "1.31.0"}
+			if cid == total {
+				conn.Version = "1.2.0"
+			}
+			connz.Conns = append(connz.Conns, conn)
+		}
+		connz.NumConns = len(connz.Conns)
+		json.NewEncoder(w).Encode(connz)
+	}))
+	defer ts.Close()
+
+	stdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	defer func() { os.Stdout = stdout }()
+
+	minVersions = map[string]string{"go": "1.31.0"}
+	defer func() { minVersions = nil }()
+
+	// Outdated client is beyond the connections polled with -n
+	engine := top.NewEngine("127.0.0.1", 8222, 1024, 1)
+	engine.HttpClient = &http.Client{}
+	engine.Uri = ts.URL
+	outdated, err := runAudit(engine)
+	if err != nil {
+		t.Fatalf("Failed auditing: %v", err)
+	}
+	if !outdated {
+		t.Fatalf("Expected outdated client beyond the first %d connections", engine.Conns)
+	}
+}